@@ -0,0 +1,430 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/backend"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/config"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/fabric"
+)
+
+type gatewayConn struct {
+	network *client.Network
+	close   func()
+}
+
+// connect opens the gateway connection configured for the daemon.
+func (c *ctl) connect() (*fabric.Backend, error) {
+	if c.backend != nil {
+		return c.backend, nil
+	}
+
+	if c.cfg.Daemon.Backend != config.BackendFabric {
+		return nil, fmt.Errorf("the %s backend keeps no ledger state", c.cfg.Daemon.Backend)
+	}
+
+	gw, closeGateway, err := fabric.Connect(&c.cfg.Fabric)
+	if err != nil {
+		return nil, err
+	}
+
+	network := gw.GetNetwork(c.cfg.Fabric.Channel)
+	c.gateway = &gatewayConn{network: network, close: closeGateway}
+	c.backend = fabric.NewBackend(network.GetContract(c.cfg.Fabric.Chaincode), c.cfg.Fabric.PrivateOwnership)
+	return c.backend, nil
+}
+
+// parseRef parses an inode reference in the form InodeRef.String prints.
+// Without a generation, the live generation of the inode is looked up.
+func (c *ctl) parseRef(s string) (common.InodeRef, error) {
+	fsUUID, rest, ok := strings.Cut(s, "/")
+	if !ok {
+		return common.InodeRef{}, fmt.Errorf("invalid inode %q, expected <fs>/<ino>[@<gen>]", s)
+	}
+	if _, err := common.ParseUUID(fsUUID); err != nil {
+		return common.InodeRef{}, err
+	}
+
+	inoString, genString, hasGen := strings.Cut(rest, "@")
+	ino, err := strconv.ParseUint(inoString, 10, 64)
+	if err != nil {
+		return common.InodeRef{}, fmt.Errorf("invalid inode number %q", inoString)
+	}
+
+	ref := common.InodeRef{FsUUID: fsUUID, Ino: ino}
+	if hasGen {
+		generation, err := strconv.ParseUint(genString, 10, 32)
+		if err != nil {
+			return common.InodeRef{}, fmt.Errorf("invalid generation %q", genString)
+		}
+		ref.Generation = uint32(generation)
+		return ref, nil
+	}
+
+	b, err := c.connect()
+	if err != nil {
+		return common.InodeRef{}, err
+	}
+
+	generations, err := b.ListAssetGenerations(fsUUID, ino)
+	if err != nil {
+		return common.InodeRef{}, err
+	}
+	for _, record := range generations {
+		if record.Deleted == nil {
+			ref.Generation = record.Generation
+			return ref, nil
+		}
+	}
+	return common.InodeRef{}, fmt.Errorf("inode %s/%d: %w", fsUUID, ino, backend.ErrNotFound)
+}
+
+func runGet(c *ctl, args []string) error {
+	fs, output := flags("get", "<fs>/<ino>[@<gen>]")
+	if err := parseFlags(fs, output, args); err != nil {
+		return err
+	}
+	if err := expectArgs(fs, 1); err != nil {
+		return err
+	}
+
+	ref, err := c.parseRef(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	b, err := c.connect()
+	if err != nil {
+		return err
+	}
+
+	attrs, err := b.ReadInode(ref)
+	if err != nil {
+		return err
+	}
+
+	if *output == "json" {
+		return writeJSON(attrs)
+	}
+
+	t := newTable()
+	t.row("INODE", ref.String())
+	t.row("MODE", formatMode(attrs.Mode))
+	t.row("UID", attrs.Uid)
+	t.row("GID", attrs.Gid)
+	t.row("SIZE", attrs.Size)
+	t.row("NLINK", attrs.Nlink)
+	t.row("BLOCKS", attrs.Blocks)
+	t.row("ATIME", formatTime(attrs.Atime))
+	t.row("MTIME", formatTime(attrs.Mtime))
+	t.row("CTIME", formatTime(attrs.Ctime))
+	t.row("CRTIME", formatTime(attrs.Crtime))
+	t.row("FLAGS", fmt.Sprintf("%#x", attrs.Flags))
+	t.row("PROJID", attrs.Projid)
+	return t.flush()
+}
+
+func runHistory(c *ctl, args []string) error {
+	fs, output := flags("history", "<fs>/<ino>[@<gen>]")
+	if err := parseFlags(fs, output, args); err != nil {
+		return err
+	}
+	if err := expectArgs(fs, 1); err != nil {
+		return err
+	}
+
+	ref, err := c.parseRef(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	b, err := c.connect()
+	if err != nil {
+		return err
+	}
+
+	history, err := b.InodeHistory(ref)
+	if err != nil {
+		return err
+	}
+
+	if *output == "json" {
+		return writeJSON(history)
+	}
+
+	t := newTable()
+	t.row("TIMESTAMP", "TRANSACTION", "MODE", "UID", "GID", "SIZE", "MTIME", "DELETED")
+	for _, entry := range history {
+		if entry.Attrs == nil {
+			t.row(entry.Timestamp.UTC().Format(time.RFC3339), entry.TxID, "-", "-", "-", "-", "-", "removed")
+			continue
+		}
+		t.row(entry.Timestamp.UTC().Format(time.RFC3339), entry.TxID, formatMode(entry.Attrs.Mode), entry.Attrs.Uid,
+			entry.Attrs.Gid, entry.Attrs.Size, formatTime(entry.Attrs.Mtime), formatTombstone(entry.Tombstone))
+	}
+	return t.flush()
+}
+
+// queries are the queries of the query command. Their arguments follow the
+// filesystem UUID.
+var queries = []struct {
+	name  string
+	args  string
+	usage string
+	nargs int
+}{
+	{"all", "", "every asset of the filesystem, tombstones included", 0},
+	{"uid", "<uid>", "live assets owned by uid", 1},
+	{"setuid", "", "live assets with the setuid bit", 0},
+	{"mtime", "<from> <to>", "live assets modified at or after from and before to (RFC 3339 or seconds)", 2},
+	{"immutable", "", "live assets with the immutable flag", 0},
+}
+
+func runQuery(c *ctl, args []string) error {
+	fs, output := flags("query", "<query> <fs> [args]")
+	pageSize := fs.Int("page-size", 100, "number of assets fetched per request")
+	defaultUsage := fs.Usage
+	fs.Usage = func() {
+		defaultUsage()
+		fmt.Fprintf(fs.Output(), "\nqueries:\n")
+		for _, q := range queries {
+			fmt.Fprintf(fs.Output(), "  %-10s %-12s %s\n", q.name, q.args, q.usage)
+		}
+	}
+	if err := parseFlags(fs, output, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return errors.New("missing query or filesystem")
+	}
+	if *pageSize <= 0 {
+		return fmt.Errorf("invalid page size %d", *pageSize)
+	}
+
+	name, fsUUID := fs.Arg(0), fs.Arg(1)
+	if _, err := common.ParseUUID(fsUUID); err != nil {
+		return err
+	}
+
+	nargs := -1
+	for _, q := range queries {
+		if q.name == name {
+			nargs = q.nargs
+		}
+	}
+	if nargs < 0 {
+		return fmt.Errorf("unknown query %q", name)
+	}
+	if err := expectArgs(fs, nargs+2); err != nil {
+		return err
+	}
+	queryArgs := fs.Args()[2:]
+
+	b, err := c.connect()
+	if err != nil {
+		return err
+	}
+
+	var page func(bookmark string) (*fabric.RecordPage, error)
+	size := int32(*pageSize)
+	switch name {
+	case "all":
+		page = func(bookmark string) (*fabric.RecordPage, error) {
+			return b.ListAssets(fsUUID, size, bookmark)
+		}
+	case "uid":
+		uid, err := strconv.ParseUint(queryArgs[0], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid uid %q", queryArgs[0])
+		}
+		page = func(bookmark string) (*fabric.RecordPage, error) {
+			return b.ListAssetsByUid(fsUUID, uint32(uid), size, bookmark)
+		}
+	case "setuid":
+		page = func(bookmark string) (*fabric.RecordPage, error) {
+			return b.ListSetuidAssets(fsUUID, size, bookmark)
+		}
+	case "mtime":
+		from, err := parseTime(queryArgs[0])
+		if err != nil {
+			return err
+		}
+		to, err := parseTime(queryArgs[1])
+		if err != nil {
+			return err
+		}
+		page = func(bookmark string) (*fabric.RecordPage, error) {
+			return b.ListAssetsModifiedBetween(fsUUID, from, to, size, bookmark)
+		}
+	}
+
+	var records []fabric.Record
+	if page == nil {
+		records, err = b.ListImmutableAssets(fsUUID)
+		if err != nil {
+			return err
+		}
+	} else {
+		bookmark := ""
+		for {
+			p, err := page(bookmark)
+			if err != nil {
+				return err
+			}
+			records = append(records, p.Records...)
+			if len(p.Records) < int(size) || p.Bookmark == "" {
+				break
+			}
+			bookmark = p.Bookmark
+		}
+	}
+
+	if *output == "json" {
+		return writeJSON(records)
+	}
+
+	t := newTable()
+	t.row("INO", "GEN", "MODE", "UID", "GID", "SIZE", "MTIME", "DELETED")
+	for _, r := range records {
+		t.row(r.Ino, r.Generation, formatMode(r.Mode), r.Uid, r.Gid, r.Size, formatTime(r.Mtime), formatTombstone(r.Deleted))
+	}
+	return t.flush()
+}
+
+type status struct {
+	MSPID     string                     `json:"mspId"`
+	Peer      string                     `json:"peer"`
+	Channel   string                     `json:"channel"`
+	Chaincode string                     `json:"chaincode"`
+	Chain     *fabric.ChainInfo          `json:"chain,omitempty"`
+	Daemon    map[string]json.RawMessage `json:"daemon,omitempty"`
+	Errors    []string                   `json:"errors,omitempty"`
+}
+
+func runStatus(c *ctl, args []string) error {
+	fs, output := flags("status", "")
+	if err := parseFlags(fs, output, args); err != nil {
+		return err
+	}
+	if err := expectArgs(fs, 0); err != nil {
+		return err
+	}
+
+	s := status{
+		MSPID:     c.cfg.Fabric.MSPID,
+		Peer:      c.cfg.Fabric.PeerEndpoint,
+		Channel:   c.cfg.Fabric.Channel,
+		Chaincode: c.cfg.Fabric.Chaincode,
+	}
+
+	if _, err := c.connect(); err != nil {
+		s.Errors = append(s.Errors, fmt.Sprintf("ledger: %v", err))
+	} else {
+		info, err := fabric.QueryChainInfo(c.gateway.network)
+		if err != nil {
+			s.Errors = append(s.Errors, fmt.Sprintf("channel: %v", err))
+		}
+		s.Chain = info
+
+		// Any query proves that the chaincode is installed and answers.
+		_, err = c.gateway.network.GetContract(c.cfg.Fabric.Chaincode).EvaluateTransaction("org.hyperledger.fabric:GetMetadata")
+		if err != nil {
+			s.Errors = append(s.Errors, fmt.Sprintf("chaincode: %v", err))
+		}
+	}
+
+	if c.cfg.API.Listen != "" {
+		stats, err := daemonStats(c.cfg.API.Listen)
+		if err != nil {
+			s.Errors = append(s.Errors, fmt.Sprintf("daemon: %v", err))
+		}
+		s.Daemon = stats
+	}
+
+	if *output == "json" {
+		if err := writeJSON(s); err != nil {
+			return err
+		}
+	} else {
+		t := newTable()
+		t.row("MSP", s.MSPID)
+		t.row("PEER", s.Peer)
+		t.row("CHANNEL", s.Channel)
+		t.row("CHAINCODE", s.Chaincode)
+		if s.Chain != nil {
+			t.row("HEIGHT", s.Chain.Height)
+		}
+		names := make([]string, 0, len(s.Daemon))
+		for name := range s.Daemon {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			t.row("DAEMON "+strings.ToUpper(name), string(s.Daemon[name]))
+		}
+		for _, e := range s.Errors {
+			t.row("ERROR", e)
+		}
+		if err := t.flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(s.Errors) > 0 {
+		return errors.New("unhealthy")
+	}
+	return nil
+}
+
+// daemonStats reads the statistics of the running daemon from its API.
+func daemonStats(listen string) (map[string]json.RawMessage, error) {
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+	url := "http://" + listen + "/stats"
+	if path, ok := strings.CutPrefix(listen, "unix:"); ok {
+		httpClient.Transport = &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.Dial("unix", path)
+			},
+		}
+		url = "http://daemon/stats"
+	}
+
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET /stats: %s", resp.Status)
+	}
+
+	var stats map[string]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("failed to parse /stats: %w", err)
+	}
+	return stats, nil
+}
+
+// parseTime accepts RFC 3339 times and seconds since the epoch.
+func parseTime(s string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 or seconds since the epoch", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/enroll"
+)
+
+// runEnroll creates ledger records for the inodes that existed before the
+// kernel module was loaded. Progress is printed after every batch, as one
+// JSON object per line with -o json.
+func runEnroll(c *ctl, args []string) error {
+	fs, output := flags("enroll", "<fs> [<path>]")
+	batchSize := fs.Int("batch-size", 500, "number of inodes created per transaction")
+	checkpoint := fs.String("checkpoint", "", "file to resume an interrupted enrollment from (default enroll-<fs>.checkpoint next to the events checkpoint)")
+	if err := parseFlags(fs, output, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 && fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("expected 1 or 2 arguments, got %d", fs.NArg())
+	}
+
+	fsUUID := fs.Arg(0)
+	if _, err := common.ParseUUID(fsUUID); err != nil {
+		return err
+	}
+
+	root := fs.Arg(1)
+	if root == "" {
+		root = c.cfg.Digest.Mounts[fsUUID]
+		if root == "" {
+			return fmt.Errorf("no mount configured for %s, pass its path", fsUUID)
+		}
+	}
+
+	if *checkpoint == "" {
+		dir := "."
+		if c.cfg.Events.CheckpointPath != "" {
+			dir = filepath.Dir(c.cfg.Events.CheckpointPath)
+		}
+		*checkpoint = filepath.Join(dir, "enroll-"+fsUUID+".checkpoint")
+	}
+
+	b, err := c.connect()
+	if err != nil {
+		return err
+	}
+
+	var report func(*enroll.Progress) error
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		report = func(p *enroll.Progress) error { return enc.Encode(p) }
+	} else {
+		report = func(p *enroll.Progress) error {
+			if p.Done {
+				return nil
+			}
+			_, err := fmt.Printf("batch %d: %d scanned, %d enrolled, %d existing, %d errors, at %s\n",
+				p.Batches, p.Scanned, p.Enrolled, p.Existing, p.Errors, p.LastPath)
+			return err
+		}
+	}
+
+	opts := enroll.Options{BatchSize: *batchSize, CheckpointPath: *checkpoint}
+	progress, err := enroll.Enroll(b, fsUUID, root, opts, report)
+	if err != nil && !errors.Is(err, enroll.ErrIncomplete) {
+		if progress != nil && progress.Batches > 0 {
+			fmt.Fprintf(os.Stderr, "enroll: run again to resume after %s\n", progress.LastPath)
+		}
+		return err
+	}
+
+	if *output == "table" {
+		if progress.ResumedAfter != "" {
+			fmt.Printf("resumed after %s\n", progress.ResumedAfter)
+		}
+		if progress.Retried > 0 {
+			fmt.Printf("retried %d entries that failed before\n", progress.Retried)
+		}
+		fmt.Printf("\n%d inodes scanned: %d enrolled, %d existing, %d errors\n",
+			progress.Scanned, progress.Enrolled, progress.Existing, progress.Errors)
+	}
+	return err
+}
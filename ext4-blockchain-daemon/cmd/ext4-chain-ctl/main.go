@@ -0,0 +1,134 @@
+// Command ext4-chain-ctl inspects the ledger state recorded by
+// ext4-chain-daemon. It reads the daemon's configuration and connects as
+// the daemon's identity.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/config"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/fabric"
+)
+
+type command struct {
+	name  string
+	args  string
+	usage string
+	run   func(c *ctl, args []string) error
+}
+
+var commands = []command{
+	{"get", "<fs>/<ino>[@<gen>]", "show the recorded attributes of an inode", runGet},
+	{"history", "<fs>/<ino>[@<gen>]", "show every recorded version of an inode", runHistory},
+	{"query", "<query> <fs> [args]", "list the assets matching a query, see query -h", runQuery},
+	{"status", "", "check the connection to the ledger and the daemon", runStatus},
+	{"scrub", "<fs> [<path>]", "compare a mounted filesystem with the ledger", runScrub},
+	{"enroll", "<fs> [<path>]", "record the existing inodes of a mounted filesystem", runEnroll},
+}
+
+// errDrift is returned by commands that found the ledger and the disk to
+// differ. It makes the command exit with exitDrift rather than 1.
+var errDrift = errors.New("the filesystem differs from the ledger")
+
+const exitDrift = 3
+
+func main() {
+	name := os.Args[0]
+	cfg, args, err := config.LoadArgs(name, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		usage(name)
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+		os.Exit(2)
+	}
+
+	if len(args) == 0 {
+		usage(name)
+		os.Exit(2)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+
+		c := &ctl{cfg: cfg}
+		defer c.close()
+		err := cmd.run(c, args[1:])
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", cmd.name, err)
+			c.close()
+			if errors.Is(err, errDrift) {
+				os.Exit(exitDrift)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+	usage(name)
+	os.Exit(2)
+}
+
+func usage(name string) {
+	fmt.Fprintf(os.Stderr, "usage: %s [daemon flags] <command> [-o table|json] [args]\n\ncommands:\n", name)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %-22s %s\n", cmd.name, cmd.args, cmd.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun %s -h for the daemon flags.\n", name)
+}
+
+// ctl holds the state shared by the commands. The gateway connection is
+// opened on first use.
+type ctl struct {
+	cfg     *config.Config
+	backend *fabric.Backend
+	gateway *gatewayConn
+}
+
+func (c *ctl) close() {
+	if c.gateway != nil {
+		c.gateway.close()
+		c.gateway = nil
+	}
+}
+
+// flags returns the flag set of a command, with the output format flag
+// every command takes.
+func flags(name, args string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	output := fs.String("o", "table", "output format: table or json")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs, output
+}
+
+func parseFlags(fs *flag.FlagSet, output *string, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+	return nil
+}
+
+func expectArgs(fs *flag.FlagSet, n int) error {
+	if fs.NArg() != n {
+		fs.Usage()
+		return fmt.Errorf("expected %d arguments, got %d", n, fs.NArg())
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/backend"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+)
+
+type table struct {
+	w *tabwriter.Writer
+}
+
+func newTable() *table {
+	return &table{w: tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)}
+}
+
+func (t *table) row(cells ...any) {
+	s := make([]string, len(cells))
+	for i, cell := range cells {
+		s[i] = fmt.Sprint(cell)
+	}
+	fmt.Fprintln(t.w, strings.Join(s, "\t"))
+}
+
+func (t *table) flush() error {
+	return t.w.Flush()
+}
+
+func writeJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// formatMode prints a mode the way ls does, followed by its octal value.
+func formatMode(mode uint32) string {
+	types := []struct {
+		mask uint32
+		c    byte
+	}{
+		{0o140000, 's'},
+		{0o120000, 'l'},
+		{0o100000, '-'},
+		{0o060000, 'b'},
+		{0o040000, 'd'},
+		{0o020000, 'c'},
+		{0o010000, 'p'},
+	}
+
+	b := []byte("?rwxrwxrwx")
+	for _, t := range types {
+		if mode&0o170000 == t.mask {
+			b[0] = t.c
+			break
+		}
+	}
+	for i := 0; i < 9; i++ {
+		if mode&(1<<(8-i)) == 0 {
+			b[i+1] = '-'
+		}
+	}
+
+	special := []struct {
+		bit uint32
+		pos int
+		set byte
+	}{
+		{0o4000, 3, 's'},
+		{0o2000, 6, 's'},
+		{0o1000, 9, 't'},
+	}
+	for _, s := range special {
+		if mode&s.bit == 0 {
+			continue
+		}
+		if b[s.pos] == '-' {
+			b[s.pos] = s.set - 'a' + 'A'
+		} else {
+			b[s.pos] = s.set
+		}
+	}
+
+	return fmt.Sprintf("%s (%06o)", b, mode)
+}
+
+func formatTime(t common.Time) string {
+	return time.Unix(t.Sec, int64(t.Nsec)).UTC().Format(time.RFC3339Nano)
+}
+
+func formatTombstone(t *backend.Tombstone) string {
+	if t == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%s by %s", t.Time.UTC().Format(time.RFC3339), t.MSPID)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/scrub"
+)
+
+// runScrub walks a mount and reports where it differs from the ledger. The
+// JSON output has one finding per line, followed by a line holding the
+// summary. Entries that could not be examined fail the command even when no
+// difference was found.
+func runScrub(c *ctl, args []string) error {
+	fs, output := flags("scrub", "<fs> [<path>]")
+	pageSize := fs.Int("page-size", 500, "number of records fetched per request")
+	atime := fs.Bool("atime", false, "compare access times too")
+	if err := parseFlags(fs, output, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 && fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("expected 1 or 2 arguments, got %d", fs.NArg())
+	}
+
+	fsUUID := fs.Arg(0)
+	if _, err := common.ParseUUID(fsUUID); err != nil {
+		return err
+	}
+
+	// The mount defaults to the one the daemon hashes files on.
+	root := fs.Arg(1)
+	if root == "" {
+		root = c.cfg.Digest.Mounts[fsUUID]
+		if root == "" {
+			return fmt.Errorf("no mount configured for %s, pass its path", fsUUID)
+		}
+	}
+
+	b, err := c.connect()
+	if err != nil {
+		return err
+	}
+
+	var report func(*scrub.Finding) error
+	var t *table
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		report = func(f *scrub.Finding) error { return enc.Encode(f) }
+	} else {
+		t = newTable()
+		t.row("KIND", "INO", "PATH", "FIELD", "LEDGER", "DISK")
+		report = func(f *scrub.Finding) error {
+			detail := f.Error
+			if detail == "" {
+				detail = formatValue(f.Field, f.Ledger)
+			}
+			t.row(f.Kind, f.Ref.Ino, orDash(f.Path), orDash(f.Field), orDash(detail), orDash(formatValue(f.Field, f.Disk)))
+			return nil
+		}
+	}
+
+	opts := scrub.Options{PageSize: int32(*pageSize), Atime: *atime}
+	summary, err := scrub.Scrub(b, fsUUID, root, opts, report)
+	if t != nil {
+		if flushErr := t.flush(); err == nil {
+			err = flushErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if *output == "json" {
+		if err := json.NewEncoder(os.Stdout).Encode(struct {
+			Summary *scrub.Summary `json:"summary"`
+		}{summary}); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("\n%d inodes, %d records: %d mismatches, %d missing, %d orphans, %d errors\n",
+			summary.Inodes, summary.Records, summary.Mismatches, summary.Missing, summary.Orphans, summary.Errors)
+		if !summary.Complete {
+			if summary.Errors > 0 {
+				fmt.Printf("some entries could not be read, orphans were not looked for\n")
+			} else {
+				fmt.Printf("%s is not the root of the filesystem, orphans were not looked for\n", root)
+			}
+		}
+	}
+
+	// A scrub that could not examine everything is not a clean one,
+	// drift or not.
+	if summary.Errors > 0 {
+		return fmt.Errorf("%d entries could not be examined", summary.Errors)
+	}
+	if summary.Drift() {
+		return errDrift
+	}
+	return nil
+}
+
+func formatValue(field string, v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case common.Time:
+		return formatTime(v)
+	case uint32:
+		if field == "mode" {
+			return formatMode(v)
+		}
+	}
+	return fmt.Sprint(v)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
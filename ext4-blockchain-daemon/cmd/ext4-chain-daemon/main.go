@@ -1,49 +1,83 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
+	"flag"
 	"log"
 	"os"
-	"time"
 
 	"github.com/hyperledger/fabric-gateway/pkg/client"
-	//"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+	"github.com/mdlayher/genetlink"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/api"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/backend"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/cache"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/config"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/digest"
 	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/ext4"
 	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/fabric"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/journal"
 )
 
 func main() {
-	clientConnection := fabric.NewGrpcConnection()
-	defer clientConnection.Close()
-
-	id := fabric.NewIdentity()
-	sign := fabric.NewSign()
-
-	gw, err := client.Connect(
-		id,
-		client.WithSign(sign),
-		client.WithClientConnection(clientConnection),
-		client.WithEvaluateTimeout(5*time.Second),
-		client.WithEndorseTimeout(15*time.Second),
-		client.WithSubmitTimeout(5*time.Second),
-		client.WithCommitStatusTimeout(30*time.Second),
-	)
+	cfg, err := config.Load(os.Args[0], os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
 	if err != nil {
-		log.Fatalf("failed to connect")
+		log.Fatalf("invalid configuration: %v", err)
 	}
-	defer gw.Close()
 
-	chaincodeName := "ext4"
-	if ccname := os.Getenv("CHAINCODE_NAME"); ccname != "" {
-		chaincodeName = ccname
+	var b backend.Backend
+	var network *client.Network
+	switch cfg.Daemon.Backend {
+	case config.BackendMemory:
+		log.Printf("using in-memory backend, records will not survive a restart")
+		b = backend.NewMemory()
+	default:
+		gw, closeGateway, err := fabric.Connect(&cfg.Fabric)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer closeGateway()
+
+		network = gw.GetNetwork(cfg.Fabric.Channel)
+		contract := network.GetContract(cfg.Fabric.Chaincode)
+		b = fabric.NewBackend(contract, cfg.Fabric.PrivateOwnership)
 	}
 
-	channelName := "mychannel"
-	if cname := os.Getenv("CHANNEL_NAME"); cname != "" {
-		channelName = cname
+	server := &api.Server{}
+	if history, ok := b.(backend.HistoryReader); ok {
+		server.History = history
+	}
+	if cfg.Journal.Path != "" {
+		j, err := journal.Open(b, &cfg.Journal)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer j.Close()
+		j.Start()
+		expvar.Publish("journal", expvar.Func(func() any { return j.Stats() }))
+		server.Journal = j
+		b = j
+	}
+	if cfg.Cache.Size > 0 {
+		c := cache.New(b, cfg.Cache.Size, cfg.Cache.TTL, cfg.Cache.NegativeTTL)
+		expvar.Publish("cache", expvar.Func(func() any { return c.Stats() }))
+		server.Cache = c
+		b = c
 	}
+	server.Backend = b
 
-	network := gw.GetNetwork(channelName)
-	contract := network.GetContract(chaincodeName)
+	if cfg.API.Listen != "" {
+		go func() {
+			if err := server.ListenAndServe(cfg.API.Listen); err != nil {
+				log.Printf("api: %v", err)
+			}
+		}()
+	}
 
 	connection, family, err := ext4.NewConn()
 	if err != nil {
@@ -51,5 +85,64 @@ func main() {
 	}
 	defer connection.Close()
 
-	err = ext4.Listen(connection, family, contract)
+	if network != nil && cfg.Events.Enabled {
+		checkpointer, err := fabric.NewCheckpointer(cfg.Events.CheckpointPath)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer checkpointer.Close()
+
+		handler := &eventHandler{
+			cache:        server.Cache,
+			conn:         connection,
+			family:       family,
+			notifyKernel: cfg.Events.NotifyKernel,
+		}
+		go fabric.ListenEvents(context.Background(), network, cfg.Fabric.Chaincode, checkpointer, handler.handle)
+	}
+
+	var closeWrite func(common.InodeRef)
+	if cfg.Digest.Enabled {
+		digests, err := digest.New(b, &cfg.Digest)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer digests.Close()
+		digests.Start(cfg.Digest.Workers)
+
+		switch cfg.Digest.Trigger {
+		case config.DigestTriggerFanotify:
+			if err := digests.WatchFanotify(); err != nil {
+				log.Fatalf("%v", err)
+			}
+		default:
+			closeWrite = digests.Request
+		}
+	}
+
+	err = ext4.Listen(connection, family, b, &cfg.Daemon, closeWrite)
+}
+
+// eventHandler reacts to changes committed to the ledger, including those
+// made by other daemons sharing the channel.
+type eventHandler struct {
+	cache        *cache.Cache
+	conn         *genetlink.Conn
+	family       genetlink.Family
+	notifyKernel bool
+}
+
+func (h *eventHandler) handle(event *fabric.AssetEvent) {
+	log.Printf("event: %s by %s in transaction %s", event.Type, event.Submitter.MSPID, event.TransactionID)
+	for _, change := range event.Changes {
+		if h.cache != nil {
+			h.cache.Invalidate(change.Ref)
+		}
+		if h.notifyKernel {
+			err := ext4.SendChangeNotification(h.conn, h.family, change.Ref)
+			if err != nil {
+				log.Printf("failed to send change notification: ino=%v: %v", change.Ref, err)
+			}
+		}
+	}
 }
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/backend"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/cache"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/journal"
+)
+
+// Server exposes the state of a running daemon over HTTP.
+type Server struct {
+	// Backend answers attribute lookups, normally through the cache.
+	Backend backend.Backend
+	// History reads the past versions of an inode; nil if the backend
+	// does not keep them.
+	History backend.HistoryReader
+	// Cache is the attribute cache in use, if any.
+	Cache *cache.Cache
+	// Journal holds the changes waiting for the ledger, if journaling is
+	// enabled.
+	Journal *journal.Journal
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /filesystems/{fs}/inodes/{ino}/generations/{gen}", s.inode)
+	mux.HandleFunc("GET /filesystems/{fs}/inodes/{ino}/generations/{gen}/history", s.history)
+	mux.HandleFunc("GET /filesystems/{fs}/inodes/{ino}/generations/{gen}/xattrs/{name}/history", s.xattrHistory)
+	mux.HandleFunc("GET /stats", s.stats)
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	return mux
+}
+
+// ListenAndServe serves the API on addr, which is either a TCP address or a
+// unix socket path prefixed with "unix:".
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("api: listening on %s", addr)
+	return http.Serve(l, s.Handler())
+}
+
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	// A socket left behind by a previous instance would make Listen fail.
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return net.Listen("unix", path)
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, backend.ErrNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, backend.ErrPermissionDenied):
+		code = http.StatusForbidden
+	}
+	writeJSON(w, code, errorResponse{Error: err.Error()})
+}
+
+func parseInodeRef(w http.ResponseWriter, r *http.Request) (common.InodeRef, bool) {
+	fsUUID := r.PathValue("fs")
+	if _, err := common.ParseUUID(fsUUID); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return common.InodeRef{}, false
+	}
+
+	ino, err := strconv.ParseUint(r.PathValue("ino"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid inode number"})
+		return common.InodeRef{}, false
+	}
+
+	generation, err := strconv.ParseUint(r.PathValue("gen"), 10, 32)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid generation"})
+		return common.InodeRef{}, false
+	}
+	return common.InodeRef{FsUUID: fsUUID, Ino: ino, Generation: uint32(generation)}, true
+}
+
+func (s *Server) inode(w http.ResponseWriter, r *http.Request) {
+	ref, ok := parseInodeRef(w, r)
+	if !ok {
+		return
+	}
+	if s.Backend == nil {
+		writeJSON(w, http.StatusNotImplemented, errorResponse{Error: "no backend"})
+		return
+	}
+
+	attrs, err := s.Backend.ReadInode(ref)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, attrs)
+}
+
+func (s *Server) history(w http.ResponseWriter, r *http.Request) {
+	ref, ok := parseInodeRef(w, r)
+	if !ok {
+		return
+	}
+	if s.History == nil {
+		writeJSON(w, http.StatusNotImplemented, errorResponse{Error: "backend does not keep history"})
+		return
+	}
+
+	history, err := s.History.InodeHistory(ref)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+func (s *Server) xattrHistory(w http.ResponseWriter, r *http.Request) {
+	ref, ok := parseInodeRef(w, r)
+	if !ok {
+		return
+	}
+	if s.History == nil {
+		writeJSON(w, http.StatusNotImplemented, errorResponse{Error: "backend does not keep history"})
+		return
+	}
+
+	history, err := s.History.XattrHistory(ref, r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+type statsResponse struct {
+	Cache   *cache.Stats   `json:"cache,omitempty"`
+	Journal *journal.Stats `json:"journal,omitempty"`
+}
+
+func (s *Server) stats(w http.ResponseWriter, r *http.Request) {
+	var resp statsResponse
+	if s.Cache != nil {
+		stats := s.Cache.Stats()
+		resp.Cache = &stats
+	}
+	if s.Journal != nil {
+		stats := s.Journal.Stats()
+		resp.Journal = &stats
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("api: failed to write response: %v", err)
+	}
+}
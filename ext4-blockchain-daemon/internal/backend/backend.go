@@ -0,0 +1,97 @@
+package backend
+
+import (
+	"errors"
+	"time"
+
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+)
+
+// Backend records inode attributes on a ledger. Implementations must be safe
+// for concurrent use.
+type Backend interface {
+	CreateInode(attrs *common.Attrs) error
+	UpdateInode(attrs *common.Attrs) error
+	// UpdateInodes applies several updates at once. Updates for inodes
+	// that do not exist are skipped and their numbers returned.
+	UpdateInodes(updates []*common.Attrs) (missing []common.InodeRef, err error)
+	ReadInode(ref common.InodeRef) (*common.Attrs, error)
+	// DeleteInode marks the inode as deleted. The record stays on the
+	// ledger as a tombstone, and the inode number may be created again
+	// afterwards.
+	DeleteInode(ref common.InodeRef) error
+	// SetXattr records the new value of an extended attribute.
+	SetXattr(x *common.Xattr) error
+	// RemoveXattr records the removal of an extended attribute. The
+	// removal is recorded even if the attribute was never set through
+	// the daemon.
+	RemoveXattr(ref common.InodeRef, name string) error
+	// ChangeDentry records a change of the directory entries of an
+	// inode. A rename is applied as a whole or not at all.
+	ChangeDentry(change *common.DentryChange) error
+	// RecordDigest records the content digest of a regular file.
+	RecordDigest(ref common.InodeRef, digest *common.ContentDigest) error
+}
+
+// HistoryEntry is one recorded version of an inode.
+type HistoryEntry struct {
+	TxID      string        `json:"txId"`
+	Timestamp time.Time     `json:"timestamp"`
+	IsDelete  bool          `json:"isDelete"`
+	Attrs     *common.Attrs `json:"attrs,omitempty"`
+	// Tombstone is set on the version recording the deletion of the
+	// inode.
+	Tombstone *Tombstone `json:"tombstone,omitempty"`
+}
+
+type Tombstone struct {
+	Time      time.Time `json:"time"`
+	MSPID     string    `json:"mspId"`
+	Submitter string    `json:"submitter"`
+}
+
+// XattrHistoryEntry is one recorded version of an extended attribute.
+type XattrHistoryEntry struct {
+	TxID      string        `json:"txId"`
+	Timestamp time.Time     `json:"timestamp"`
+	Xattr     *common.Xattr `json:"xattr"`
+	// Removed is set on the version recording the removal of the
+	// attribute.
+	Removed *Tombstone `json:"removed,omitempty"`
+}
+
+// HistoryReader is implemented by backends that keep every version of an
+// inode record.
+type HistoryReader interface {
+	// InodeHistory returns the versions of the inode, newest first.
+	InodeHistory(ref common.InodeRef) ([]HistoryEntry, error)
+	// XattrHistory returns the versions of an extended attribute of the
+	// inode, newest first.
+	XattrHistory(ref common.InodeRef, name string) ([]XattrHistoryEntry, error)
+}
+
+var (
+	ErrNotFound = errors.New("inode not found")
+	ErrExists   = errors.New("inode already exists")
+	// ErrPermissionDenied is returned when the daemon's identity may not
+	// change the record of an inode created by another identity.
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrUnavailable is returned when the ledger could not be reached.
+	// The change may or may not have been recorded.
+	ErrUnavailable = errors.New("ledger unavailable")
+)
+
+// Status maps the result of a Backend call to the status reported to the
+// kernel.
+func Status(err error) uint16 {
+	switch {
+	case err == nil:
+		return common.EXT4BD_STATUS_SUCCESS
+	case errors.Is(err, ErrNotFound):
+		return common.EXT4BD_STATUS_INODE_NOT_FOUND
+	case errors.Is(err, ErrPermissionDenied):
+		return common.EXT4BD_STATUS_PERMISSION_DENIED
+	default:
+		return common.EXT4BD_STATUS_FAIL
+	}
+}
@@ -0,0 +1,222 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+)
+
+// Memory is a Backend keeping the records in process memory. It follows the
+// semantics of the chaincode and lets the daemon run without a Fabric
+// network; everything is lost when the daemon exits.
+type Memory struct {
+	mu           sync.RWMutex
+	inodes       map[common.InodeRef]common.Attrs
+	history      map[common.InodeRef][]HistoryEntry
+	xattrHistory map[xattrKey][]XattrHistoryEntry
+	dentries     map[common.Dentry]common.InodeRef
+	digests      map[common.InodeRef]common.ContentDigest
+	txID         uint64
+}
+
+type xattrKey struct {
+	ref  common.InodeRef
+	name string
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		inodes:       make(map[common.InodeRef]common.Attrs),
+		history:      make(map[common.InodeRef][]HistoryEntry),
+		xattrHistory: make(map[xattrKey][]XattrHistoryEntry),
+		dentries:     make(map[common.Dentry]common.InodeRef),
+		digests:      make(map[common.InodeRef]common.ContentDigest),
+	}
+}
+
+// put stores attrs and records the new version; m.mu must be held.
+func (m *Memory) put(attrs common.Attrs) {
+	m.inodes[attrs.Ref()] = attrs
+	m.record(attrs.Ref(), HistoryEntry{Attrs: &attrs})
+}
+
+// record appends a version of ref to its history; m.mu must be held.
+func (m *Memory) record(ref common.InodeRef, entry HistoryEntry) {
+	entry.TxID = m.nextTxID()
+	entry.Timestamp = time.Now()
+	m.history[ref] = append(m.history[ref], entry)
+}
+
+// nextTxID returns a new synthetic transaction ID; m.mu must be held.
+func (m *Memory) nextTxID() string {
+	m.txID++
+	return fmt.Sprintf("memory-%d", m.txID)
+}
+
+// recordXattr appends a version of an extended attribute to its history;
+// m.mu must be held.
+func (m *Memory) recordXattr(x *common.Xattr, removed *Tombstone) error {
+	if _, ok := m.inodes[x.Ref]; !ok {
+		return fmt.Errorf("inode %v: %w", x.Ref, ErrNotFound)
+	}
+
+	key := xattrKey{ref: x.Ref, name: x.Name}
+	m.xattrHistory[key] = append(m.xattrHistory[key], XattrHistoryEntry{
+		TxID:      m.nextTxID(),
+		Timestamp: time.Now(),
+		Xattr:     x,
+		Removed:   removed,
+	})
+	return nil
+}
+
+func (m *Memory) CreateInode(attrs *common.Attrs) error {
+	log.Printf("memory: CreateInode %v", attrs.Ref())
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.inodes[attrs.Ref()]; ok {
+		return fmt.Errorf("inode %v: %w", attrs.Ref(), ErrExists)
+	}
+	m.put(*attrs)
+	return nil
+}
+
+func (m *Memory) UpdateInode(attrs *common.Attrs) error {
+	log.Printf("memory: UpdateInode %v", attrs.Ref())
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.inodes[attrs.Ref()]
+	if !ok {
+		return fmt.Errorf("inode %v: %w", attrs.Ref(), ErrNotFound)
+	}
+	current.Merge(attrs)
+	m.put(current)
+	return nil
+}
+
+func (m *Memory) UpdateInodes(updates []*common.Attrs) ([]common.InodeRef, error) {
+	var missing []common.InodeRef
+	for _, attrs := range updates {
+		err := m.UpdateInode(attrs)
+		if errors.Is(err, ErrNotFound) {
+			missing = append(missing, attrs.Ref())
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+func (m *Memory) ReadInode(ref common.InodeRef) (*common.Attrs, error) {
+	log.Printf("memory: ReadInode %v", ref)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	attrs, ok := m.inodes[ref]
+	if !ok {
+		return nil, fmt.Errorf("inode %v: %w", ref, ErrNotFound)
+	}
+	return &attrs, nil
+}
+
+func (m *Memory) DeleteInode(ref common.InodeRef) error {
+	log.Printf("memory: DeleteInode %v", ref)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attrs, ok := m.inodes[ref]
+	if !ok {
+		return fmt.Errorf("inode %v: %w", ref, ErrNotFound)
+	}
+	delete(m.inodes, ref)
+	m.record(ref, HistoryEntry{
+		Attrs:     &attrs,
+		Tombstone: &Tombstone{Time: time.Now()},
+	})
+	return nil
+}
+
+func (m *Memory) SetXattr(x *common.Xattr) error {
+	log.Printf("memory: SetXattr %v %s", x.Ref, x.Name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.recordXattr(x, nil)
+}
+
+func (m *Memory) RemoveXattr(ref common.InodeRef, name string) error {
+	log.Printf("memory: RemoveXattr %v %s", ref, name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.recordXattr(&common.Xattr{Ref: ref, Name: name}, &Tombstone{Time: time.Now()})
+}
+
+// ChangeDentry follows the chaincode: the inode must exist, while removing
+// an entry that was never recorded is not an error.
+func (m *Memory) ChangeDentry(change *common.DentryChange) error {
+	log.Printf("memory: ChangeDentry %v op=%d", change.Ref, change.Op)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.inodes[change.Ref]; !ok {
+		return fmt.Errorf("inode %v: %w", change.Ref, ErrNotFound)
+	}
+	if change.Old != nil {
+		delete(m.dentries, *change.Old)
+	}
+	if change.New != nil {
+		m.dentries[*change.New] = change.Ref
+	}
+	return nil
+}
+
+func (m *Memory) RecordDigest(ref common.InodeRef, digest *common.ContentDigest) error {
+	log.Printf("memory: RecordDigest %v %s", ref, digest.Algorithm)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.inodes[ref]; !ok {
+		return fmt.Errorf("inode %v: %w", ref, ErrNotFound)
+	}
+	m.digests[ref] = *digest
+	return nil
+}
+
+func (m *Memory) InodeHistory(ref common.InodeRef) ([]HistoryEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	versions := m.history[ref]
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("inode %v: %w", ref, ErrNotFound)
+	}
+
+	history := make([]HistoryEntry, len(versions))
+	for i, entry := range versions {
+		history[len(versions)-1-i] = entry
+	}
+	return history, nil
+}
+
+func (m *Memory) XattrHistory(ref common.InodeRef, name string) ([]XattrHistoryEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	versions := m.xattrHistory[xattrKey{ref: ref, name: name}]
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("xattr %s of inode %v: %w", name, ref, ErrNotFound)
+	}
+
+	history := make([]XattrHistoryEntry, len(versions))
+	for i, entry := range versions {
+		history[len(versions)-1-i] = entry
+	}
+	return history, nil
+}
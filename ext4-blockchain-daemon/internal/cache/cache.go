@@ -0,0 +1,249 @@
+package cache
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/backend"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+)
+
+// Cache is a Backend that keeps recently read inodes in memory, so that
+// GETATTR requests do not have to evaluate a transaction every time.
+//
+// Reads go through the cache; inodes reported as missing by the backend are
+// remembered for negativeTTL. Successful creates and updates are written
+// through to the cache. Entries are evicted in least recently used order once
+// the cache holds size inodes.
+type Cache struct {
+	backend.Backend
+
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[common.InodeRef]*list.Element
+
+	hits         atomic.Uint64
+	negativeHits atomic.Uint64
+	misses       atomic.Uint64
+	evictions    atomic.Uint64
+	expirations  atomic.Uint64
+}
+
+type entry struct {
+	ref common.InodeRef
+	// attrs is nil for inodes known not to exist.
+	attrs   *common.Attrs
+	expires time.Time
+}
+
+// Stats is a snapshot of the cache counters.
+type Stats struct {
+	Size         int    `json:"size"`
+	Capacity     int    `json:"capacity"`
+	Hits         uint64 `json:"hits"`
+	NegativeHits uint64 `json:"negativeHits"`
+	Misses       uint64 `json:"misses"`
+	Evictions    uint64 `json:"evictions"`
+	Expirations  uint64 `json:"expirations"`
+}
+
+func New(b backend.Backend, size int, ttl, negativeTTL time.Duration) *Cache {
+	return &Cache{
+		Backend:     b,
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		lru:         list.New(),
+		entries:     make(map[common.InodeRef]*list.Element),
+	}
+}
+
+func (c *Cache) CreateInode(attrs *common.Attrs) error {
+	err := c.Backend.CreateInode(attrs)
+	if err == nil {
+		c.store(attrs.Ref(), attrs)
+	}
+	return err
+}
+
+func (c *Cache) UpdateInode(attrs *common.Attrs) error {
+	err := c.Backend.UpdateInode(attrs)
+	switch {
+	case err == nil:
+		c.update(attrs)
+	case errors.Is(err, backend.ErrNotFound):
+		c.store(attrs.Ref(), nil)
+	}
+	return err
+}
+
+func (c *Cache) UpdateInodes(updates []*common.Attrs) ([]common.InodeRef, error) {
+	missing, err := c.Backend.UpdateInodes(updates)
+	if err != nil {
+		return nil, err
+	}
+
+	skipped := make(map[common.InodeRef]bool, len(missing))
+	for _, ref := range missing {
+		skipped[ref] = true
+		c.store(ref, nil)
+	}
+	for _, attrs := range updates {
+		if !skipped[attrs.Ref()] {
+			c.update(attrs)
+		}
+	}
+	return missing, nil
+}
+
+func (c *Cache) DeleteInode(ref common.InodeRef) error {
+	err := c.Backend.DeleteInode(ref)
+	if err == nil || errors.Is(err, backend.ErrNotFound) {
+		c.store(ref, nil)
+	}
+	return err
+}
+
+func (c *Cache) ReadInode(ref common.InodeRef) (*common.Attrs, error) {
+	if attrs, found, ok := c.lookup(ref); ok {
+		if !found {
+			c.negativeHits.Add(1)
+			return nil, backend.ErrNotFound
+		}
+		c.hits.Add(1)
+		return attrs, nil
+	}
+	c.misses.Add(1)
+
+	attrs, err := c.Backend.ReadInode(ref)
+	switch {
+	case err == nil:
+		c.store(ref, attrs)
+	case errors.Is(err, backend.ErrNotFound):
+		c.store(ref, nil)
+	}
+	return attrs, err
+}
+
+// Invalidate drops the cached state of an inode, for example after it was
+// changed by another daemon.
+func (c *Cache) Invalidate(ref common.InodeRef) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[ref]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	size := c.lru.Len()
+	c.mu.Unlock()
+
+	return Stats{
+		Size:         size,
+		Capacity:     c.size,
+		Hits:         c.hits.Load(),
+		NegativeHits: c.negativeHits.Load(),
+		Misses:       c.misses.Load(),
+		Evictions:    c.evictions.Load(),
+		Expirations:  c.expirations.Load(),
+	}
+}
+
+// lookup returns a copy of the cached attributes of an inode. ok reports whether
+// a live entry was found, found whether the inode exists.
+func (c *Cache) lookup(ref common.InodeRef) (attrs *common.Attrs, found bool, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[ref]
+	if !ok {
+		return nil, false, false
+	}
+
+	e := el.Value.(*entry)
+	if time.Now().After(e.expires) {
+		c.remove(el)
+		c.expirations.Add(1)
+		return nil, false, false
+	}
+
+	c.lru.MoveToFront(el)
+	if e.attrs == nil {
+		return nil, false, true
+	}
+	copied := *e.attrs
+	return &copied, true, true
+}
+
+// update merges a successfully applied update into the cached attributes.
+// Without a cached copy there is nothing to merge into, and a negative entry
+// is stale now, so the inode is dropped and read again on the next miss.
+func (c *Cache) update(attrs *common.Attrs) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[attrs.Ref()]
+	if !ok {
+		return
+	}
+
+	e := el.Value.(*entry)
+	if e.attrs == nil {
+		c.remove(el)
+		return
+	}
+	e.attrs.Merge(attrs)
+	e.expires = time.Now().Add(c.ttl)
+	c.lru.MoveToFront(el)
+}
+
+// store caches attrs for an inode; nil records that the inode does not exist.
+func (c *Cache) store(ref common.InodeRef, attrs *common.Attrs) {
+	if c.size <= 0 {
+		return
+	}
+
+	ttl := c.ttl
+	if attrs == nil {
+		ttl = c.negativeTTL
+	} else {
+		copied := *attrs
+		attrs = &copied
+	}
+	if ttl <= 0 {
+		c.Invalidate(ref)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[ref]; ok {
+		e := el.Value.(*entry)
+		e.attrs = attrs
+		e.expires = time.Now().Add(ttl)
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[ref] = c.lru.PushFront(&entry{ref: ref, attrs: attrs, expires: time.Now().Add(ttl)})
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+		c.evictions.Add(1)
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*entry).ref)
+}
@@ -0,0 +1,240 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/backend"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+)
+
+const testUUID = "0f0e0d0c-0b0a-0908-0706-050403020100"
+
+// counter counts the reads reaching the backend.
+type counter struct {
+	*backend.Memory
+	reads int
+}
+
+func (c *counter) ReadInode(ref common.InodeRef) (*common.Attrs, error) {
+	c.reads++
+	return c.Memory.ReadInode(ref)
+}
+
+func newTestCache(size int, ttl, negativeTTL time.Duration) (*Cache, *counter) {
+	b := &counter{Memory: backend.NewMemory()}
+	return New(b, size, ttl, negativeTTL), b
+}
+
+func attrs(ino uint64) *common.Attrs {
+	return &common.Attrs{
+		FsUUID:     testUUID,
+		Ino:        ino,
+		Generation: 1,
+		Uid:        1000,
+		Mode:       0o100644,
+		Valid:      common.EXT4B_VALID_ALL,
+	}
+}
+
+func ref(ino uint64) common.InodeRef {
+	return common.InodeRef{FsUUID: testUUID, Ino: ino, Generation: 1}
+}
+
+func mustRead(t *testing.T, c *Cache, ino uint64) *common.Attrs {
+	t.Helper()
+	a, err := c.ReadInode(ref(ino))
+	if err != nil {
+		t.Fatalf("ReadInode(%d): %v", ino, err)
+	}
+	return a
+}
+
+func TestCreateIsWrittenThrough(t *testing.T) {
+	c, b := newTestCache(10, time.Hour, time.Hour)
+	if err := c.CreateInode(attrs(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	if a := mustRead(t, c, 1); a.Uid != 1000 {
+		t.Errorf("uid = %d, want 1000", a.Uid)
+	}
+	if b.reads != 0 {
+		t.Errorf("%d reads reached the backend, want 0", b.reads)
+	}
+	if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestReadReturnsCopy(t *testing.T) {
+	c, _ := newTestCache(10, time.Hour, time.Hour)
+	if err := c.CreateInode(attrs(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	mustRead(t, c, 1).Uid = 0
+	if a := mustRead(t, c, 1); a.Uid != 1000 {
+		t.Errorf("cached uid changed through a returned copy: %d", a.Uid)
+	}
+}
+
+func TestUpdateMergesIntoCachedCopy(t *testing.T) {
+	c, b := newTestCache(10, time.Hour, time.Hour)
+	if err := c.CreateInode(attrs(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	update := &common.Attrs{FsUUID: testUUID, Ino: 1, Generation: 1, Uid: 0, Mode: 0o100600, Valid: common.EXT4B_VALID_MODE}
+	if err := c.UpdateInode(update); err != nil {
+		t.Fatal(err)
+	}
+
+	a := mustRead(t, c, 1)
+	if a.Mode != 0o100600 || a.Uid != 1000 {
+		t.Errorf("mode %o, uid %d; want 100600 and the unchanged 1000", a.Mode, a.Uid)
+	}
+	if b.reads != 0 {
+		t.Errorf("%d reads reached the backend, want 0", b.reads)
+	}
+}
+
+func TestBatchUpdateRemembersMissingInodes(t *testing.T) {
+	c, b := newTestCache(10, time.Hour, time.Hour)
+	if err := c.CreateInode(attrs(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	update := &common.Attrs{FsUUID: testUUID, Ino: 1, Generation: 1, Uid: 7, Valid: common.EXT4B_VALID_UID}
+	missing := &common.Attrs{FsUUID: testUUID, Ino: 2, Generation: 1, Uid: 7, Valid: common.EXT4B_VALID_UID}
+	refs, err := c.UpdateInodes([]*common.Attrs{update, missing})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0] != ref(2) {
+		t.Fatalf("missing = %v, want inode 2", refs)
+	}
+
+	if a := mustRead(t, c, 1); a.Uid != 7 {
+		t.Errorf("uid = %d, want 7", a.Uid)
+	}
+	if _, err := c.ReadInode(ref(2)); !errors.Is(err, backend.ErrNotFound) {
+		t.Errorf("ReadInode(2) = %v, want ErrNotFound", err)
+	}
+	if b.reads != 0 {
+		t.Errorf("%d reads reached the backend, want 0", b.reads)
+	}
+}
+
+func TestNegativeEntries(t *testing.T) {
+	c, b := newTestCache(10, time.Hour, time.Hour)
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.ReadInode(ref(1)); !errors.Is(err, backend.ErrNotFound) {
+			t.Fatalf("ReadInode = %v, want ErrNotFound", err)
+		}
+	}
+	if b.reads != 1 {
+		t.Errorf("%d reads reached the backend, want 1", b.reads)
+	}
+	if stats := c.Stats(); stats.NegativeHits != 1 || stats.Misses != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	// Creating the inode replaces the negative entry.
+	if err := c.CreateInode(attrs(1)); err != nil {
+		t.Fatal(err)
+	}
+	mustRead(t, c, 1)
+	if b.reads != 1 {
+		t.Errorf("%d reads reached the backend, want 1", b.reads)
+	}
+}
+
+func TestDeleteCachesAbsence(t *testing.T) {
+	c, b := newTestCache(10, time.Hour, time.Hour)
+	if err := c.CreateInode(attrs(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteInode(ref(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.ReadInode(ref(1)); !errors.Is(err, backend.ErrNotFound) {
+		t.Errorf("ReadInode = %v, want ErrNotFound", err)
+	}
+	if b.reads != 0 {
+		t.Errorf("%d reads reached the backend, want 0", b.reads)
+	}
+}
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	c, b := newTestCache(2, time.Hour, time.Hour)
+	for ino := uint64(1); ino <= 2; ino++ {
+		if err := c.CreateInode(attrs(ino)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reading 1 makes 2 the least recently used.
+	mustRead(t, c, 1)
+	if err := c.CreateInode(attrs(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	mustRead(t, c, 1)
+	mustRead(t, c, 3)
+	if b.reads != 0 {
+		t.Fatalf("%d reads reached the backend, want 0", b.reads)
+	}
+	mustRead(t, c, 2)
+	if b.reads != 1 {
+		t.Errorf("%d reads reached the backend, want 1 for the evicted inode", b.reads)
+	}
+	if stats := c.Stats(); stats.Size != 2 || stats.Evictions != 2 {
+		t.Errorf("stats = %+v, want 2 entries and 2 evictions", stats)
+	}
+}
+
+func TestEntriesExpire(t *testing.T) {
+	c, b := newTestCache(10, time.Millisecond, time.Hour)
+	if err := c.CreateInode(attrs(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	mustRead(t, c, 1)
+	if b.reads != 1 {
+		t.Errorf("%d reads reached the backend, want 1", b.reads)
+	}
+	if stats := c.Stats(); stats.Expirations != 1 {
+		t.Errorf("stats = %+v, want 1 expiration", stats)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	c, b := newTestCache(10, time.Hour, time.Hour)
+	if err := c.CreateInode(attrs(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Invalidate(ref(1))
+	mustRead(t, c, 1)
+	if b.reads != 1 {
+		t.Errorf("%d reads reached the backend, want 1", b.reads)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	c, b := newTestCache(0, time.Hour, time.Hour)
+	if err := c.CreateInode(attrs(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	mustRead(t, c, 1)
+	mustRead(t, c, 1)
+	if b.reads != 2 {
+		t.Errorf("%d reads reached the backend, want 2", b.reads)
+	}
+}
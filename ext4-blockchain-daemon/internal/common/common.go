@@ -1,30 +1,129 @@
 package common
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"strings"
+
 	"github.com/mdlayher/netlink"
 )
 
+// Time is a timestamp as ext4 stores it. Seconds are signed so that times
+// before 1970 can be represented.
 type Time struct {
-	Sec  uint64
-	Nsec uint32
+	Sec  int64  `json:"sec"`
+	Nsec uint32 `json:"nsec"`
 }
 
 type Attrs struct {
-	Uid   uint32
-	Gid   uint32
-	Atime Time
-	Mtime Time
-	Ctime Time
-	Mode  uint32
-	Ino   uint64
+	Uid        uint32 `json:"uid"`
+	Gid        uint32 `json:"gid"`
+	Atime      Time   `json:"atime"`
+	Mtime      Time   `json:"mtime"`
+	Ctime      Time   `json:"ctime"`
+	Mode       uint32 `json:"mode"`
+	Ino        uint64 `json:"ino"`
+	FsUUID     string `json:"fsUuid"`
+	Generation uint32 `json:"generation"`
+	Size       uint64 `json:"size"`
+	Nlink      uint32 `json:"nlink"`
+	// Blocks is i_blocks, counted in 512-byte sectors.
+	Blocks uint64 `json:"blocks"`
+	// Crtime is ext4's i_crtime, the birth time of the inode.
+	Crtime Time `json:"crtime"`
+	// Flags are the ext4 inode flags (EXT4_*_FL) as chattr sets them.
+	Flags  uint32 `json:"flags"`
+	Projid uint32 `json:"projid"`
+	// Valid is a mask of EXT4B_VALID_* bits telling which of the fields
+	// above hold a value. Fields without their bit are left unchanged by
+	// updates, so zero is a legal value for every field.
+	Valid uint32 `json:"valid"`
+}
+
+// InodeRef identifies an inode across filesystems and across reuse of its
+// number: inode numbers are only unique within the filesystem with the given
+// superblock UUID, and ext4 bumps i_generation whenever it recycles one.
+type InodeRef struct {
+	FsUUID     string `json:"fsUuid"`
+	Ino        uint64 `json:"ino"`
+	Generation uint32 `json:"generation"`
+}
+
+func (r InodeRef) String() string {
+	return fmt.Sprintf("%s/%d@%d", r.FsUUID, r.Ino, r.Generation)
+}
+
+func (a *Attrs) Ref() InodeRef {
+	return InodeRef{FsUUID: a.FsUUID, Ino: a.Ino, Generation: a.Generation}
+}
+
+// FormatUUID formats the 16 bytes of a superblock UUID in the usual
+// 8-4-4-4-12 hexadecimal form.
+func FormatUUID(b []byte) (string, error) {
+	if len(b) != 16 {
+		return "", fmt.Errorf("invalid filesystem UUID length %d", len(b))
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// ParseUUID is the inverse of FormatUUID.
+func ParseUUID(s string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != 16 || len(s) != 36 {
+		return nil, fmt.Errorf("invalid filesystem UUID %q", s)
+	}
+	return b, nil
+}
+
+// Merge applies update to a the way the chaincode's UpdateAsset does: only
+// the fields marked valid in update are copied.
+func (a *Attrs) Merge(update *Attrs) {
+	if update.Valid&EXT4B_VALID_UID != 0 {
+		a.Uid = update.Uid
+	}
+	if update.Valid&EXT4B_VALID_GID != 0 {
+		a.Gid = update.Gid
+	}
+	if update.Valid&EXT4B_VALID_ATIME != 0 {
+		a.Atime = update.Atime
+	}
+	if update.Valid&EXT4B_VALID_MTIME != 0 {
+		a.Mtime = update.Mtime
+	}
+	if update.Valid&EXT4B_VALID_CTIME != 0 {
+		a.Ctime = update.Ctime
+	}
+	if update.Valid&EXT4B_VALID_MODE != 0 {
+		a.Mode = update.Mode
+	}
+	if update.Valid&EXT4B_VALID_SIZE != 0 {
+		a.Size = update.Size
+	}
+	if update.Valid&EXT4B_VALID_NLINK != 0 {
+		a.Nlink = update.Nlink
+	}
+	if update.Valid&EXT4B_VALID_BLOCKS != 0 {
+		a.Blocks = update.Blocks
+	}
+	if update.Valid&EXT4B_VALID_CRTIME != 0 {
+		a.Crtime = update.Crtime
+	}
+	if update.Valid&EXT4B_VALID_FLAGS != 0 {
+		a.Flags = update.Flags
+	}
+	if update.Valid&EXT4B_VALID_PROJID != 0 {
+		a.Projid = update.Projid
+	}
+	a.Valid |= update.Valid
 }
 
 func (n *Time) DecodeTime(ad *netlink.AttributeDecoder) error {
 	for ad.Next() {
 		switch ad.Type() {
 		case EXT4B_TIME_ATTR_SEC:
-			n.Sec = ad.Uint64()
+			n.Sec = ad.Int64()
 		case EXT4B_TIME_ATTR_NSEC:
 			n.Nsec = ad.Uint32()
 		}
@@ -38,43 +137,278 @@ func DecodeAttributes(data []byte) (*Attrs, error) {
 		return nil, err
 	}
 
+	// Messages without EXT4B_ATTR_VALID are taken to carry a value for
+	// every attribute they contain. Bits of attributes that are missing
+	// from the message are ignored.
 	var attributes Attrs
+	var present uint32
+	var haveValid bool
 	for ad.Next() {
 		switch ad.Type() {
 		case EXT4B_ATTR_UID:
 			attributes.Uid = ad.Uint32()
+			present |= EXT4B_VALID_UID
 		case EXT4B_ATTR_GID:
 			attributes.Gid = ad.Uint32()
+			present |= EXT4B_VALID_GID
 		case EXT4B_ATTR_ATIME:
 			ad.Nested(attributes.Atime.DecodeTime)
+			present |= EXT4B_VALID_ATIME
 		case EXT4B_ATTR_MTIME:
 			ad.Nested(attributes.Mtime.DecodeTime)
+			present |= EXT4B_VALID_MTIME
 		case EXT4B_ATTR_CTIME:
 			ad.Nested(attributes.Ctime.DecodeTime)
+			present |= EXT4B_VALID_CTIME
 		case EXT4B_ATTR_MODE:
 			attributes.Mode = ad.Uint32()
+			present |= EXT4B_VALID_MODE
+		case EXT4B_ATTR_SIZE:
+			attributes.Size = ad.Uint64()
+			present |= EXT4B_VALID_SIZE
+		case EXT4B_ATTR_NLINK:
+			attributes.Nlink = ad.Uint32()
+			present |= EXT4B_VALID_NLINK
+		case EXT4B_ATTR_BLOCKS:
+			attributes.Blocks = ad.Uint64()
+			present |= EXT4B_VALID_BLOCKS
+		case EXT4B_ATTR_CRTIME:
+			ad.Nested(attributes.Crtime.DecodeTime)
+			present |= EXT4B_VALID_CRTIME
+		case EXT4B_ATTR_FLAGS:
+			attributes.Flags = ad.Uint32()
+			present |= EXT4B_VALID_FLAGS
+		case EXT4B_ATTR_PROJID:
+			attributes.Projid = ad.Uint32()
+			present |= EXT4B_VALID_PROJID
 		case EXT4B_ATTR_INO:
 			attributes.Ino = ad.Uint64()
+		case EXT4B_ATTR_FS_UUID:
+			ad.Do(func(b []byte) error {
+				uuid, err := FormatUUID(b)
+				attributes.FsUUID = uuid
+				return err
+			})
+		case EXT4B_ATTR_GENERATION:
+			attributes.Generation = ad.Uint32()
+		case EXT4B_ATTR_VALID:
+			attributes.Valid = ad.Uint32()
+			haveValid = true
 		}
 	}
+	if err := ad.Err(); err != nil {
+		return nil, err
+	}
+	if attributes.FsUUID == "" {
+		return nil, fmt.Errorf("missing EXT4B_ATTR_FS_UUID")
+	}
+	if haveValid {
+		attributes.Valid &= present
+	} else {
+		attributes.Valid = present
+	}
 	return &attributes, nil
 }
 
-func DecodeIno(data []byte) (uint64, error) {
+// DecodeInodeRef decodes requests that only identify an inode.
+func DecodeInodeRef(data []byte) (InodeRef, error) {
+	ad, err := netlink.NewAttributeDecoder(data)
+	if err != nil {
+		return InodeRef{}, err
+	}
+
+	var ref InodeRef
+	var haveIno bool
+	for ad.Next() {
+		switch ad.Type() {
+		case EXT4B_ATTR_INO:
+			ref.Ino = ad.Uint64()
+			haveIno = true
+		case EXT4B_ATTR_FS_UUID:
+			ad.Do(func(b []byte) error {
+				uuid, err := FormatUUID(b)
+				ref.FsUUID = uuid
+				return err
+			})
+		case EXT4B_ATTR_GENERATION:
+			ref.Generation = ad.Uint32()
+		}
+	}
+	if err := ad.Err(); err != nil {
+		return InodeRef{}, err
+	}
+
+	if !haveIno || ref.FsUUID == "" {
+		err = fmt.Errorf("expected EXT4B_ATTR_INO and EXT4B_ATTR_FS_UUID")
+		return InodeRef{}, err
+	}
+	return ref, nil
+}
+
+// Content digest algorithms. DigestFsverity is the fs-verity file digest
+// with SHA-256 as its hash.
+const (
+	DigestSHA256   = "sha256"
+	DigestFsverity = "fsverity-sha256"
+)
+
+// ContentDigest is a digest of the content of a regular file, taken while
+// the file had the given mtime.
+type ContentDigest struct {
+	Algorithm string `json:"algorithm"`
+	Digest    []byte `json:"digest"`
+	Mtime     Time   `json:"mtime"`
+}
+
+// Xattr is an extended attribute of an inode as set by setxattr. Digest is
+// the SHA-256 of the value; Value is only set if the kernel sent the value
+// itself rather than its digest.
+type Xattr struct {
+	Ref    InodeRef `json:"ref"`
+	Name   string   `json:"name"`
+	Value  []byte   `json:"value,omitempty"`
+	Digest []byte   `json:"digest"`
+}
+
+// DecodeXattr decodes setxattr and removexattr requests. The digest is
+// computed here when the request carries the value.
+func DecodeXattr(data []byte) (*Xattr, error) {
+	ref, err := DecodeInodeRef(data)
+	if err != nil {
+		return nil, err
+	}
+
 	ad, err := netlink.NewAttributeDecoder(data)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	if ad.Next() && ad.Type() == EXT4B_ATTR_INO {
-		return ad.Uint64(), nil
+	x := Xattr{Ref: ref}
+	var haveValue bool
+	for ad.Next() {
+		switch ad.Type() {
+		case EXT4B_ATTR_XATTR_NAME:
+			x.Name = ad.String()
+		case EXT4B_ATTR_XATTR_VALUE:
+			x.Value = ad.Bytes()
+			haveValue = true
+		case EXT4B_ATTR_XATTR_DIGEST:
+			x.Digest = ad.Bytes()
+		}
+	}
+	if err := ad.Err(); err != nil {
+		return nil, err
 	}
 
-	err = fmt.Errorf("expected EXT4B_ATTR_INO, but got something else or no attributes")
-	return 0, err
+	if x.Name == "" {
+		return nil, fmt.Errorf("missing EXT4B_ATTR_XATTR_NAME")
+	}
+	if haveValue {
+		digest := sha256.Sum256(x.Value)
+		if x.Digest != nil && !bytes.Equal(x.Digest, digest[:]) {
+			return nil, fmt.Errorf("digest of xattr %s does not match its value", x.Name)
+		}
+		x.Digest = digest[:]
+	}
+	if x.Digest != nil && len(x.Digest) != sha256.Size {
+		return nil, fmt.Errorf("invalid digest length %d", len(x.Digest))
+	}
+	return &x, nil
+}
+
+// Dentry is a directory entry: Name within the directory Parent.
+type Dentry struct {
+	Parent InodeRef `json:"parent"`
+	Name   string   `json:"name"`
+}
+
+// DentryChange is a change of the directory entries pointing at an inode.
+// Old is the entry removed by unlink and rename, New the entry added by
+// create, link and rename.
+type DentryChange struct {
+	Op  uint8    `json:"op"`
+	Ref InodeRef `json:"ref"`
+	Old *Dentry  `json:"old,omitempty"`
+	New *Dentry  `json:"new,omitempty"`
+}
+
+// DecodeDentryChange decodes EXT4B_CMD_DENTRY_REQUEST and
+// EXT4B_CMD_RENAME_REQUEST messages. Parents are on the filesystem of the
+// inode.
+func DecodeDentryChange(cmd uint8, data []byte) (*DentryChange, error) {
+	ref, err := DecodeInodeRef(data)
+	if err != nil {
+		return nil, err
+	}
+
+	ad, err := netlink.NewAttributeDecoder(data)
+	if err != nil {
+		return nil, err
+	}
+
+	change := DentryChange{Ref: ref}
+	dentry := Dentry{Parent: InodeRef{FsUUID: ref.FsUUID}}
+	newDentry := Dentry{Parent: InodeRef{FsUUID: ref.FsUUID}}
+	for ad.Next() {
+		switch ad.Type() {
+		case EXT4B_ATTR_DENTRY_OP:
+			change.Op = ad.Uint8()
+		case EXT4B_ATTR_PARENT_INO:
+			dentry.Parent.Ino = ad.Uint64()
+		case EXT4B_ATTR_PARENT_GENERATION:
+			dentry.Parent.Generation = ad.Uint32()
+		case EXT4B_ATTR_DENTRY_NAME:
+			dentry.Name = ad.String()
+		case EXT4B_ATTR_NEW_PARENT_INO:
+			newDentry.Parent.Ino = ad.Uint64()
+		case EXT4B_ATTR_NEW_PARENT_GENERATION:
+			newDentry.Parent.Generation = ad.Uint32()
+		case EXT4B_ATTR_NEW_DENTRY_NAME:
+			newDentry.Name = ad.String()
+		}
+	}
+	if err := ad.Err(); err != nil {
+		return nil, err
+	}
+
+	if dentry.Parent.Ino == 0 || dentry.Name == "" {
+		return nil, fmt.Errorf("expected EXT4B_ATTR_PARENT_INO and EXT4B_ATTR_DENTRY_NAME")
+	}
+
+	if cmd == EXT4B_CMD_RENAME_REQUEST {
+		if newDentry.Parent.Ino == 0 || newDentry.Name == "" {
+			return nil, fmt.Errorf("expected EXT4B_ATTR_NEW_PARENT_INO and EXT4B_ATTR_NEW_DENTRY_NAME")
+		}
+		change.Op = EXT4B_DENTRY_OP_RENAME
+		change.Old = &dentry
+		change.New = &newDentry
+		return &change, nil
+	}
+
+	switch change.Op {
+	case EXT4B_DENTRY_OP_CREATE, EXT4B_DENTRY_OP_LINK:
+		change.New = &dentry
+	case EXT4B_DENTRY_OP_UNLINK:
+		change.Old = &dentry
+	default:
+		return nil, fmt.Errorf("invalid dentry operation %d", change.Op)
+	}
+	return &change, nil
+}
+
+// EncodeInodeRef adds the attributes identifying ref to a message.
+func EncodeInodeRef(ae *netlink.AttributeEncoder, ref InodeRef) error {
+	uuid, err := ParseUUID(ref.FsUUID)
+	if err != nil {
+		return err
+	}
+	ae.Uint64(EXT4B_ATTR_INO, ref.Ino)
+	ae.Bytes(EXT4B_ATTR_FS_UUID, uuid)
+	ae.Uint32(EXT4B_ATTR_GENERATION, ref.Generation)
+	return nil
 }
 
 func (n *Time) EncodeTime(ae *netlink.AttributeEncoder) {
-	ae.Uint64(EXT4B_TIME_ATTR_SEC, n.Sec)
+	ae.Int64(EXT4B_TIME_ATTR_SEC, n.Sec)
 	ae.Uint32(EXT4B_TIME_ATTR_NSEC, n.Nsec)
 }
@@ -9,6 +9,13 @@ const (
 	EXT4B_CMD_STATUS_RESPONSE
 	EXT4B_CMD_GETATTR_REQUEST
 	EXT4B_CMD_GETATTR_RESPONSE
+	EXT4B_CMD_CHANGE_NOTIFY
+	EXT4B_CMD_DELETE_INODE_REQUEST
+	EXT4B_CMD_SETXATTR_REQUEST
+	EXT4B_CMD_REMOVEXATTR_REQUEST
+	EXT4B_CMD_DENTRY_REQUEST
+	EXT4B_CMD_RENAME_REQUEST
+	EXT4B_CMD_CLOSE_WRITE_NOTIFY
 )
 
 const (
@@ -21,6 +28,59 @@ const (
 	EXT4B_ATTR_MODE
 	EXT4B_ATTR_INO
 	EXT4B_ATTR_STATUS
+	EXT4B_ATTR_FS_UUID
+	EXT4B_ATTR_GENERATION
+	EXT4B_ATTR_VALID
+	EXT4B_ATTR_SIZE
+	EXT4B_ATTR_NLINK
+	EXT4B_ATTR_BLOCKS
+	EXT4B_ATTR_CRTIME
+	EXT4B_ATTR_FLAGS
+	EXT4B_ATTR_PROJID
+	EXT4B_ATTR_XATTR_NAME
+	EXT4B_ATTR_XATTR_VALUE
+	EXT4B_ATTR_XATTR_DIGEST
+	EXT4B_ATTR_DENTRY_OP
+	EXT4B_ATTR_PARENT_INO
+	EXT4B_ATTR_PARENT_GENERATION
+	EXT4B_ATTR_DENTRY_NAME
+	EXT4B_ATTR_NEW_PARENT_INO
+	EXT4B_ATTR_NEW_PARENT_GENERATION
+	EXT4B_ATTR_NEW_DENTRY_NAME
+)
+
+// Values of EXT4B_ATTR_DENTRY_OP. EXT4B_CMD_RENAME_REQUEST carries no
+// operation; EXT4B_DENTRY_OP_RENAME is only used within the daemon.
+const (
+	EXT4B_DENTRY_OP_UNSPEC uint8 = iota
+	EXT4B_DENTRY_OP_CREATE
+	EXT4B_DENTRY_OP_LINK
+	EXT4B_DENTRY_OP_UNLINK
+	EXT4B_DENTRY_OP_RENAME
+)
+
+// Bits of EXT4B_ATTR_VALID, which tells which attributes of a request carry
+// a value. They have the values of the matching ia_valid bits of the kernel;
+// nlink, blocks, crtime, flags and projid have no such bit and use bits
+// ia_valid leaves unused.
+const (
+	EXT4B_VALID_MODE   uint32 = 1 << 0
+	EXT4B_VALID_UID    uint32 = 1 << 1
+	EXT4B_VALID_GID    uint32 = 1 << 2
+	EXT4B_VALID_SIZE   uint32 = 1 << 3
+	EXT4B_VALID_ATIME  uint32 = 1 << 4
+	EXT4B_VALID_MTIME  uint32 = 1 << 5
+	EXT4B_VALID_CTIME  uint32 = 1 << 6
+	EXT4B_VALID_NLINK  uint32 = 1 << 24
+	EXT4B_VALID_BLOCKS uint32 = 1 << 25
+	EXT4B_VALID_CRTIME uint32 = 1 << 26
+	EXT4B_VALID_FLAGS  uint32 = 1 << 27
+	EXT4B_VALID_PROJID uint32 = 1 << 28
+
+	EXT4B_VALID_ALL = EXT4B_VALID_MODE | EXT4B_VALID_UID | EXT4B_VALID_GID |
+		EXT4B_VALID_SIZE | EXT4B_VALID_ATIME | EXT4B_VALID_MTIME |
+		EXT4B_VALID_CTIME | EXT4B_VALID_NLINK | EXT4B_VALID_BLOCKS |
+		EXT4B_VALID_CRTIME | EXT4B_VALID_FLAGS | EXT4B_VALID_PROJID
 )
 
 const (
@@ -33,4 +93,5 @@ const (
 	EXT4BD_STATUS_SUCCESS uint16 = iota
 	EXT4BD_STATUS_FAIL
 	EXT4BD_STATUS_INODE_NOT_FOUND
+	EXT4BD_STATUS_PERMISSION_DENIED
 )
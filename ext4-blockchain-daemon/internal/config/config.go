@@ -0,0 +1,528 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of the daemon. Values are resolved in the
+// following order, later sources overriding earlier ones: built-in defaults,
+// the YAML configuration file, environment variables, command-line flags.
+type Config struct {
+	Daemon  DaemonConfig  `yaml:"daemon"`
+	Cache   CacheConfig   `yaml:"cache"`
+	API     APIConfig     `yaml:"api"`
+	Events  EventsConfig  `yaml:"events"`
+	Journal JournalConfig `yaml:"journal"`
+	Digest  DigestConfig  `yaml:"digest"`
+	Fabric  FabricConfig  `yaml:"fabric"`
+
+	// envErrors collects malformed environment values so that they are
+	// reported by Validate together with every other problem.
+	envErrors []error
+}
+
+type DaemonConfig struct {
+	// Backend selects where inode records are kept: "fabric" or "memory".
+	Backend string `yaml:"backend"`
+	// Workers is the number of requests processed concurrently. Requests
+	// for the same inode are always processed in order.
+	Workers int `yaml:"workers"`
+	// BatchWindow is how long SETATTR requests are collected before being
+	// applied in one transaction; zero disables batching. BatchSize bounds
+	// the number of inodes in such a transaction.
+	BatchWindow time.Duration `yaml:"batchWindow"`
+	BatchSize   int           `yaml:"batchSize"`
+}
+
+type CacheConfig struct {
+	// Size is the number of inodes kept in the attribute cache; zero
+	// disables the cache.
+	Size        int           `yaml:"size"`
+	TTL         time.Duration `yaml:"ttl"`
+	NegativeTTL time.Duration `yaml:"negativeTTL"`
+}
+
+// EventsConfig controls the subscription to the chaincode events, which is
+// how the daemon learns about changes made by other daemons and tools.
+type EventsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// CheckpointPath is the file recording the last processed event. When
+	// empty, events missed while the daemon was down are not replayed.
+	CheckpointPath string `yaml:"checkpointPath"`
+	// NotifyKernel forwards every change to the kernel module with
+	// EXT4B_CMD_CHANGE_NOTIFY.
+	NotifyKernel bool `yaml:"notifyKernel"`
+}
+
+// JournalConfig controls the journal keeping the changes the ledger could
+// not take while it was unreachable.
+type JournalConfig struct {
+	// Path is the journal file; empty disables the journal, and changes
+	// made while the ledger is unreachable are lost.
+	Path string `yaml:"path"`
+	// Offline decides what the kernel is told about a change that was
+	// journaled: "queue" reports success, "fail" reports failure. The
+	// change is replayed either way.
+	Offline string `yaml:"offline"`
+	// RetryInterval is how long to wait before retrying the replay after
+	// the ledger was found unreachable.
+	RetryInterval time.Duration `yaml:"retryInterval"`
+}
+
+const (
+	JournalOfflineQueue = "queue"
+	JournalOfflineFail  = "fail"
+)
+
+// DigestConfig controls the content digests of regular files, computed
+// when a file is closed after being written.
+type DigestConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Trigger selects what starts a digest: "kernel" waits for
+	// EXT4B_CMD_CLOSE_WRITE_NOTIFY from the kernel module, "fanotify"
+	// watches the mounts for FAN_CLOSE_WRITE.
+	Trigger string `yaml:"trigger"`
+	// Mounts maps filesystem UUIDs to a mount point of the filesystem,
+	// through which the files are opened.
+	Mounts map[string]string `yaml:"mounts"`
+	// Workers is the number of files hashed concurrently.
+	Workers int `yaml:"workers"`
+}
+
+const (
+	DigestTriggerKernel   = "kernel"
+	DigestTriggerFanotify = "fanotify"
+)
+
+type APIConfig struct {
+	// Listen is the TCP address or "unix:" socket path of the HTTP API;
+	// empty disables it.
+	Listen string `yaml:"listen"`
+}
+
+const (
+	BackendFabric = "fabric"
+	BackendMemory = "memory"
+)
+
+type FabricConfig struct {
+	MSPID        string `yaml:"mspId"`
+	CertPath     string `yaml:"certPath"`
+	KeyPath      string `yaml:"keyPath"`
+	TLSCertPath  string `yaml:"tlsCertPath"`
+	PeerEndpoint string `yaml:"peerEndpoint"`
+	GatewayPeer  string `yaml:"gatewayPeer"`
+	Channel      string `yaml:"channel"`
+	Chaincode    string `yaml:"chaincode"`
+	// PrivateOwnership keeps uid, gid and mode in a private data
+	// collection instead of public state.
+	PrivateOwnership bool           `yaml:"privateOwnership"`
+	Timeouts         TimeoutsConfig `yaml:"timeouts"`
+}
+
+type TimeoutsConfig struct {
+	Evaluate     time.Duration `yaml:"evaluate"`
+	Endorse      time.Duration `yaml:"endorse"`
+	Submit       time.Duration `yaml:"submit"`
+	CommitStatus time.Duration `yaml:"commitStatus"`
+}
+
+const DefaultPath = "/etc/ext4-chain-daemon/config.yaml"
+
+func Default() *Config {
+	return &Config{
+		Daemon: DaemonConfig{
+			Backend:     BackendFabric,
+			Workers:     8,
+			BatchWindow: 20 * time.Millisecond,
+			BatchSize:   128,
+		},
+		Cache: CacheConfig{
+			Size:        65536,
+			TTL:         30 * time.Second,
+			NegativeTTL: 5 * time.Second,
+		},
+		Events: EventsConfig{
+			Enabled: true,
+		},
+		Journal: JournalConfig{
+			Offline:       JournalOfflineQueue,
+			RetryInterval: 5 * time.Second,
+		},
+		Digest: DigestConfig{
+			Trigger: DigestTriggerKernel,
+			Workers: 2,
+		},
+		Fabric: FabricConfig{
+			MSPID:        "Org1MSP",
+			PeerEndpoint: "dns:///localhost:7051",
+			GatewayPeer:  "peer0.org1.example.com",
+			Channel:      "mychannel",
+			Chaincode:    "ext4",
+			Timeouts: TimeoutsConfig{
+				Evaluate:     5 * time.Second,
+				Endorse:      15 * time.Second,
+				Submit:       5 * time.Second,
+				CommitStatus: 30 * time.Second,
+			},
+		},
+	}
+}
+
+// Load builds the configuration from the file named by -config (or
+// EXT4BD_CONFIG), the environment and the command-line arguments, and
+// validates the result.
+func Load(name string, args []string) (*Config, error) {
+	cfg, _, err := LoadArgs(name, args)
+	return cfg, err
+}
+
+// LoadArgs is Load for commands that take arguments after the flags, which
+// it returns along with the configuration.
+func LoadArgs(name string, args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	path := fs.String("config", "", "path to the YAML configuration file (default "+DefaultPath+" if present)")
+
+	// Flags are parsed before the file is read, so their values are only
+	// recorded here and applied once the file and the environment are in.
+	var flags []func(cfg *Config)
+	scratch := Default()
+	for _, s := range settings {
+		s := s
+		parse := func(value string) error {
+			if err := s.set(scratch, value); err != nil {
+				return err
+			}
+			flags = append(flags, func(cfg *Config) { s.set(cfg, value) })
+			return nil
+		}
+		if s.isBool(scratch) {
+			fs.BoolFunc(s.flag, s.usage, parse)
+		} else {
+			fs.Func(s.flag, s.usage, parse)
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	explicit := *path != ""
+	if !explicit {
+		if env := os.Getenv("EXT4BD_CONFIG"); env != "" {
+			*path = env
+			explicit = true
+		} else {
+			*path = DefaultPath
+		}
+	}
+
+	cfg := Default()
+	if err := cfg.readFile(*path, explicit); err != nil {
+		return nil, nil, err
+	}
+	cfg.applyEnv()
+	for _, apply := range flags {
+		apply(cfg)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+func (cfg *Config) readFile(path string, required bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if !required && errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to open configuration file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse configuration file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (cfg *Config) applyEnv() {
+	for _, s := range settings {
+		if s.env == "" {
+			continue
+		}
+		value, ok := os.LookupEnv(s.env)
+		if !ok || value == "" {
+			continue
+		}
+		if err := s.set(cfg, value); err != nil {
+			cfg.envErrors = append(cfg.envErrors, fmt.Errorf("%s: invalid value %q: %w", s.env, value, err))
+		}
+	}
+}
+
+// setting describes a value that can be overridden from the environment and
+// the command line. field returns a pointer to the corresponding Config field.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	field func(cfg *Config) any
+}
+
+func (s setting) set(cfg *Config, value string) error {
+	switch p := s.field(cfg).(type) {
+	case *string:
+		*p = value
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*p = d
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*p = n
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*p = b
+	case *map[string]string:
+		m := make(map[string]string)
+		for _, pair := range strings.Split(value, ",") {
+			k, v, ok := strings.Cut(pair, "=")
+			if !ok || k == "" {
+				return fmt.Errorf("expected key=value, got %q", pair)
+			}
+			m[k] = v
+		}
+		*p = m
+	default:
+		panic(fmt.Sprintf("config: unsupported type %T for %s", p, s.flag))
+	}
+	return nil
+}
+
+func (s setting) isBool(cfg *Config) bool {
+	_, ok := s.field(cfg).(*bool)
+	return ok
+}
+
+var settings = []setting{
+	{"backend", "EXT4BD_BACKEND", "ledger backend: fabric or memory",
+		func(cfg *Config) any { return &cfg.Daemon.Backend }},
+	{"workers", "EXT4BD_WORKERS", "number of requests processed concurrently",
+		func(cfg *Config) any { return &cfg.Daemon.Workers }},
+	{"batch-window", "EXT4BD_BATCH_WINDOW", "how long SETATTR requests are collected into one transaction (0 disables batching)",
+		func(cfg *Config) any { return &cfg.Daemon.BatchWindow }},
+	{"batch-size", "EXT4BD_BATCH_SIZE", "maximum number of inodes updated in one transaction",
+		func(cfg *Config) any { return &cfg.Daemon.BatchSize }},
+	{"cache-size", "EXT4BD_CACHE_SIZE", "number of inodes kept in the attribute cache (0 disables it)",
+		func(cfg *Config) any { return &cfg.Cache.Size }},
+	{"cache-ttl", "EXT4BD_CACHE_TTL", "how long cached attributes are served",
+		func(cfg *Config) any { return &cfg.Cache.TTL }},
+	{"cache-negative-ttl", "EXT4BD_CACHE_NEGATIVE_TTL", "how long unknown inodes are remembered",
+		func(cfg *Config) any { return &cfg.Cache.NegativeTTL }},
+	{"api-listen", "EXT4BD_API_LISTEN", "address of the HTTP API, host:port or unix:<path> (empty disables it)",
+		func(cfg *Config) any { return &cfg.API.Listen }},
+	{"events", "EXT4BD_EVENTS", "subscribe to chaincode events",
+		func(cfg *Config) any { return &cfg.Events.Enabled }},
+	{"events-checkpoint", "EXT4BD_EVENTS_CHECKPOINT", "file recording the last processed chaincode event",
+		func(cfg *Config) any { return &cfg.Events.CheckpointPath }},
+	{"events-notify-kernel", "EXT4BD_EVENTS_NOTIFY_KERNEL", "forward ledger changes to the kernel module",
+		func(cfg *Config) any { return &cfg.Events.NotifyKernel }},
+	{"journal", "EXT4BD_JOURNAL", "file journaling changes while the ledger is unreachable (empty disables it)",
+		func(cfg *Config) any { return &cfg.Journal.Path }},
+	{"journal-offline", "EXT4BD_JOURNAL_OFFLINE", "what the kernel is told about journaled changes: queue (success) or fail",
+		func(cfg *Config) any { return &cfg.Journal.Offline }},
+	{"journal-retry-interval", "EXT4BD_JOURNAL_RETRY_INTERVAL", "how often the journal is replayed while the ledger is unreachable",
+		func(cfg *Config) any { return &cfg.Journal.RetryInterval }},
+	{"digest", "EXT4BD_DIGEST", "record content digests of written files",
+		func(cfg *Config) any { return &cfg.Digest.Enabled }},
+	{"digest-trigger", "EXT4BD_DIGEST_TRIGGER", "what starts a content digest: kernel or fanotify",
+		func(cfg *Config) any { return &cfg.Digest.Trigger }},
+	{"digest-mounts", "EXT4BD_DIGEST_MOUNTS", "mount points of the tracked filesystems, as uuid=path,...",
+		func(cfg *Config) any { return &cfg.Digest.Mounts }},
+	{"digest-workers", "EXT4BD_DIGEST_WORKERS", "number of files hashed concurrently",
+		func(cfg *Config) any { return &cfg.Digest.Workers }},
+	{"msp-id", "EXT4BD_MSP_ID", "MSP ID of the client identity",
+		func(cfg *Config) any { return &cfg.Fabric.MSPID }},
+	{"cert-path", "EXT4BD_CERT_PATH", "directory holding the client signing certificate",
+		func(cfg *Config) any { return &cfg.Fabric.CertPath }},
+	{"key-path", "EXT4BD_KEY_PATH", "directory holding the client private key",
+		func(cfg *Config) any { return &cfg.Fabric.KeyPath }},
+	{"tls-cert-path", "EXT4BD_TLS_CERT_PATH", "TLS CA certificate of the gateway peer",
+		func(cfg *Config) any { return &cfg.Fabric.TLSCertPath }},
+	{"peer-endpoint", "EXT4BD_PEER_ENDPOINT", "gRPC endpoint of the gateway peer",
+		func(cfg *Config) any { return &cfg.Fabric.PeerEndpoint }},
+	{"gateway-peer", "EXT4BD_GATEWAY_PEER", "TLS server name of the gateway peer",
+		func(cfg *Config) any { return &cfg.Fabric.GatewayPeer }},
+	{"channel", "CHANNEL_NAME", "channel name",
+		func(cfg *Config) any { return &cfg.Fabric.Channel }},
+	{"chaincode", "CHAINCODE_NAME", "chaincode name",
+		func(cfg *Config) any { return &cfg.Fabric.Chaincode }},
+	{"private-ownership", "EXT4BD_PRIVATE_OWNERSHIP", "keep uid, gid and mode in a private data collection",
+		func(cfg *Config) any { return &cfg.Fabric.PrivateOwnership }},
+	{"evaluate-timeout", "EXT4BD_EVALUATE_TIMEOUT", "timeout for evaluating transactions",
+		func(cfg *Config) any { return &cfg.Fabric.Timeouts.Evaluate }},
+	{"endorse-timeout", "EXT4BD_ENDORSE_TIMEOUT", "timeout for endorsing transactions",
+		func(cfg *Config) any { return &cfg.Fabric.Timeouts.Endorse }},
+	{"submit-timeout", "EXT4BD_SUBMIT_TIMEOUT", "timeout for submitting transactions",
+		func(cfg *Config) any { return &cfg.Fabric.Timeouts.Submit }},
+	{"commit-status-timeout", "EXT4BD_COMMIT_STATUS_TIMEOUT", "timeout for obtaining the commit status",
+		func(cfg *Config) any { return &cfg.Fabric.Timeouts.CommitStatus }},
+}
+
+// Validate reports every invalid setting at once.
+func (cfg *Config) Validate() error {
+	errs := append([]error(nil), cfg.envErrors...)
+	errs = append(errs, cfg.Daemon.validate()...)
+	errs = append(errs, cfg.Cache.validate()...)
+	if cfg.Journal.Path != "" {
+		errs = append(errs, cfg.Journal.validate()...)
+	}
+	if cfg.Digest.Enabled {
+		errs = append(errs, cfg.Digest.validate()...)
+	}
+	if cfg.Daemon.Backend == BackendFabric {
+		errs = append(errs, cfg.Fabric.validate()...)
+	}
+	return errors.Join(errs...)
+}
+
+func (d *DaemonConfig) validate() []error {
+	var errs []error
+	switch d.Backend {
+	case BackendFabric, BackendMemory:
+	default:
+		errs = append(errs, fmt.Errorf("daemon.backend: unknown backend %q, expected %q or %q", d.Backend, BackendFabric, BackendMemory))
+	}
+	if d.Workers < 1 {
+		errs = append(errs, fmt.Errorf("daemon.workers must be at least 1, got %d", d.Workers))
+	}
+	if d.BatchWindow < 0 {
+		errs = append(errs, fmt.Errorf("daemon.batchWindow must not be negative, got %v", d.BatchWindow))
+	}
+	if d.BatchSize < 1 {
+		errs = append(errs, fmt.Errorf("daemon.batchSize must be at least 1, got %d", d.BatchSize))
+	}
+	return errs
+}
+
+func (c *CacheConfig) validate() []error {
+	var errs []error
+	if c.Size < 0 {
+		errs = append(errs, fmt.Errorf("cache.size must not be negative, got %d", c.Size))
+	}
+	if c.Size > 0 && c.TTL <= 0 {
+		errs = append(errs, fmt.Errorf("cache.ttl must be positive, got %v", c.TTL))
+	}
+	if c.NegativeTTL < 0 {
+		errs = append(errs, fmt.Errorf("cache.negativeTTL must not be negative, got %v", c.NegativeTTL))
+	}
+	return errs
+}
+
+func (j *JournalConfig) validate() []error {
+	var errs []error
+	switch j.Offline {
+	case JournalOfflineQueue, JournalOfflineFail:
+	default:
+		errs = append(errs, fmt.Errorf("journal.offline: unknown policy %q, expected %q or %q", j.Offline, JournalOfflineQueue, JournalOfflineFail))
+	}
+	if j.RetryInterval <= 0 {
+		errs = append(errs, fmt.Errorf("journal.retryInterval must be positive, got %v", j.RetryInterval))
+	}
+	return errs
+}
+
+func (d *DigestConfig) validate() []error {
+	var errs []error
+	switch d.Trigger {
+	case DigestTriggerKernel, DigestTriggerFanotify:
+	default:
+		errs = append(errs, fmt.Errorf("digest.trigger: unknown trigger %q, expected %q or %q", d.Trigger, DigestTriggerKernel, DigestTriggerFanotify))
+	}
+	if len(d.Mounts) == 0 {
+		errs = append(errs, fmt.Errorf("digest.mounts must list at least one filesystem"))
+	}
+	for uuid, path := range d.Mounts {
+		if fi, err := os.Stat(path); err != nil {
+			errs = append(errs, fmt.Errorf("digest.mounts.%s: %w", uuid, err))
+		} else if !fi.IsDir() {
+			errs = append(errs, fmt.Errorf("digest.mounts.%s: %s is not a directory", uuid, path))
+		}
+	}
+	if d.Workers < 1 {
+		errs = append(errs, fmt.Errorf("digest.workers must be at least 1, got %d", d.Workers))
+	}
+	return errs
+}
+
+func (f *FabricConfig) validate() []error {
+	var errs []error
+	required := func(name, value string) {
+		if strings.TrimSpace(value) == "" {
+			errs = append(errs, fmt.Errorf("fabric.%s must be set", name))
+		}
+	}
+	dir := func(name, value string) {
+		if value == "" {
+			required(name, value)
+			return
+		}
+		if fi, err := os.Stat(value); err != nil {
+			errs = append(errs, fmt.Errorf("fabric.%s: %w", name, err))
+		} else if !fi.IsDir() {
+			errs = append(errs, fmt.Errorf("fabric.%s: %s is not a directory", name, value))
+		}
+	}
+	file := func(name, value string) {
+		if value == "" {
+			required(name, value)
+			return
+		}
+		if fi, err := os.Stat(value); err != nil {
+			errs = append(errs, fmt.Errorf("fabric.%s: %w", name, err))
+		} else if fi.IsDir() {
+			errs = append(errs, fmt.Errorf("fabric.%s: %s is a directory", name, value))
+		}
+	}
+	positive := func(name string, value time.Duration) {
+		if value <= 0 {
+			errs = append(errs, fmt.Errorf("fabric.timeouts.%s must be positive, got %v", name, value))
+		}
+	}
+
+	required("mspId", f.MSPID)
+	dir("certPath", f.CertPath)
+	dir("keyPath", f.KeyPath)
+	file("tlsCertPath", f.TLSCertPath)
+	required("peerEndpoint", f.PeerEndpoint)
+	required("gatewayPeer", f.GatewayPeer)
+	required("channel", f.Channel)
+	required("chaincode", f.Chaincode)
+	positive("evaluate", f.Timeouts.Evaluate)
+	positive("endorse", f.Timeouts.Endorse)
+	positive("submit", f.Timeouts.Submit)
+	positive("commitStatus", f.Timeouts.CommitStatus)
+	return errs
+}
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv hides the environment and the configuration file of the host
+// from Load.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("EXT4BD_CONFIG", writeFile(t, ""))
+	for _, s := range settings {
+		t.Setenv(s.env, "")
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPrecedence(t *testing.T) {
+	clearEnv(t)
+	path := writeFile(t, `
+daemon:
+  backend: memory
+  workers: 2
+cache:
+  size: 10
+  ttl: 1m
+`)
+	t.Setenv("EXT4BD_WORKERS", "3")
+	t.Setenv("EXT4BD_CACHE_SIZE", "20")
+
+	// The flag comes before -config, and still overrides the file.
+	cfg, err := Load("test", []string{"-cache-size", "30", "-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Daemon.Backend != BackendMemory {
+		t.Errorf("backend = %q, want the file's %q", cfg.Daemon.Backend, BackendMemory)
+	}
+	if cfg.Daemon.Workers != 3 {
+		t.Errorf("workers = %d, want the environment's 3", cfg.Daemon.Workers)
+	}
+	if cfg.Cache.Size != 30 {
+		t.Errorf("cache size = %d, want the flag's 30", cfg.Cache.Size)
+	}
+	if cfg.Cache.TTL != time.Minute {
+		t.Errorf("cache ttl = %v, want the file's 1m", cfg.Cache.TTL)
+	}
+	if cfg.Daemon.BatchSize != Default().Daemon.BatchSize {
+		t.Errorf("batch size = %d, want the default", cfg.Daemon.BatchSize)
+	}
+}
+
+func TestConfigFromEnvironment(t *testing.T) {
+	clearEnv(t)
+	path := writeFile(t, "daemon:\n  backend: memory\n")
+	t.Setenv("EXT4BD_CONFIG", path)
+
+	cfg, err := Load("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Daemon.Backend != BackendMemory {
+		t.Errorf("backend = %q, want %q", cfg.Daemon.Backend, BackendMemory)
+	}
+}
+
+func TestLoadArgsReturnsArguments(t *testing.T) {
+	clearEnv(t)
+	cfg, args, err := LoadArgs("test", []string{"-backend", "memory", "scrub", "-o", "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Daemon.Backend != BackendMemory {
+		t.Errorf("backend = %q, want %q", cfg.Daemon.Backend, BackendMemory)
+	}
+	if want := []string{"scrub", "-o", "json"}; !slices.Equal(args, want) {
+		t.Errorf("args = %q, want %q", args, want)
+	}
+}
+
+func TestFileErrors(t *testing.T) {
+	clearEnv(t)
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"unknown field", writeFile(t, "daemon:\n  backend: memory\n  worker: 2\n"), "field worker not found"},
+		{"missing file", filepath.Join(t.TempDir(), "missing.yaml"), "failed to open configuration file"},
+		{"malformed", writeFile(t, "daemon: [\n"), "failed to parse configuration file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load("test", []string{"-config", tt.path})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvalidFlag(t *testing.T) {
+	clearEnv(t)
+	if _, err := Load("test", []string{"-workers", "many"}); err == nil {
+		t.Error("Load accepted -workers many")
+	}
+}
+
+func TestValidateReportsEveryError(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("EXT4BD_BATCH_WINDOW", "soon")
+	t.Setenv("EXT4BD_DIGEST_MOUNTS", "nopath")
+
+	_, err := Load("test", []string{
+		"-backend", "memory",
+		"-workers", "0",
+		"-cache-size", "-1",
+		"-journal", filepath.Join(t.TempDir(), "journal"),
+		"-journal-offline", "drop",
+	})
+	if err == nil {
+		t.Fatal("Load accepted an invalid configuration")
+	}
+
+	for _, want := range []string{
+		"EXT4BD_BATCH_WINDOW",
+		"EXT4BD_DIGEST_MOUNTS",
+		"daemon.workers",
+		"cache.size",
+		"journal.offline",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %s:\n%v", want, err)
+		}
+	}
+}
+
+func TestValidateFabric(t *testing.T) {
+	clearEnv(t)
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(cert, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Load("test", []string{"-key-path", cert, "-tls-cert-path", dir, "-msp-id", " "})
+	if err == nil {
+		t.Fatal("Load accepted an invalid fabric configuration")
+	}
+	for _, want := range []string{
+		"fabric.mspId must be set",
+		"fabric.certPath must be set",
+		"fabric.keyPath: " + cert + " is not a directory",
+		"fabric.tlsCertPath: " + dir + " is a directory",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+
+	cfg, err := Load("test", []string{"-cert-path", dir, "-key-path", dir, "-tls-cert-path", cert})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Fabric.Channel != "mychannel" {
+		t.Errorf("channel = %q, want the default", cfg.Fabric.Channel)
+	}
+}
+
+func TestDigestMounts(t *testing.T) {
+	clearEnv(t)
+	dir := t.TempDir()
+	t.Setenv("EXT4BD_DIGEST_MOUNTS", "a="+dir+",b="+dir)
+
+	cfg, err := Load("test", []string{"-backend", "memory", "-digest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Digest.Mounts) != 2 || cfg.Digest.Mounts["a"] != dir || cfg.Digest.Mounts["b"] != dir {
+		t.Errorf("mounts = %v", cfg.Digest.Mounts)
+	}
+
+	_, err = Load("test", []string{"-backend", "memory", "-digest", "-digest-mounts", "a=" + filepath.Join(dir, "missing")})
+	if err == nil || !strings.Contains(err.Error(), "digest.mounts.a") {
+		t.Errorf("Load = %v, want an error about digest.mounts.a", err)
+	}
+}
@@ -0,0 +1,248 @@
+// Package digest records content digests of regular files after they have
+// been written.
+package digest
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/backend"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/config"
+	"golang.org/x/sys/unix"
+)
+
+// queueSize is the number of files that may wait for a worker. Triggers
+// arriving while the queue is full are dropped.
+const queueSize = 1024
+
+// ErrChanged is returned when a file was modified while being hashed. The
+// close that ends the modification triggers a new digest.
+var ErrChanged = errors.New("file changed while being hashed")
+
+// Service hashes files on a pool of workers and records the digests. A
+// trigger for an inode that is still queued is dropped, as the queued
+// digest will see the newer content anyway.
+type Service struct {
+	b      backend.Backend
+	mounts map[string]*os.File
+	paths  map[string]string
+	queue  chan job
+
+	mu      sync.Mutex
+	pending map[common.InodeRef]bool
+}
+
+type job struct {
+	ref common.InodeRef
+	// file is the file to hash; nil if it has to be opened by handle.
+	file *os.File
+}
+
+// New opens the configured mounts. The workers are started by Start.
+func New(b backend.Backend, cfg *config.DigestConfig) (*Service, error) {
+	s := &Service{
+		b:       b,
+		mounts:  make(map[string]*os.File),
+		paths:   cfg.Mounts,
+		queue:   make(chan job, queueSize),
+		pending: make(map[common.InodeRef]bool),
+	}
+	for uuid, path := range cfg.Mounts {
+		if _, err := common.ParseUUID(uuid); err != nil {
+			s.Close()
+			return nil, err
+		}
+		mount, err := os.Open(path)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to open mount of %s: %w", uuid, err)
+		}
+		s.mounts[uuid] = mount
+	}
+	return s, nil
+}
+
+func (s *Service) Start(workers int) {
+	for range max(workers, 1) {
+		go s.worker()
+	}
+}
+
+// Close releases the mounts.
+func (s *Service) Close() {
+	for _, mount := range s.mounts {
+		mount.Close()
+	}
+}
+
+// Request queues a digest of the inode, which is opened through the mount
+// of its filesystem.
+func (s *Service) Request(ref common.InodeRef) {
+	s.submit(job{ref: ref})
+}
+
+func (s *Service) submit(j job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending[j.ref] {
+		j.close()
+		return
+	}
+
+	select {
+	case s.queue <- j:
+		s.pending[j.ref] = true
+	default:
+		log.Printf("digest: queue full, dropping %v", j.ref)
+		j.close()
+	}
+}
+
+func (j job) close() {
+	if j.file != nil {
+		j.file.Close()
+	}
+}
+
+func (s *Service) worker() {
+	for j := range s.queue {
+		s.mu.Lock()
+		delete(s.pending, j.ref)
+		s.mu.Unlock()
+
+		if err := s.process(j); err != nil {
+			log.Printf("digest: %v: %v", j.ref, err)
+		}
+	}
+}
+
+func (s *Service) process(j job) error {
+	f := j.file
+	if f == nil {
+		var err error
+		f, err = s.open(j.ref)
+		if err != nil {
+			return err
+		}
+	}
+	defer f.Close()
+
+	var st unix.Stat_t
+	if err := unix.Fstat(int(f.Fd()), &st); err != nil {
+		return err
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFREG {
+		return nil
+	}
+
+	digest, err := Compute(f)
+	if err != nil {
+		return err
+	}
+	return s.b.RecordDigest(j.ref, digest)
+}
+
+// fileidIno32Gen is FILEID_INO32_GEN, the file handle type of ext4: the
+// 32-bit inode number followed by the generation.
+const fileidIno32Gen = 1
+
+// open opens an inode by file handle, which needs CAP_DAC_READ_SEARCH.
+func (s *Service) open(ref common.InodeRef) (*os.File, error) {
+	mount, ok := s.mounts[ref.FsUUID]
+	if !ok {
+		return nil, fmt.Errorf("no mount configured for filesystem %s", ref.FsUUID)
+	}
+	if ref.Ino > math.MaxUint32 {
+		return nil, fmt.Errorf("inode number %d does not fit a file handle", ref.Ino)
+	}
+
+	handle := make([]byte, 8)
+	binary.NativeEndian.PutUint32(handle[0:], uint32(ref.Ino))
+	binary.NativeEndian.PutUint32(handle[4:], ref.Generation)
+
+	// O_NONBLOCK keeps a FIFO from blocking the worker; it does not
+	// affect reads of regular files.
+	flags := unix.O_RDONLY | unix.O_CLOEXEC | unix.O_NONBLOCK | unix.O_NOCTTY
+	fd, err := unix.OpenByHandleAt(int(mount.Fd()), unix.NewFileHandle(fileidIno32Gen, handle), flags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open by handle: %w", err)
+	}
+	return os.NewFile(uintptr(fd), ref.String()), nil
+}
+
+// Compute returns the fs-verity digest of f if it has one, and the SHA-256
+// of its content otherwise. It fails with ErrChanged if the mtime of f
+// changes in the meantime.
+func Compute(f *os.File) (*common.ContentDigest, error) {
+	before, err := mtime(f)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := &common.ContentDigest{Mtime: before}
+	digest.Digest, err = measureVerity(f)
+	switch {
+	case err == nil:
+		digest.Algorithm = common.DigestFsverity
+	case errors.Is(err, unix.ENODATA), errors.Is(err, unix.ENOTTY), errors.Is(err, unix.EOPNOTSUPP):
+		digest.Algorithm = common.DigestSHA256
+		digest.Digest, err = hashContent(f)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	after, err := mtime(f)
+	if err != nil {
+		return nil, err
+	}
+	if after != before {
+		return nil, ErrChanged
+	}
+	return digest, nil
+}
+
+func mtime(f *os.File) (common.Time, error) {
+	var st unix.Stat_t
+	if err := unix.Fstat(int(f.Fd()), &st); err != nil {
+		return common.Time{}, err
+	}
+	return common.Time{Sec: st.Mtim.Sec, Nsec: uint32(st.Mtim.Nsec)}, nil
+}
+
+func hashContent(f *os.File) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, math.MaxInt64)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// measureVerity reads the fs-verity digest of f with FS_IOC_MEASURE_VERITY,
+// which fills a struct fsverity_digest: the algorithm and the size of the
+// digest as two u16, followed by the digest.
+func measureVerity(f *os.File) ([]byte, error) {
+	buf := make([]byte, 4+sha256.Size)
+	binary.NativeEndian.PutUint16(buf[2:], sha256.Size)
+
+	if err := ioctlPtr(f, unix.FS_IOC_MEASURE_VERITY, buf); err != nil {
+		return nil, err
+	}
+
+	if alg := binary.NativeEndian.Uint16(buf[0:]); alg != unix.FS_VERITY_HASH_ALG_SHA256 {
+		return nil, fmt.Errorf("unsupported fs-verity hash algorithm %d", alg)
+	}
+	size := binary.NativeEndian.Uint16(buf[2:])
+	return buf[4 : 4+size], nil
+}
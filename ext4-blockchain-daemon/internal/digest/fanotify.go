@@ -0,0 +1,84 @@
+package digest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+	"golang.org/x/sys/unix"
+)
+
+// WatchFanotify starts watching every configured mount for files closed
+// after a write and queues a digest of each. It needs CAP_SYS_ADMIN.
+func (s *Service) WatchFanotify() error {
+	for uuid, path := range s.paths {
+		fd, err := unix.FanotifyInit(unix.FAN_CLASS_NOTIF|unix.FAN_CLOEXEC, unix.O_RDONLY|unix.O_LARGEFILE|unix.O_CLOEXEC)
+		if err != nil {
+			return fmt.Errorf("fanotify_init: %w", err)
+		}
+
+		err = unix.FanotifyMark(fd, unix.FAN_MARK_ADD|unix.FAN_MARK_MOUNT, unix.FAN_CLOSE_WRITE, unix.AT_FDCWD, path)
+		if err != nil {
+			unix.Close(fd)
+			return fmt.Errorf("fanotify_mark %s: %w", path, err)
+		}
+
+		log.Printf("digest: watching %s (%s)", path, uuid)
+		go s.readFanotify(uuid, os.NewFile(uintptr(fd), "fanotify:"+path))
+	}
+	return nil
+}
+
+func (s *Service) readFanotify(fsUUID string, f *os.File) {
+	defer f.Close()
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			log.Printf("digest: failed to read fanotify events: %v", err)
+			return
+		}
+
+		events := buf[:n]
+		for len(events) > 0 {
+			var meta unix.FanotifyEventMetadata
+			if err := binary.Read(bytes.NewReader(events), binary.NativeEndian, &meta); err != nil {
+				log.Printf("digest: malformed fanotify event: %v", err)
+				break
+			}
+			if meta.Vers != unix.FANOTIFY_METADATA_VERSION || meta.Event_len == 0 || int(meta.Event_len) > len(events) {
+				log.Printf("digest: unexpected fanotify event version %d", meta.Vers)
+				break
+			}
+			events = events[meta.Event_len:]
+
+			if meta.Fd >= 0 {
+				s.handleFanotify(fsUUID, os.NewFile(uintptr(meta.Fd), "fanotify event"))
+			}
+		}
+	}
+}
+
+func (s *Service) handleFanotify(fsUUID string, f *os.File) {
+	var st unix.Stat_t
+	if err := unix.Fstat(int(f.Fd()), &st); err != nil || st.Mode&unix.S_IFMT != unix.S_IFREG {
+		f.Close()
+		return
+	}
+
+	generation, err := generation(f)
+	if err != nil {
+		log.Printf("digest: failed to read generation of inode %d: %v", st.Ino, err)
+		f.Close()
+		return
+	}
+
+	s.submit(job{
+		ref:  common.InodeRef{FsUUID: fsUUID, Ino: st.Ino, Generation: generation},
+		file: f,
+	})
+}
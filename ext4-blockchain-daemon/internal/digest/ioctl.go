@@ -0,0 +1,31 @@
+package digest
+
+import (
+	"os"
+	"strconv"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// fsIocGetversion is FS_IOC_GETVERSION, _IOR('v', 1, long), which
+// x/sys/unix does not define. The encoding is the asm-generic one.
+const fsIocGetversion = 2<<30 | (strconv.IntSize/8)<<16 | 'v'<<8 | 1
+
+// ioctlPtr performs an ioctl whose argument points at buf.
+func ioctlPtr(f *os.File, req uint, buf []byte) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), uintptr(req), uintptr(unsafe.Pointer(&buf[0])))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// generation returns the i_generation of the inode of f.
+func generation(f *os.File) (uint32, error) {
+	generation, err := unix.IoctlGetInt(int(f.Fd()), fsIocGetversion)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(generation), nil
+}
@@ -0,0 +1,369 @@
+// Package enroll records the inodes of an existing filesystem on the
+// ledger, so that files created before the kernel module was loaded are
+// tracked too.
+package enroll
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/fsinfo"
+	"golang.org/x/sys/unix"
+)
+
+// Ledger is the part of fabric.Backend an enrollment writes to.
+type Ledger interface {
+	// CreateInodes creates many inodes in one transaction, skipping and
+	// returning those that already exist.
+	CreateInodes(inodes []*common.Attrs) (existing []common.InodeRef, err error)
+}
+
+// ErrIncomplete is returned, wrapped, by an enrollment that walked the whole
+// filesystem but could not read some of its entries.
+var ErrIncomplete = errors.New("some entries could not be enrolled")
+
+// Options tune an enrollment.
+type Options struct {
+	// BatchSize is the number of inodes created per transaction.
+	BatchSize int
+	// CheckpointPath is the file recording the last enrolled path and the
+	// entries that could not be read. An enrollment finding a checkpoint
+	// of the same filesystem and mount retries those entries and resumes
+	// after that path. The file is removed once every entry is enrolled.
+	CheckpointPath string
+}
+
+// Progress reports how far an enrollment got.
+type Progress struct {
+	Scanned  int `json:"scanned"`
+	Enrolled int `json:"enrolled"`
+	// Existing counts inodes that were on the ledger already, either
+	// created by the daemon or by an interrupted enrollment.
+	Existing int `json:"existing"`
+	// Errors counts entries that could not be read. They are kept in the
+	// checkpoint and retried by the next enrollment.
+	Errors int `json:"errors"`
+	// Retried counts the entries of the checkpoint that were retried.
+	Retried int `json:"retried"`
+	Batches int `json:"batches"`
+	// ResumedAfter is the path the enrollment resumed after, if any.
+	ResumedAfter string `json:"resumedAfter,omitempty"`
+	// LastPath is the last path committed to the ledger, relative to the
+	// mount.
+	LastPath string `json:"lastPath,omitempty"`
+	Done     bool   `json:"done"`
+}
+
+// checkpoint is the content of Options.CheckpointPath.
+type checkpoint struct {
+	FsUUID   string `json:"fsUuid"`
+	Root     string `json:"root"`
+	LastPath string `json:"lastPath"`
+	// Walked is set once the walk reached the end of the filesystem, so
+	// that only Failed is left to enroll.
+	Walked bool `json:"walked,omitempty"`
+	// Failed lists the entries that could not be read, relative to the
+	// mount. A directory stands for everything below it.
+	Failed []string `json:"failed,omitempty"`
+}
+
+type entry struct {
+	path  string
+	attrs *common.Attrs
+}
+
+type enrollment struct {
+	ledger   Ledger
+	fsUUID   string
+	root     string
+	opts     Options
+	report   func(*Progress) error
+	progress Progress
+	batch    []entry
+
+	rootStat unix.Statx_t
+	seen     map[uint64]bool
+	// retry holds the failed entries of the checkpoint not retried yet,
+	// and failed those that failed during this enrollment.
+	retry  []string
+	failed []string
+	walked bool
+}
+
+// Enroll creates a ledger record for every inode of the filesystem fsUUID
+// mounted at root, without crossing into other mounts. It refuses to start
+// if root is on another filesystem. report, if not nil, is called after
+// every committed batch; an error it returns stops the enrollment. The
+// daemon should be running while enrolling, so that changes made meanwhile
+// are recorded too.
+//
+// Entries that cannot be read are logged and skipped, and Enroll returns
+// ErrIncomplete once the walk is over.
+func Enroll(ledger Ledger, fsUUID, root string, opts Options, report func(*Progress) error) (*Progress, error) {
+	if opts.BatchSize <= 0 {
+		return nil, fmt.Errorf("invalid batch size %d", opts.BatchSize)
+	}
+
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := fsinfo.CheckUUID(root, fsUUID); err != nil {
+		return nil, err
+	}
+
+	e := &enrollment{ledger: ledger, fsUUID: fsUUID, root: root, opts: opts, report: report, seen: make(map[uint64]bool)}
+	c, err := e.readCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	e.progress.ResumedAfter = c.LastPath
+	e.progress.LastPath = c.LastPath
+	e.retry = c.Failed
+	e.walked = c.Walked
+
+	if err := statx(root, &e.rootStat); err != nil {
+		return nil, err
+	}
+
+	// The failed entries come before the checkpoint, so the walk below
+	// skips them. Each one is committed before it leaves the checkpoint.
+	for len(e.retry) > 0 {
+		if err := e.walk(filepath.Join(root, e.retry[0]), ""); err != nil {
+			return &e.progress, err
+		}
+		if err := e.flush(); err != nil {
+			return &e.progress, err
+		}
+		e.retry = e.retry[1:]
+		e.progress.Retried++
+	}
+
+	if !e.walked {
+		if err := e.walk(root, c.LastPath); err != nil {
+			return &e.progress, err
+		}
+		if err := e.flush(); err != nil {
+			return &e.progress, err
+		}
+		e.walked = true
+	}
+
+	if len(e.failed) > 0 {
+		if err := e.writeCheckpoint(); err != nil {
+			return &e.progress, err
+		}
+		return &e.progress, fmt.Errorf("%w: %d entries failed, run again to retry them", ErrIncomplete, len(e.failed))
+	}
+
+	if opts.CheckpointPath != "" {
+		if err := os.Remove(opts.CheckpointPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return &e.progress, err
+		}
+	}
+
+	e.progress.Done = true
+	if report != nil {
+		if err := report(&e.progress); err != nil {
+			return &e.progress, err
+		}
+	}
+	return &e.progress, nil
+}
+
+// walk adds the inodes from start down to the batch, skipping everything up
+// to resume.
+func (e *enrollment) walk(start, resume string) error {
+	return filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
+		rel, relErr := filepath.Rel(e.root, path)
+		if relErr != nil {
+			return relErr
+		}
+		if err != nil {
+			e.fail(rel, err)
+			return nil
+		}
+
+		// WalkDir visits the entries in the order comparePaths defines,
+		// so everything up to the checkpoint was enrolled before.
+		if resume != "" && comparePaths(rel, resume) <= 0 {
+			if d.IsDir() && rel != resume && !isAncestor(rel, resume) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		var stx unix.Statx_t
+		if err := statx(path, &stx); err != nil {
+			e.fail(rel, err)
+			return nil
+		}
+
+		if stx.Dev_major != e.rootStat.Dev_major || stx.Dev_minor != e.rootStat.Dev_minor {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		e.progress.Scanned++
+		if e.seen[stx.Ino] {
+			return nil
+		}
+		e.seen[stx.Ino] = true
+
+		attrs, err := inodeAttrs(e.fsUUID, path, &stx)
+		if err != nil {
+			e.fail(rel, err)
+			return nil
+		}
+
+		e.batch = append(e.batch, entry{path: rel, attrs: attrs})
+		if len(e.batch) >= e.opts.BatchSize {
+			return e.flush()
+		}
+		return nil
+	})
+}
+
+// fail records an entry to retry. A directory that cannot be read is
+// reported after the directory itself was enrolled; retrying it walks it
+// again, which finds the directory on the ledger. Entries removed since
+// they were listed are not retried, the daemon records their removal.
+func (e *enrollment) fail(rel string, err error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	log.Printf("enroll: %v", err)
+	e.progress.Errors++
+	if n := len(e.failed); n > 0 && (e.failed[n-1] == rel || isAncestor(e.failed[n-1], rel)) {
+		return
+	}
+	e.failed = append(e.failed, rel)
+}
+
+// flush commits the pending batch and moves the checkpoint past it.
+func (e *enrollment) flush() error {
+	if len(e.batch) == 0 {
+		return nil
+	}
+
+	inodes := make([]*common.Attrs, len(e.batch))
+	for i, entry := range e.batch {
+		inodes[i] = entry.attrs
+	}
+
+	existing, err := e.ledger.CreateInodes(inodes)
+	if err != nil {
+		return fmt.Errorf("failed to enroll the batch ending at %s: %w", e.batch[len(e.batch)-1].path, err)
+	}
+
+	e.progress.Batches++
+	e.progress.Existing += len(existing)
+	e.progress.Enrolled += len(inodes) - len(existing)
+	// Retried entries lie before the checkpoint, which stays where it is.
+	if len(e.retry) == 0 {
+		e.progress.LastPath = e.batch[len(e.batch)-1].path
+	}
+	e.batch = e.batch[:0]
+
+	if err := e.writeCheckpoint(); err != nil {
+		return err
+	}
+	if e.report != nil {
+		return e.report(&e.progress)
+	}
+	return nil
+}
+
+// readCheckpoint returns the checkpoint to resume from, empty to start
+// over.
+func (e *enrollment) readCheckpoint() (*checkpoint, error) {
+	var c checkpoint
+	if e.opts.CheckpointPath == "" {
+		return &c, nil
+	}
+
+	data, err := os.ReadFile(e.opts.CheckpointPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return &c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read enrollment checkpoint: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid enrollment checkpoint %s: %w", e.opts.CheckpointPath, err)
+	}
+	if c.FsUUID != e.fsUUID || c.Root != e.root {
+		return nil, fmt.Errorf("enrollment checkpoint %s belongs to %s mounted at %s", e.opts.CheckpointPath, c.FsUUID, c.Root)
+	}
+	return &c, nil
+}
+
+// writeCheckpoint replaces the checkpoint file atomically.
+func (e *enrollment) writeCheckpoint() error {
+	if e.opts.CheckpointPath == "" {
+		return nil
+	}
+
+	c := checkpoint{
+		FsUUID:   e.fsUUID,
+		Root:     e.root,
+		LastPath: e.progress.LastPath,
+		Walked:   e.walked,
+		Failed:   append(append([]string(nil), e.retry...), e.failed...),
+	}
+	data, err := json.Marshal(&c)
+	if err != nil {
+		return err
+	}
+
+	tmp := e.opts.CheckpointPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write enrollment checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, e.opts.CheckpointPath); err != nil {
+		return fmt.Errorf("failed to write enrollment checkpoint: %w", err)
+	}
+	return nil
+}
+
+// comparePaths orders relative paths the way WalkDir visits them: a
+// directory before its entries, and entries by name.
+func comparePaths(a, b string) int {
+	as, bs := splitPath(a), splitPath(b)
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := strings.Compare(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	return len(as) - len(bs)
+}
+
+func isAncestor(dir, path string) bool {
+	ds, ps := splitPath(dir), splitPath(path)
+	if len(ds) >= len(ps) {
+		return false
+	}
+	for i := range ds {
+		if ds[i] != ps[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func splitPath(p string) []string {
+	if p == "." || p == "" {
+		return nil
+	}
+	return strings.Split(p, string(filepath.Separator))
+}
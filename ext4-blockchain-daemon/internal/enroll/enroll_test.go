@@ -0,0 +1,125 @@
+package enroll
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testUUID = "0f0e0d0c-0b0a-0908-0706-050403020100"
+
+func TestComparePathsFollowsWalkDir(t *testing.T) {
+	root := t.TempDir()
+	for _, p := range []string{"a/b/c", "a/b-c", "a.b/c", "a0", "ab/c", "b"} {
+		if err := os.MkdirAll(filepath.Join(root, p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range []string{"a/b/file", "a/z", "a-", "a.b/c/file"} {
+		if err := os.WriteFile(filepath.Join(root, p), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var visited []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		visited = append(visited, rel)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Comparing whole strings would put "a-" and "a.b" before "a/b".
+	for i := 1; i < len(visited); i++ {
+		if c := comparePaths(visited[i-1], visited[i]); c >= 0 {
+			t.Errorf("comparePaths(%q, %q) = %d, want < 0", visited[i-1], visited[i], c)
+		}
+		if c := comparePaths(visited[i], visited[i-1]); c <= 0 {
+			t.Errorf("comparePaths(%q, %q) = %d, want > 0", visited[i], visited[i-1], c)
+		}
+	}
+	if c := comparePaths("a/b", "a/b"); c != 0 {
+		t.Errorf("comparePaths of equal paths = %d", c)
+	}
+}
+
+func TestIsAncestor(t *testing.T) {
+	tests := []struct {
+		dir, path string
+		want      bool
+	}{
+		{".", "a", true},
+		{"a", "a/b", true},
+		{"a", "a/b/c", true},
+		{"a/b", "a/b", false},
+		{"a", "ab/c", false},
+		{"a/b", "a", false},
+		{"a/b", "a/c", false},
+	}
+	for _, tt := range tests {
+		if got := isAncestor(tt.dir, tt.path); got != tt.want {
+			t.Errorf("isAncestor(%q, %q) = %v, want %v", tt.dir, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	e := &enrollment{fsUUID: testUUID, root: "/mnt", opts: Options{CheckpointPath: path}}
+
+	c, err := e.readCheckpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.LastPath != "" || c.Walked || len(c.Failed) != 0 {
+		t.Errorf("checkpoint without a file = %+v, want an empty one", c)
+	}
+
+	e.progress.LastPath = "a/b"
+	e.retry = []string{"a/locked"}
+	e.failed = []string{"a/b/gone"}
+	if err := e.writeCheckpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = e.readCheckpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.LastPath != "a/b" || c.Walked {
+		t.Errorf("checkpoint = %+v", c)
+	}
+	if want := []string{"a/locked", "a/b/gone"}; !slices.Equal(c.Failed, want) {
+		t.Errorf("failed = %q, want %q", c.Failed, want)
+	}
+
+	other := &enrollment{fsUUID: testUUID, root: "/srv", opts: Options{CheckpointPath: path}}
+	if _, err := other.readCheckpoint(); err == nil || !strings.Contains(err.Error(), "belongs to") {
+		t.Errorf("checkpoint of another mount: %v", err)
+	}
+}
+
+func TestFailKeepsOnePathPerSubtree(t *testing.T) {
+	e := &enrollment{}
+	e.fail("a", fs.ErrPermission)
+	e.fail("a/b", fs.ErrPermission)
+	e.fail("a", fs.ErrPermission)
+	e.fail("c", &fs.PathError{Op: "statx", Path: "c", Err: fs.ErrNotExist})
+	e.fail("d", errors.New("ioctl failed"))
+
+	if want := []string{"a", "d"}; !slices.Equal(e.failed, want) {
+		t.Errorf("failed = %q, want %q", e.failed, want)
+	}
+	if e.progress.Errors != 4 {
+		t.Errorf("errors = %d, want 4: removed entries are not errors", e.progress.Errors)
+	}
+}
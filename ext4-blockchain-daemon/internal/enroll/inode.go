@@ -0,0 +1,89 @@
+package enroll
+
+import (
+	"encoding/binary"
+	"io/fs"
+	"unsafe"
+
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/fsinfo"
+	"golang.org/x/sys/unix"
+)
+
+// fsIocFsgetxattr is FS_IOC_FSGETXATTR, _IOR('X', 31, struct fsxattr),
+// which x/sys/unix does not define.
+const fsIocFsgetxattr = 2<<30 | fsxattrSize<<16 | 'X'<<8 | 31
+
+// fsxattrSize is the size of struct fsxattr; fsx_projid is at offset 12.
+const fsxattrSize = 28
+
+func statx(path string, stx *unix.Statx_t) error {
+	mask := unix.STATX_BASIC_STATS | unix.STATX_BTIME
+	err := unix.Statx(unix.AT_FDCWD, path, unix.AT_SYMLINK_NOFOLLOW, mask, stx)
+	if err != nil {
+		return &fs.PathError{Op: "statx", Path: path, Err: err}
+	}
+	return nil
+}
+
+// inodeAttrs returns the attributes of the inode at path, as the kernel
+// module would send them on creation.
+func inodeAttrs(fsUUID, path string, stx *unix.Statx_t) (*common.Attrs, error) {
+	generation, err := fsinfo.Generation(path, stx.Ino)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := &common.Attrs{
+		Uid:        stx.Uid,
+		Gid:        stx.Gid,
+		Atime:      common.Time{Sec: stx.Atime.Sec, Nsec: stx.Atime.Nsec},
+		Mtime:      common.Time{Sec: stx.Mtime.Sec, Nsec: stx.Mtime.Nsec},
+		Ctime:      common.Time{Sec: stx.Ctime.Sec, Nsec: stx.Ctime.Nsec},
+		Mode:       uint32(stx.Mode),
+		Ino:        stx.Ino,
+		FsUUID:     fsUUID,
+		Generation: generation,
+		Size:       stx.Size,
+		Nlink:      stx.Nlink,
+		Blocks:     stx.Blocks,
+		Valid:      common.EXT4B_VALID_ALL,
+	}
+	if stx.Mask&unix.STATX_BTIME != 0 {
+		attrs.Crtime = common.Time{Sec: stx.Btime.Sec, Nsec: stx.Btime.Nsec}
+	}
+
+	// Flags and project IDs need an open file, and opening device nodes
+	// may have side effects, so they are only read for regular files and
+	// directories.
+	switch stx.Mode & unix.S_IFMT {
+	case unix.S_IFREG, unix.S_IFDIR:
+		attrs.Flags, attrs.Projid, err = flagsAndProjid(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return attrs, nil
+}
+
+func flagsAndProjid(path string) (uint32, uint32, error) {
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return 0, 0, &fs.PathError{Op: "open", Path: path, Err: err}
+	}
+	defer unix.Close(fd)
+
+	flags, err := unix.IoctlGetUint32(fd, unix.FS_IOC_GETFLAGS)
+	if err != nil {
+		return 0, 0, &fs.PathError{Op: "FS_IOC_GETFLAGS", Path: path, Err: err}
+	}
+
+	var fsxattr [fsxattrSize]byte
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), fsIocFsgetxattr, uintptr(unsafe.Pointer(&fsxattr[0])))
+	if errno != 0 {
+		return 0, 0, &fs.PathError{Op: "FS_IOC_FSGETXATTR", Path: path, Err: errno}
+	}
+
+	return flags, binary.NativeEndian.Uint32(fsxattr[12:]), nil
+}
@@ -0,0 +1,48 @@
+package ext4
+
+import (
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+)
+
+// batch collects SETATTR requests of one worker. Requests for the same inode
+// are merged into a single update, but each of them is still answered.
+type batch struct {
+	order   []common.InodeRef
+	updates map[common.InodeRef]*common.Attrs
+	pending map[common.InodeRef]int
+}
+
+func newBatch() *batch {
+	return &batch{
+		updates: make(map[common.InodeRef]*common.Attrs),
+		pending: make(map[common.InodeRef]int),
+	}
+}
+
+func (b *batch) add(attrs *common.Attrs) {
+	ref := attrs.Ref()
+	if update, ok := b.updates[ref]; ok {
+		update.Merge(attrs)
+	} else {
+		update := *attrs
+		b.updates[ref] = &update
+		b.order = append(b.order, ref)
+	}
+	b.pending[ref]++
+}
+
+func (b *batch) contains(ref common.InodeRef) bool {
+	_, ok := b.updates[ref]
+	return ok
+}
+
+// len returns the number of distinct inodes in the batch.
+func (b *batch) len() int {
+	return len(b.order)
+}
+
+func (b *batch) reset() {
+	b.order = b.order[:0]
+	clear(b.updates)
+	clear(b.pending)
+}
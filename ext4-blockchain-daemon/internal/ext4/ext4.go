@@ -5,11 +5,11 @@ import (
 	"log"
 	"os"
 
-	"github.com/hyperledger/fabric-gateway/pkg/client"
 	"github.com/mdlayher/genetlink"
 	"github.com/mdlayher/netlink"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/backend"
 	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
-	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/fabric"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/config"
 )
 
 func NewConn() (*genetlink.Conn, genetlink.Family, error) {
@@ -36,7 +36,17 @@ func NewConn() (*genetlink.Conn, genetlink.Family, error) {
 	return c, family, nil
 }
 
-func Listen(c *genetlink.Conn, family genetlink.Family, contract *client.Contract) error {
+// Listen receives requests from the kernel and hands them to a pool of
+// worker goroutines. closeWrite, if not nil, is called for every
+// EXT4B_CMD_CLOSE_WRITE_NOTIFY; it must not block.
+func Listen(c *genetlink.Conn, family genetlink.Family, b backend.Backend, cfg *config.DaemonConfig, closeWrite func(common.InodeRef)) error {
+	p := newPool(c, family, b, poolConfig{
+		workers:     cfg.Workers,
+		batchWindow: cfg.BatchWindow,
+		batchSize:   cfg.BatchSize,
+	})
+	defer p.close()
+
 	for {
 		msgs, _, err := c.Receive()
 		if err != nil {
@@ -45,38 +55,43 @@ func Listen(c *genetlink.Conn, family genetlink.Family, contract *client.Contrac
 
 		for _, msg := range msgs {
 			switch msg.Header.Command {
-			case common.EXT4B_CMD_NEW_INODE_REQUEST:
+			case common.EXT4B_CMD_NEW_INODE_REQUEST, common.EXT4B_CMD_SETATTR_REQUEST:
 				attributes, err := common.DecodeAttributes(msg.Data)
 				if err != nil {
 					log.Fatalf("failed to decode attributes: %v", err)
 				}
-				status := fabric.NewInode(attributes, contract)
-				err = sendStatusResponse(c, family, attributes.Ino, status)
+				p.dispatch(&request{cmd: msg.Header.Command, ref: attributes.Ref(), attrs: attributes})
+
+			case common.EXT4B_CMD_GETATTR_REQUEST, common.EXT4B_CMD_DELETE_INODE_REQUEST:
+				ref, err := common.DecodeInodeRef(msg.Data)
 				if err != nil {
-					log.Printf("failed to send: ino=%v, status=%v", attributes.Ino, status)
+					log.Fatalf("failed to decode ino: %v", err)
 				}
+				p.dispatch(&request{cmd: msg.Header.Command, ref: ref})
 
-			case common.EXT4B_CMD_SETATTR_REQUEST:
-				attributes, err := common.DecodeAttributes(msg.Data)
+			case common.EXT4B_CMD_SETXATTR_REQUEST, common.EXT4B_CMD_REMOVEXATTR_REQUEST:
+				x, err := common.DecodeXattr(msg.Data)
 				if err != nil {
-					log.Fatalf("failed to decode attributes: %v", err)
+					log.Fatalf("failed to decode xattr: %v", err)
 				}
-				status := fabric.SetAttributes(attributes, contract)
-				err = sendStatusResponse(c, family, attributes.Ino, status)
+				p.dispatch(&request{cmd: msg.Header.Command, ref: x.Ref, xattr: x})
+
+			case common.EXT4B_CMD_DENTRY_REQUEST, common.EXT4B_CMD_RENAME_REQUEST:
+				change, err := common.DecodeDentryChange(msg.Header.Command, msg.Data)
 				if err != nil {
-					log.Printf("failed to send: ino=%v, status=%v", attributes.Ino, status)
+					log.Fatalf("failed to decode dentry: %v", err)
 				}
+				p.dispatch(&request{cmd: msg.Header.Command, ref: change.Ref, dentry: change})
 
-			case common.EXT4B_CMD_GETATTR_REQUEST:
-				ino, err := common.DecodeIno(msg.Data)
-				if err != nil {
-					log.Fatalf("failed to decode ino: %v", err)
+			case common.EXT4B_CMD_CLOSE_WRITE_NOTIFY:
+				if closeWrite == nil {
+					continue
 				}
-				status, attributes := fabric.GetAttributes(ino, contract)
-				err = sendGetAttributesResponse(c, family, attributes, status)
+				ref, err := common.DecodeInodeRef(msg.Data)
 				if err != nil {
-					log.Printf("failed to send: ino=%v, status=%v", ino, status)
+					log.Fatalf("failed to decode ino: %v", err)
 				}
+				closeWrite(ref)
 			}
 		}
 	}
@@ -99,9 +114,11 @@ func sendSetPid(c *genetlink.Conn, family genetlink.Family) error {
 	return nil
 }
 
-func sendStatusResponse(c *genetlink.Conn, family genetlink.Family, ino uint64, status uint16) error {
+func sendStatusResponse(c *genetlink.Conn, family genetlink.Family, ref common.InodeRef, status uint16) error {
 	ae := netlink.NewAttributeEncoder()
-	ae.Uint64(common.EXT4B_ATTR_INO, ino)
+	if err := common.EncodeInodeRef(ae, ref); err != nil {
+		return err
+	}
 	ae.Uint16(common.EXT4B_ATTR_STATUS, status)
 
 	b, err := ae.Encode()
@@ -121,7 +138,7 @@ func sendStatusResponse(c *genetlink.Conn, family genetlink.Family, ino uint64,
 	if err != nil {
 		return err
 	}
-	log.Printf("sendStatusResponse: ino=%v, status=%v", ino, status)
+	log.Printf("sendStatusResponse: ino=%v, status=%v", ref, status)
 	return nil
 }
 
@@ -129,7 +146,9 @@ func sendGetAttributesResponse(c *genetlink.Conn, family genetlink.Family, respo
 	ae := netlink.NewAttributeEncoder()
 
 	ae.Uint16(common.EXT4B_ATTR_STATUS, status)
-	ae.Uint64(common.EXT4B_ATTR_INO, response.Ino)
+	if err := common.EncodeInodeRef(ae, response.Ref()); err != nil {
+		return err
+	}
 
 	if status == common.EXT4BD_STATUS_SUCCESS {
 		ae.Uint32(common.EXT4B_ATTR_MODE, response.Mode)
@@ -137,22 +156,35 @@ func sendGetAttributesResponse(c *genetlink.Conn, family genetlink.Family, respo
 		ae.Uint32(common.EXT4B_ATTR_GID, response.Gid)
 
 		ae.Nested(common.EXT4B_ATTR_ATIME, func(nae *netlink.AttributeEncoder) error {
-			nae.Uint64(common.EXT4B_TIME_ATTR_SEC, response.Atime.Sec)
+			nae.Int64(common.EXT4B_TIME_ATTR_SEC, response.Atime.Sec)
 			nae.Uint32(common.EXT4B_TIME_ATTR_NSEC, response.Atime.Nsec)
 			return nil
 		})
 
 		ae.Nested(common.EXT4B_ATTR_MTIME, func(nae *netlink.AttributeEncoder) error {
-			nae.Uint64(common.EXT4B_TIME_ATTR_SEC, response.Mtime.Sec)
+			nae.Int64(common.EXT4B_TIME_ATTR_SEC, response.Mtime.Sec)
 			nae.Uint32(common.EXT4B_TIME_ATTR_NSEC, response.Mtime.Nsec)
 			return nil
 		})
 
 		ae.Nested(common.EXT4B_ATTR_CTIME, func(nae *netlink.AttributeEncoder) error {
-			nae.Uint64(common.EXT4B_TIME_ATTR_SEC, response.Ctime.Sec)
+			nae.Int64(common.EXT4B_TIME_ATTR_SEC, response.Ctime.Sec)
 			nae.Uint32(common.EXT4B_TIME_ATTR_NSEC, response.Ctime.Nsec)
 			return nil
 		})
+
+		ae.Uint64(common.EXT4B_ATTR_SIZE, response.Size)
+		ae.Uint32(common.EXT4B_ATTR_NLINK, response.Nlink)
+		ae.Uint64(common.EXT4B_ATTR_BLOCKS, response.Blocks)
+
+		ae.Nested(common.EXT4B_ATTR_CRTIME, func(nae *netlink.AttributeEncoder) error {
+			nae.Int64(common.EXT4B_TIME_ATTR_SEC, response.Crtime.Sec)
+			nae.Uint32(common.EXT4B_TIME_ATTR_NSEC, response.Crtime.Nsec)
+			return nil
+		})
+
+		ae.Uint32(common.EXT4B_ATTR_FLAGS, response.Flags)
+		ae.Uint32(common.EXT4B_ATTR_PROJID, response.Projid)
 	}
 
 	b, err := ae.Encode()
@@ -175,3 +207,32 @@ func sendGetAttributesResponse(c *genetlink.Conn, family genetlink.Family, respo
 	log.Printf("sendGetattrResponse: status=%v", status)
 	return nil
 }
+
+// SendChangeNotification tells the kernel that the ledger record of ref was
+// changed by a transaction the kernel did not ask for.
+func SendChangeNotification(c *genetlink.Conn, family genetlink.Family, ref common.InodeRef) error {
+	ae := netlink.NewAttributeEncoder()
+	if err := common.EncodeInodeRef(ae, ref); err != nil {
+		return err
+	}
+
+	b, err := ae.Encode()
+	if err != nil {
+		return err
+	}
+
+	msg := genetlink.Message{
+		Header: genetlink.Header{
+			Command: common.EXT4B_CMD_CHANGE_NOTIFY,
+			Version: family.Version,
+		},
+		Data: b,
+	}
+
+	_, err = c.Send(msg, family.ID, netlink.Request)
+	if err != nil {
+		return err
+	}
+	log.Printf("sendChangeNotification: ino=%v", ref)
+	return nil
+}
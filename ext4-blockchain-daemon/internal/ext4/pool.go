@@ -0,0 +1,235 @@
+package ext4
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/genetlink"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/backend"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+)
+
+// queueSize is the number of requests a single worker may have pending
+// before Listen blocks on it.
+const queueSize = 64
+
+type request struct {
+	cmd    uint8
+	ref    common.InodeRef
+	attrs  *common.Attrs
+	xattr  *common.Xattr
+	dentry *common.DentryChange
+}
+
+// pool processes requests on a fixed number of workers. Requests are assigned
+// to workers by inode number, so all requests for one inode are handled by
+// the same worker, in the order they were received, while a slow transaction
+// only delays the inodes that share its worker.
+//
+// SETATTR requests are not applied one by one: each worker merges them per
+// inode for up to batchWindow, or until batchSize inodes are pending, and
+// applies them in a single transaction. Any other request for an inode with
+// a pending update flushes the batch first, which keeps the per-inode order.
+type pool struct {
+	c           *genetlink.Conn
+	family      genetlink.Family
+	b           backend.Backend
+	batchWindow time.Duration
+	batchSize   int
+	queues      []chan *request
+	wg          sync.WaitGroup
+}
+
+type poolConfig struct {
+	workers     int
+	batchWindow time.Duration
+	batchSize   int
+}
+
+func newPool(c *genetlink.Conn, family genetlink.Family, b backend.Backend, cfg poolConfig) *pool {
+	workers := max(cfg.workers, 1)
+
+	p := &pool{
+		c:           c,
+		family:      family,
+		b:           b,
+		batchWindow: cfg.batchWindow,
+		batchSize:   cfg.batchSize,
+		queues:      make([]chan *request, workers),
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan *request, queueSize)
+		p.wg.Add(1)
+		go p.worker(p.queues[i])
+	}
+	return p
+}
+
+func (p *pool) dispatch(req *request) {
+	p.queues[req.ref.Ino%uint64(len(p.queues))] <- req
+}
+
+// close waits for all queued requests to be handled.
+func (p *pool) close() {
+	for _, q := range p.queues {
+		close(q)
+	}
+	p.wg.Wait()
+}
+
+func (p *pool) batching() bool {
+	return p.batchWindow > 0 && p.batchSize > 1
+}
+
+func (p *pool) worker(queue <-chan *request) {
+	defer p.wg.Done()
+
+	pending := newBatch()
+	timer := time.NewTimer(p.batchWindow)
+	stopTimer(timer)
+
+	for {
+		select {
+		case req, ok := <-queue:
+			if !ok {
+				p.flush(pending)
+				return
+			}
+
+			if req.cmd == common.EXT4B_CMD_SETATTR_REQUEST && p.batching() {
+				pending.add(req.attrs)
+				if pending.len() == 1 {
+					timer.Reset(p.batchWindow)
+				}
+				if pending.len() >= p.batchSize {
+					stopTimer(timer)
+					p.flush(pending)
+				}
+				continue
+			}
+
+			if pending.contains(req.ref) {
+				stopTimer(timer)
+				p.flush(pending)
+			}
+			p.handle(req)
+
+		case <-timer.C:
+			p.flush(pending)
+		}
+	}
+}
+
+// stopTimer stops t and drains the tick it may have sent already, so that
+// a stale tick does not flush the next batch before its window is over.
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
+
+// flush applies the pending updates and answers every request merged into
+// them.
+func (p *pool) flush(pending *batch) {
+	if pending.len() == 0 {
+		return
+	}
+	defer pending.reset()
+
+	statuses := make(map[common.InodeRef]uint16, pending.len())
+	if pending.len() == 1 {
+		ref := pending.order[0]
+		statuses[ref] = backend.Status(p.b.UpdateInode(pending.updates[ref]))
+	} else {
+		updates := make([]*common.Attrs, 0, pending.len())
+		for _, ref := range pending.order {
+			updates = append(updates, pending.updates[ref])
+		}
+
+		missing, err := p.b.UpdateInodes(updates)
+		for _, ref := range pending.order {
+			statuses[ref] = backend.Status(err)
+		}
+		for _, ref := range missing {
+			statuses[ref] = common.EXT4BD_STATUS_INODE_NOT_FOUND
+		}
+	}
+
+	for _, ref := range pending.order {
+		status := statuses[ref]
+		for range pending.pending[ref] {
+			err := sendStatusResponse(p.c, p.family, ref, status)
+			if err != nil {
+				log.Printf("failed to send: ino=%v, status=%v", ref, status)
+			}
+		}
+	}
+}
+
+func (p *pool) handle(req *request) {
+	switch req.cmd {
+	case common.EXT4B_CMD_NEW_INODE_REQUEST:
+		status := backend.Status(p.b.CreateInode(req.attrs))
+		err := sendStatusResponse(p.c, p.family, req.ref, status)
+		if err != nil {
+			log.Printf("failed to send: ino=%v, status=%v", req.ref, status)
+		}
+
+	case common.EXT4B_CMD_SETATTR_REQUEST:
+		status := backend.Status(p.b.UpdateInode(req.attrs))
+		err := sendStatusResponse(p.c, p.family, req.ref, status)
+		if err != nil {
+			log.Printf("failed to send: ino=%v, status=%v", req.ref, status)
+		}
+
+	case common.EXT4B_CMD_DELETE_INODE_REQUEST:
+		status := backend.Status(p.b.DeleteInode(req.ref))
+		err := sendStatusResponse(p.c, p.family, req.ref, status)
+		if err != nil {
+			log.Printf("failed to send: ino=%v, status=%v", req.ref, status)
+		}
+
+	case common.EXT4B_CMD_SETXATTR_REQUEST:
+		// The reply to a malformed request goes through the worker of its
+		// inode like any other, so that replies keep their order.
+		status := common.EXT4BD_STATUS_FAIL
+		if req.xattr.Digest == nil {
+			log.Printf("setxattr request without value or digest: ino=%v, name=%s", req.ref, req.xattr.Name)
+		} else {
+			status = backend.Status(p.b.SetXattr(req.xattr))
+		}
+		err := sendStatusResponse(p.c, p.family, req.ref, status)
+		if err != nil {
+			log.Printf("failed to send: ino=%v, status=%v", req.ref, status)
+		}
+
+	case common.EXT4B_CMD_REMOVEXATTR_REQUEST:
+		status := backend.Status(p.b.RemoveXattr(req.ref, req.xattr.Name))
+		err := sendStatusResponse(p.c, p.family, req.ref, status)
+		if err != nil {
+			log.Printf("failed to send: ino=%v, status=%v", req.ref, status)
+		}
+
+	case common.EXT4B_CMD_DENTRY_REQUEST, common.EXT4B_CMD_RENAME_REQUEST:
+		status := backend.Status(p.b.ChangeDentry(req.dentry))
+		err := sendStatusResponse(p.c, p.family, req.ref, status)
+		if err != nil {
+			log.Printf("failed to send: ino=%v, status=%v", req.ref, status)
+		}
+
+	case common.EXT4B_CMD_GETATTR_REQUEST:
+		attributes, err := p.b.ReadInode(req.ref)
+		status := backend.Status(err)
+		if err != nil {
+			attributes = &common.Attrs{Ino: req.ref.Ino, FsUUID: req.ref.FsUUID, Generation: req.ref.Generation}
+		}
+		err = sendGetAttributesResponse(p.c, p.family, attributes, status)
+		if err != nil {
+			log.Printf("failed to send: ino=%v, status=%v", req.ref, status)
+		}
+	}
+}
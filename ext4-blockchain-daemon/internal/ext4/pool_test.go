@@ -0,0 +1,231 @@
+package ext4
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/genetlink"
+	"github.com/mdlayher/genetlink/genltest"
+	"github.com/mdlayher/netlink"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/backend"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+)
+
+const testUUID = "0f0e0d0c-0b0a-0908-0706-050403020100"
+
+// recorder logs the ledger calls and the replies of a pool in the order
+// they happen.
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) record(format string, args ...any) {
+	r.mu.Lock()
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+	r.mu.Unlock()
+}
+
+func (r *recorder) log() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.events)
+}
+
+// ledger implements the Backend calls the tests make. UpdateInodes reports
+// the inodes in missing as not found.
+type ledger struct {
+	backend.Backend
+	r       *recorder
+	missing map[uint64]bool
+}
+
+func describe(attrs *common.Attrs) string {
+	var fields []string
+	if attrs.Valid&common.EXT4B_VALID_UID != 0 {
+		fields = append(fields, fmt.Sprintf("uid=%d", attrs.Uid))
+	}
+	if attrs.Valid&common.EXT4B_VALID_MODE != 0 {
+		fields = append(fields, fmt.Sprintf("mode=%o", attrs.Mode))
+	}
+	return fmt.Sprintf("%d:%s", attrs.Ino, strings.Join(fields, ","))
+}
+
+func (l *ledger) UpdateInode(attrs *common.Attrs) error {
+	l.r.record("update %s", describe(attrs))
+	return nil
+}
+
+func (l *ledger) UpdateInodes(updates []*common.Attrs) ([]common.InodeRef, error) {
+	var described []string
+	var missing []common.InodeRef
+	for _, u := range updates {
+		described = append(described, describe(u))
+		if l.missing[u.Ino] {
+			missing = append(missing, u.Ref())
+		}
+	}
+	l.r.record("updateBatch %s", strings.Join(described, " "))
+	return missing, nil
+}
+
+func (l *ledger) DeleteInode(ref common.InodeRef) error {
+	l.r.record("delete %d", ref.Ino)
+	return nil
+}
+
+func testPool(t *testing.T, l *ledger, cfg poolConfig) *pool {
+	t.Helper()
+	c := genltest.Dial(func(greq genetlink.Message, _ netlink.Message) ([]genetlink.Message, error) {
+		if greq.Header.Command != common.EXT4B_CMD_STATUS_RESPONSE {
+			t.Errorf("unexpected command %d", greq.Header.Command)
+			return nil, nil
+		}
+
+		ref, err := common.DecodeInodeRef(greq.Data)
+		if err != nil {
+			t.Errorf("invalid response: %v", err)
+			return nil, nil
+		}
+		ad, err := netlink.NewAttributeDecoder(greq.Data)
+		if err != nil {
+			t.Errorf("invalid response: %v", err)
+			return nil, nil
+		}
+		var status uint16
+		for ad.Next() {
+			if ad.Type() == common.EXT4B_ATTR_STATUS {
+				status = ad.Uint16()
+			}
+		}
+		l.r.record("reply %d %d", ref.Ino, status)
+		return nil, nil
+	})
+	t.Cleanup(func() { c.Close() })
+
+	return newPool(c, genetlink.Family{ID: 0x20, Version: 1}, l, cfg)
+}
+
+func setattr(ino uint64, valid uint32, uid, mode uint32) *request {
+	attrs := &common.Attrs{FsUUID: testUUID, Ino: ino, Generation: 1, Uid: uid, Mode: mode, Valid: valid}
+	return &request{cmd: common.EXT4B_CMD_SETATTR_REQUEST, ref: attrs.Ref(), attrs: attrs}
+}
+
+func waitForEvents(t *testing.T, r *recorder, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(r.log()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d events, got %v", n, r.log())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPoolMergesSetattrPerInode(t *testing.T) {
+	r := &recorder{}
+	p := testPool(t, &ledger{r: r}, poolConfig{workers: 1, batchWindow: time.Hour, batchSize: 10})
+
+	p.dispatch(setattr(1, common.EXT4B_VALID_UID, 1000, 0))
+	p.dispatch(setattr(2, common.EXT4B_VALID_MODE, 0, 0o100755))
+	p.dispatch(setattr(1, common.EXT4B_VALID_MODE, 0, 0o100600))
+	// Any other request for a pending inode flushes the batch first.
+	p.dispatch(&request{cmd: common.EXT4B_CMD_DELETE_INODE_REQUEST, ref: common.InodeRef{FsUUID: testUUID, Ino: 1, Generation: 1}})
+	p.close()
+
+	want := []string{
+		"updateBatch 1:uid=1000,mode=100600 2:mode=100755",
+		"reply 1 0",
+		"reply 1 0",
+		"reply 2 0",
+		"delete 1",
+		"reply 1 0",
+	}
+	if got := r.log(); !slices.Equal(got, want) {
+		t.Errorf("events:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestPoolFlushesFullBatch(t *testing.T) {
+	r := &recorder{}
+	p := testPool(t, &ledger{r: r}, poolConfig{workers: 1, batchWindow: time.Hour, batchSize: 2})
+	defer p.close()
+
+	p.dispatch(setattr(1, common.EXT4B_VALID_UID, 1, 0))
+	p.dispatch(setattr(2, common.EXT4B_VALID_UID, 2, 0))
+	waitForEvents(t, r, 3)
+
+	want := []string{"updateBatch 1:uid=1 2:uid=2", "reply 1 0", "reply 2 0"}
+	if got := r.log(); !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestPoolFlushesAfterWindow(t *testing.T) {
+	r := &recorder{}
+	p := testPool(t, &ledger{r: r}, poolConfig{workers: 1, batchWindow: 10 * time.Millisecond, batchSize: 10})
+	defer p.close()
+
+	for i := 0; i < 2; i++ {
+		p.dispatch(setattr(1, common.EXT4B_VALID_UID, uint32(i), 0))
+		waitForEvents(t, r, 2*(i+1))
+	}
+
+	want := []string{"update 1:uid=0", "reply 1 0", "update 1:uid=1", "reply 1 0"}
+	if got := r.log(); !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestPoolRepliesNotFoundForMissingInodes(t *testing.T) {
+	r := &recorder{}
+	p := testPool(t, &ledger{r: r, missing: map[uint64]bool{2: true}}, poolConfig{workers: 1, batchWindow: time.Hour, batchSize: 10})
+
+	p.dispatch(setattr(1, common.EXT4B_VALID_UID, 1, 0))
+	p.dispatch(setattr(2, common.EXT4B_VALID_UID, 2, 0))
+	p.close()
+
+	want := []string{
+		"updateBatch 1:uid=1 2:uid=2",
+		"reply 1 0",
+		fmt.Sprintf("reply 2 %d", common.EXT4BD_STATUS_INODE_NOT_FOUND),
+	}
+	if got := r.log(); !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestPoolWithoutBatching(t *testing.T) {
+	r := &recorder{}
+	// A single worker: the sockets of genltest do not take concurrent
+	// sends.
+	p := testPool(t, &ledger{r: r}, poolConfig{workers: 1})
+
+	p.dispatch(setattr(1, common.EXT4B_VALID_UID, 1, 0))
+	p.dispatch(setattr(1, common.EXT4B_VALID_MODE, 0, 0o100644))
+	p.close()
+
+	want := []string{"update 1:uid=1", "reply 1 0", "update 1:mode=100644", "reply 1 0"}
+	if got := r.log(); !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestPoolRejectsSetxattrWithoutDigest(t *testing.T) {
+	r := &recorder{}
+	p := testPool(t, &ledger{r: r}, poolConfig{workers: 1})
+
+	ref := common.InodeRef{FsUUID: testUUID, Ino: 1, Generation: 1}
+	p.dispatch(&request{cmd: common.EXT4B_CMD_SETXATTR_REQUEST, ref: ref, xattr: &common.Xattr{Ref: ref, Name: "user.test"}})
+	p.close()
+
+	// The ledger is not called, and the request is answered anyway.
+	want := []string{fmt.Sprintf("reply 1 %d", common.EXT4BD_STATUS_FAIL)}
+	if got := r.log(); !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
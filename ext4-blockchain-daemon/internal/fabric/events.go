@@ -0,0 +1,148 @@
+package fabric
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+)
+
+const assetChangedEvent = "AssetChanged"
+
+// eventRetryInterval is how long ListenEvents waits before reconnecting
+// after the event stream failed.
+const eventRetryInterval = 5 * time.Second
+
+type Submitter struct {
+	MSPID string `json:"mspId"`
+	ID    string `json:"id"`
+}
+
+type AssetChange struct {
+	Ref    common.InodeRef
+	Fields []string
+}
+
+// AssetEvent is the payload of the AssetChanged chaincode event.
+type AssetEvent struct {
+	TransactionID string
+	BlockNumber   uint64
+	Type          string
+	Submitter     Submitter
+	Changes       []AssetChange
+}
+
+// Checkpointer records the position of the last processed event, so that
+// ListenEvents can resume after it.
+type Checkpointer interface {
+	client.Checkpoint
+	CheckpointChaincodeEvent(event *client.ChaincodeEvent) error
+	Close() error
+}
+
+// NewCheckpointer returns a Checkpointer persisted in the file at path, or
+// one kept in memory if path is empty. The latter only lets ListenEvents
+// resume within a single run of the daemon.
+func NewCheckpointer(path string) (Checkpointer, error) {
+	if path == "" {
+		return &memoryCheckpointer{}, nil
+	}
+
+	checkpointer, err := client.NewFileCheckpointer(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event checkpoint %s: %w", path, err)
+	}
+	return checkpointer, nil
+}
+
+type memoryCheckpointer struct {
+	client.InMemoryCheckpointer
+}
+
+func (c *memoryCheckpointer) CheckpointChaincodeEvent(event *client.ChaincodeEvent) error {
+	c.InMemoryCheckpointer.CheckpointChaincodeEvent(event)
+	return nil
+}
+
+func (c *memoryCheckpointer) Close() error {
+	return nil
+}
+
+// ListenEvents delivers the AssetChanged events of chaincode to handle until
+// ctx is cancelled. Each event is checkpointed once handle returns, and the
+// stream is reopened from the checkpoint whenever it fails.
+func ListenEvents(ctx context.Context, network *client.Network, chaincode string, checkpointer Checkpointer, handle func(*AssetEvent)) error {
+	for {
+		err := listenEvents(ctx, network, chaincode, checkpointer, handle)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("fabric: chaincode event stream failed, retrying in %v: %v", eventRetryInterval, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(eventRetryInterval):
+		}
+	}
+}
+
+func listenEvents(ctx context.Context, network *client.Network, chaincode string, checkpointer Checkpointer, handle func(*AssetEvent)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events, err := network.ChaincodeEvents(ctx, chaincode, client.WithCheckpoint(checkpointer))
+	if err != nil {
+		return err
+	}
+	log.Printf("fabric: listening for chaincode events from block %d", checkpointer.BlockNumber())
+
+	for event := range events {
+		if event.EventName == assetChangedEvent {
+			assetEvent, err := parseAssetEvent(event)
+			if err != nil {
+				log.Printf("fabric: ignoring malformed event in transaction %s: %v", event.TransactionID, err)
+			} else {
+				handle(assetEvent)
+			}
+		}
+
+		err := checkpointer.CheckpointChaincodeEvent(event)
+		if err != nil {
+			return fmt.Errorf("failed to checkpoint event: %w", err)
+		}
+	}
+	return fmt.Errorf("event stream closed")
+}
+
+func parseAssetEvent(event *client.ChaincodeEvent) (*AssetEvent, error) {
+	var payload struct {
+		Type      string    `json:"type"`
+		Submitter Submitter `json:"submitter"`
+		Changes   []struct {
+			assetKey
+			Fields []string `json:"fields"`
+		} `json:"changes"`
+	}
+
+	err := json.Unmarshal(event.Payload, &payload)
+	if err != nil {
+		return nil, err
+	}
+
+	assetEvent := &AssetEvent{
+		TransactionID: event.TransactionID,
+		BlockNumber:   event.BlockNumber,
+		Type:          payload.Type,
+		Submitter:     payload.Submitter,
+		Changes:       make([]AssetChange, 0, len(payload.Changes)),
+	}
+	for _, change := range payload.Changes {
+		assetEvent.Changes = append(assetEvent.Changes, AssetChange{Ref: change.ref(), Fields: change.Fields})
+	}
+	return assetEvent, nil
+}
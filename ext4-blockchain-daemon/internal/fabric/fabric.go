@@ -1,92 +1,92 @@
 package fabric
 
 import (
+	"crypto/rand"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/hyperledger/fabric-gateway/pkg/client"
 	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/backend"
 	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/config"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/status"
 )
 
-const (
-	mspID        = "Org1MSP"
-	cryptoPath   = "/home/przem/go/src/github.com/przemyslawS99/fabric-samples/test-network/organizations/peerOrganizations/org1.example.com"
-	certPath     = cryptoPath + "/users/User1@org1.example.com/msp/signcerts"
-	keyPath      = cryptoPath + "/users/User1@org1.example.com/msp/keystore"
-	tlsCertPath  = cryptoPath + "/peers/peer0.org1.example.com/tls/ca.crt"
-	peerEndpoint = "dns:///localhost:7051"
-	gatewayPeer  = "peer0.org1.example.com"
-)
-
-func NewGrpcConnection() *grpc.ClientConn {
-	certificatePEM, err := os.ReadFile(tlsCertPath)
+func NewGrpcConnection(cfg *config.FabricConfig) (*grpc.ClientConn, error) {
+	certificatePEM, err := os.ReadFile(cfg.TLSCertPath)
 	if err != nil {
-		panic(fmt.Errorf("failed to read TLS certifcate file: %w", err))
+		return nil, fmt.Errorf("failed to read TLS certificate file: %w", err)
 	}
 
 	certificate, err := identity.CertificateFromPEM(certificatePEM)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("failed to parse TLS certificate %s: %w", cfg.TLSCertPath, err)
 	}
 
 	certPool := x509.NewCertPool()
 	certPool.AddCert(certificate)
-	transportCredentials := credentials.NewClientTLSFromCert(certPool, gatewayPeer)
+	transportCredentials := credentials.NewClientTLSFromCert(certPool, cfg.GatewayPeer)
 
-	connection, err := grpc.NewClient(peerEndpoint, grpc.WithTransportCredentials(transportCredentials))
+	connection, err := grpc.NewClient(cfg.PeerEndpoint, grpc.WithTransportCredentials(transportCredentials))
 	if err != nil {
-		panic(fmt.Errorf("failed to create gRPC connection: %w", err))
+		return nil, fmt.Errorf("failed to create gRPC connection to %s: %w", cfg.PeerEndpoint, err)
 	}
 
-	return connection
+	return connection, nil
 }
 
-func NewIdentity() *identity.X509Identity {
-	certificatePEM, err := readFirstFile(certPath)
+func NewIdentity(cfg *config.FabricConfig) (*identity.X509Identity, error) {
+	certificatePEM, err := readFirstFile(cfg.CertPath)
 	if err != nil {
-		panic(fmt.Errorf("failed to read certificate file: %w", err))
+		return nil, fmt.Errorf("failed to read certificate file: %w", err)
 	}
 
 	certificate, err := identity.CertificateFromPEM(certificatePEM)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("failed to parse certificate in %s: %w", cfg.CertPath, err)
 	}
 
-	id, err := identity.NewX509Identity(mspID, certificate)
+	id, err := identity.NewX509Identity(cfg.MSPID, certificate)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("failed to create identity for %s: %w", cfg.MSPID, err)
 	}
 
-	return id
+	return id, nil
 }
 
-func NewSign() identity.Sign {
-	privateKeyPEM, err := readFirstFile(keyPath)
+func NewSign(cfg *config.FabricConfig) (identity.Sign, error) {
+	privateKeyPEM, err := readFirstFile(cfg.KeyPath)
 	if err != nil {
-		panic(fmt.Errorf("failed to read private key file: %w", err))
+		return nil, fmt.Errorf("failed to read private key file: %w", err)
 	}
 
 	privateKey, err := identity.PrivateKeyFromPEM(privateKeyPEM)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("failed to parse private key in %s: %w", cfg.KeyPath, err)
 	}
 
 	sign, err := identity.NewPrivateKeySign(privateKey)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("failed to create signer: %w", err)
 	}
 
-	return sign
+	return sign, nil
 }
 
 func readFirstFile(dirPath string) ([]byte, error) {
@@ -94,172 +94,684 @@ func readFirstFile(dirPath string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer dir.Close()
 
 	fileNames, err := dir.Readdirnames(1)
 	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s is empty", dirPath)
+		}
 		return nil, err
 	}
 
 	return os.ReadFile(path.Join(dirPath, fileNames[0]))
 }
 
-func NewInode(attrs *common.Attrs, contract *client.Contract) uint16 {
-	log.Printf("fabric: NewInode %v", attrs.Ino)
-	args := convertAttrs(attrs)
+// Connect opens a gateway connection as the identity described by cfg. The
+// returned function closes the gateway and the underlying gRPC connection.
+func Connect(cfg *config.FabricConfig) (*client.Gateway, func(), error) {
+	clientConnection, err := NewGrpcConnection(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	id, err := NewIdentity(cfg)
+	if err != nil {
+		clientConnection.Close()
+		return nil, nil, err
+	}
+
+	sign, err := NewSign(cfg)
+	if err != nil {
+		clientConnection.Close()
+		return nil, nil, err
+	}
+
+	gw, err := client.Connect(
+		id,
+		client.WithSign(sign),
+		client.WithClientConnection(clientConnection),
+		client.WithEvaluateTimeout(cfg.Timeouts.Evaluate),
+		client.WithEndorseTimeout(cfg.Timeouts.Endorse),
+		client.WithSubmitTimeout(cfg.Timeouts.Submit),
+		client.WithCommitStatusTimeout(cfg.Timeouts.CommitStatus),
+	)
+	if err != nil {
+		clientConnection.Close()
+		return nil, nil, fmt.Errorf("failed to connect to gateway: %w", err)
+	}
+
+	return gw, func() {
+		gw.Close()
+		clientConnection.Close()
+	}, nil
+}
+
+// Backend records inodes through the ext4 chaincode.
+type Backend struct {
+	contract *client.Contract
+	// privateOwnership keeps uid, gid and mode out of public state by
+	// passing them to the chaincode in the transient map.
+	privateOwnership bool
+}
+
+func NewBackend(contract *client.Contract, privateOwnership bool) *Backend {
+	return &Backend{contract: contract, privateOwnership: privateOwnership}
+}
+
+// ownershipTransientKey is the transient map entry through which uid, gid
+// and mode are passed in private mode.
+const ownershipTransientKey = "ownership"
+
+// ownershipSaltSize is the size of the salt of private ownership records.
+const ownershipSaltSize = 32
+
+// publicAttrs returns the attributes to pass as transaction arguments,
+// which leave out uid, gid and mode in private mode.
+func (b *Backend) publicAttrs(attrs *common.Attrs) *common.Attrs {
+	if !b.privateOwnership {
+		return attrs
+	}
+	public := *attrs
+	public.Uid = 0
+	public.Gid = 0
+	public.Mode = 0
+	return &public
+}
+
+// submitAttrs submits a transaction recording attrs. In private mode their
+// uid, gid and mode are passed in the transient map, each record with a
+// fresh random salt so that its public hash cannot be reversed by trying
+// every uid, gid and mode.
+func (b *Backend) submitAttrs(name string, args []string, attrs ...*common.Attrs) ([]byte, error) {
+	if !b.privateOwnership {
+		return b.contract.SubmitTransaction(name, args...)
+	}
+
+	records := make([]ownership, len(attrs))
+	for i, a := range attrs {
+		salt := make([]byte, ownershipSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("failed to generate salt: %w", err)
+		}
+		records[i] = ownership{
+			FsUUID:     a.FsUUID,
+			Ino:        a.Ino,
+			Generation: a.Generation,
+			Uid:        a.Uid,
+			Gid:        a.Gid,
+			Mode:       a.Mode,
+			Salt:       salt,
+		}
+	}
+
+	recordsJSON, err := json.Marshal(records)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.contract.Submit(name,
+		client.WithArguments(args...),
+		client.WithTransient(map[string][]byte{ownershipTransientKey: recordsJSON}),
+	)
+}
+
+func (b *Backend) CreateInode(attrs *common.Attrs) error {
+	log.Printf("fabric: NewInode %v", attrs.Ref())
+	args := convertAttrs(b.publicAttrs(attrs))
 	log.Printf("args: %v", args)
-	_, err := contract.SubmitTransaction("CreateAsset", args...)
+	_, err := b.submitAttrs("CreateAsset", args, attrs)
 	if err != nil {
-		log.Printf("failed to submit transaction: %v", err)
-		return common.EXT4BD_STATUS_FAIL
+		return handleError(err)
+	}
+
+	log.Printf("transaction committed successfully")
+	return nil
+}
+
+func (b *Backend) UpdateInode(attrs *common.Attrs) error {
+	log.Printf("fabric: SetAttributes %v", attrs.Ref())
+	args := append(convertAttrs(b.publicAttrs(attrs)), formatUint(uint64(attrs.Valid)))
+	_, err := b.submitAttrs("UpdateAsset", args, attrs)
+	if err != nil {
+		return handleError(err)
+	}
+
+	log.Printf("transaction committed successfully")
+	return nil
+}
+
+func (b *Backend) UpdateInodes(updates []*common.Attrs) ([]common.InodeRef, error) {
+	log.Printf("fabric: BatchUpdateAssets %d inodes", len(updates))
+	assets := make([]assetUpdate, len(updates))
+	for i, attrs := range updates {
+		assets[i] = toAssetUpdate(b.publicAttrs(attrs))
+	}
+
+	assetsJSON, err := json.Marshal(assets)
+	if err != nil {
+		return nil, err
+	}
+
+	submitResult, err := b.submitAttrs("BatchUpdateAssets", []string{string(assetsJSON)}, updates...)
+	if err != nil {
+		return nil, handleError(err)
+	}
+
+	var keys []assetKey
+	if err := json.Unmarshal(submitResult, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse BatchUpdateAssets result: %w", err)
+	}
+
+	missing := make([]common.InodeRef, 0, len(keys))
+	for _, key := range keys {
+		missing = append(missing, key.ref())
+	}
+
+	log.Printf("transaction committed successfully")
+	return missing, nil
+}
+
+// CreateInodes creates many inodes in a single transaction. Inodes that
+// already exist are skipped and returned.
+func (b *Backend) CreateInodes(inodes []*common.Attrs) ([]common.InodeRef, error) {
+	log.Printf("fabric: BatchCreateAssets %d inodes", len(inodes))
+	assets := make([]asset, len(inodes))
+	for i, attrs := range inodes {
+		assets[i] = toAsset(b.publicAttrs(attrs))
+	}
+
+	assetsJSON, err := json.Marshal(assets)
+	if err != nil {
+		return nil, err
+	}
+
+	submitResult, err := b.submitAttrs("BatchCreateAssets", []string{string(assetsJSON)}, inodes...)
+	if err != nil {
+		return nil, handleError(err)
+	}
+
+	var keys []assetKey
+	if err := json.Unmarshal(submitResult, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse BatchCreateAssets result: %w", err)
+	}
+
+	existing := make([]common.InodeRef, 0, len(keys))
+	for _, key := range keys {
+		existing = append(existing, key.ref())
+	}
+
+	log.Printf("transaction committed successfully")
+	return existing, nil
+}
+
+func (b *Backend) ReadInode(ref common.InodeRef) (*common.Attrs, error) {
+	log.Printf("fabric: GetAttributes %v", ref)
+
+	evaluateResult, err := b.contract.EvaluateTransaction("ReadAsset", refArgs(ref)...)
+	if err != nil {
+		return nil, handleError(err)
+	}
+
+	asset, err := decodeAsset(evaluateResult)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := asset.attrs()
+	if asset.Private != nil {
+		err = b.readOwnership(attrs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("transaction evaluated successfully")
+	return attrs, nil
+}
+
+// readOwnership fills in the uid, gid and mode of attrs from the private
+// data collection.
+func (b *Backend) readOwnership(attrs *common.Attrs) error {
+	evaluateResult, err := b.contract.EvaluateTransaction("ReadAssetOwnership", refArgs(attrs.Ref())...)
+	if err != nil {
+		return handleError(err)
+	}
+
+	var record ownership
+	err = json.Unmarshal(evaluateResult, &record)
+	if err != nil {
+		return fmt.Errorf("failed to parse ReadAssetOwnership result: %w", err)
+	}
+
+	attrs.Uid = record.Uid
+	attrs.Gid = record.Gid
+	attrs.Mode = record.Mode
+	return nil
+}
+
+func (b *Backend) DeleteInode(ref common.InodeRef) error {
+	log.Printf("fabric: DeleteAsset %v", ref)
+	_, err := b.contract.SubmitTransaction("DeleteAsset", refArgs(ref)...)
+	if err != nil {
+		return handleError(err)
 	}
 
 	log.Printf("transaction committed successfully")
-	return common.EXT4BD_STATUS_SUCCESS
+	return nil
 }
 
-func SetAttributes(attrs *common.Attrs, contract *client.Contract) uint16 {
-	log.Printf("fabric: SetAttributes %v", attrs.Ino)
-	args := convertAttrs(attrs)
-	_, err := contract.SubmitTransaction("UpdateAsset", args...)
+func (b *Backend) InodeHistory(ref common.InodeRef) ([]backend.HistoryEntry, error) {
+	log.Printf("fabric: GetAssetHistory %v", ref)
+
+	evaluateResult, err := b.contract.EvaluateTransaction("GetAssetHistory", refArgs(ref)...)
+	if err != nil {
+		return nil, handleError(err)
+	}
+
+	var entries []struct {
+		TxID      string          `json:"txId"`
+		Timestamp time.Time       `json:"timestamp"`
+		IsDelete  bool            `json:"isDelete"`
+		Asset     json.RawMessage `json:"asset"`
+	}
+	err = json.Unmarshal(evaluateResult, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse GetAssetHistory result: %w", err)
+	}
+
+	history := make([]backend.HistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		h := backend.HistoryEntry{
+			TxID:      entry.TxID,
+			Timestamp: entry.Timestamp,
+			IsDelete:  entry.IsDelete,
+		}
+		if !entry.IsDelete {
+			h.Attrs, h.Tombstone, err = parseAsset(entry.Asset)
+			if err != nil {
+				return nil, err
+			}
+		}
+		history = append(history, h)
+	}
+
+	return history, nil
+}
+
+func (b *Backend) SetXattr(x *common.Xattr) error {
+	log.Printf("fabric: SetXattr %v %s", x.Ref, x.Name)
+	var value string
+	if x.Value != nil {
+		value = base64.StdEncoding.EncodeToString(x.Value)
+	}
+	args := append(refArgs(x.Ref), x.Name, hex.EncodeToString(x.Digest), value)
+	_, err := b.contract.SubmitTransaction("SetXattr", args...)
 	if err != nil {
 		return handleError(err)
 	}
 
 	log.Printf("transaction committed successfully")
-	return common.EXT4BD_STATUS_SUCCESS
+	return nil
 }
 
-func GetAttributes(ino uint64, contract *client.Contract) (uint16, *common.Attrs) {
-	log.Printf("fabric: GetAttributes %v", ino)
+func (b *Backend) RemoveXattr(ref common.InodeRef, name string) error {
+	log.Printf("fabric: RemoveXattr %v %s", ref, name)
+	_, err := b.contract.SubmitTransaction("RemoveXattr", append(refArgs(ref), name)...)
+	if err != nil {
+		return handleError(err)
+	}
+
+	log.Printf("transaction committed successfully")
+	return nil
+}
 
-	var ret uint16
-	var attrs *common.Attrs
-	var result string
+func (b *Backend) XattrHistory(ref common.InodeRef, name string) ([]backend.XattrHistoryEntry, error) {
+	log.Printf("fabric: GetXattrHistory %v %s", ref, name)
 
-	evaluateResult, err := contract.EvaluateTransaction("ReadAsset", fmt.Sprintf("%d", ino))
+	evaluateResult, err := b.contract.EvaluateTransaction("GetXattrHistory", append(refArgs(ref), name)...)
 	if err != nil {
-		ret = handleError(err)
-		goto err_out
+		return nil, handleError(err)
 	}
 
-	attrs, err = parseAttrs(evaluateResult)
+	var entries []struct {
+		TxID      string    `json:"txId"`
+		Timestamp time.Time `json:"timestamp"`
+		Xattr     xattr     `json:"xattr"`
+	}
+	err = json.Unmarshal(evaluateResult, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse GetXattrHistory result: %w", err)
+	}
+
+	history := make([]backend.XattrHistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		x, err := entry.Xattr.parse()
+		if err != nil {
+			return nil, err
+		}
+
+		h := backend.XattrHistoryEntry{
+			TxID:      entry.TxID,
+			Timestamp: entry.Timestamp,
+			Xattr:     x,
+		}
+		if entry.Xattr.Removed != nil {
+			h.Removed = entry.Xattr.Removed.toBackend()
+		}
+		history = append(history, h)
+	}
+
+	return history, nil
+}
+
+func (b *Backend) ChangeDentry(change *common.DentryChange) error {
+	log.Printf("fabric: ChangeDentry %v op=%d", change.Ref, change.Op)
+
+	args := refArgs(change.Ref)
+	var function string
+	switch change.Op {
+	case common.EXT4B_DENTRY_OP_CREATE, common.EXT4B_DENTRY_OP_LINK:
+		function = "LinkAsset"
+		args = append(args, dentryArgs(change.New)...)
+	case common.EXT4B_DENTRY_OP_UNLINK:
+		function = "UnlinkAsset"
+		args = append(args, dentryArgs(change.Old)...)
+	case common.EXT4B_DENTRY_OP_RENAME:
+		function = "RenameAsset"
+		args = append(args, dentryArgs(change.Old)...)
+		args = append(args, dentryArgs(change.New)...)
+	default:
+		return fmt.Errorf("invalid dentry operation %d", change.Op)
+	}
+
+	_, err := b.contract.SubmitTransaction(function, args...)
 	if err != nil {
-		ret = common.EXT4BD_STATUS_FAIL
-		goto err_out
+		return handleError(err)
 	}
 
-	log.Printf("transaction committed successfully: %s", result)
-	ret = common.EXT4BD_STATUS_SUCCESS
+	log.Printf("transaction committed successfully")
+	return nil
+}
 
-	return ret, attrs
+func (b *Backend) RecordDigest(ref common.InodeRef, digest *common.ContentDigest) error {
+	log.Printf("fabric: RecordContentDigest %v %s", ref, digest.Algorithm)
+	mtimeSec, mtimeNsec := formatTime(digest.Mtime)
+	args := append(refArgs(ref), digest.Algorithm, hex.EncodeToString(digest.Digest), mtimeSec, mtimeNsec)
+	_, err := b.contract.SubmitTransaction("RecordContentDigest", args...)
+	if err != nil {
+		return handleError(err)
+	}
 
-err_out:
-	return ret, &common.Attrs{Ino: ino}
+	log.Printf("transaction committed successfully")
+	return nil
 }
 
-func handleError(err error) uint16 {
+// handleError logs a failed transaction and translates the chaincode errors
+// the daemon cares about into backend errors.
+func handleError(err error) error {
 	var endorseErr *client.EndorseError
 	if errors.As(err, &endorseErr) {
 		log.Printf("Endorse error for transaction %s with gRPC status %v: %s", endorseErr.TransactionID, status.Code(endorseErr), endorseErr)
-		return common.EXT4BD_STATUS_INODE_NOT_FOUND
 	} else {
 		log.Printf("failed to submit transaction: %v", err)
-		return common.EXT4BD_STATUS_FAIL
 	}
-}
 
-func formatUint32(value uint32) string {
-	if value == 0 {
-		return ""
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return fmt.Errorf("%w: %v", backend.ErrUnavailable, err)
+	}
+
+	message := chaincodeMessage(err)
+	switch {
+	case strings.Contains(message, "access denied"):
+		return fmt.Errorf("%w: %v", backend.ErrPermissionDenied, err)
+	case strings.Contains(message, "does not exist"):
+		return fmt.Errorf("%w: %v", backend.ErrNotFound, err)
+	case strings.Contains(message, "already exists"):
+		return fmt.Errorf("%w: %v", backend.ErrExists, err)
+	default:
+		return err
 	}
-	return strconv.FormatUint(uint64(value), 10)
 }
 
-func formatUint64(value uint64) string {
-	if value == 0 {
-		return ""
+// chaincodeMessage collects the gRPC status message together with the
+// messages of the attached error details, which is where the gateway puts
+// the error returned by the chaincode.
+func chaincodeMessage(err error) string {
+	st := status.Convert(err)
+	messages := []string{st.Message()}
+	for _, detail := range st.Details() {
+		if d, ok := detail.(*gateway.ErrorDetail); ok {
+			messages = append(messages, d.GetMessage())
+		}
 	}
+	return strings.Join(messages, "; ")
+}
+
+func formatUint(value uint64) string {
 	return strconv.FormatUint(value, 10)
 }
 
 func formatTime(t common.Time) (string, string) {
-	return formatUint64(t.Sec), formatUint32(t.Nsec)
+	return strconv.FormatInt(t.Sec, 10), formatUint(uint64(t.Nsec))
 }
 
+// convertAttrs returns the arguments of CreateAsset, which are also the
+// leading arguments of UpdateAsset.
 func convertAttrs(attrs *common.Attrs) []string {
 	atimeSec, atimeNsec := formatTime(attrs.Atime)
 	mtimeSec, mtimeNsec := formatTime(attrs.Mtime)
 	ctimeSec, ctimeNsec := formatTime(attrs.Ctime)
+	crtimeSec, crtimeNsec := formatTime(attrs.Crtime)
 
 	return []string{
-		formatUint32(attrs.Uid),
-		formatUint32(attrs.Gid),
+		attrs.FsUUID,
+		formatUint(uint64(attrs.Uid)),
+		formatUint(uint64(attrs.Gid)),
 		atimeSec,
 		atimeNsec,
 		mtimeSec,
 		mtimeNsec,
 		ctimeSec,
 		ctimeNsec,
-		formatUint32(attrs.Mode),
-		formatUint64(attrs.Ino),
-	}
-}
-
-func parseAttrs(data []byte) (*common.Attrs, error) {
-	var asset struct {
-		Uid   string `json:"uid"`
-		Gid   string `json:"gid"`
-		Atime struct {
-			Sec  string `json:"sec"`
-			Nsec string `json:"nsec"`
-		} `json:"atime"`
-		Mtime struct {
-			Sec  string `json:"sec"`
-			Nsec string `json:"nsec"`
-		} `json:"mtime"`
-		Ctime struct {
-			Sec  string `json:"sec"`
-			Nsec string `json:"nsec"`
-		} `json:"ctime"`
-		Mode string `json:"mode"`
-		Ino  string `json:"ino"`
+		formatUint(uint64(attrs.Mode)),
+		formatUint(attrs.Ino),
+		formatUint(uint64(attrs.Generation)),
+		formatUint(attrs.Size),
+		formatUint(uint64(attrs.Nlink)),
+		formatUint(attrs.Blocks),
+		crtimeSec,
+		crtimeNsec,
+		formatUint(uint64(attrs.Flags)),
+		formatUint(uint64(attrs.Projid)),
+	}
+}
+
+// asset mirrors the Asset type of the chaincode.
+type asset struct {
+	SchemaVersion int         `json:"schemaVersion"`
+	Uid           uint32      `json:"uid"`
+	Gid           uint32      `json:"gid"`
+	Atime         common.Time `json:"atime"`
+	Mtime         common.Time `json:"mtime"`
+	Ctime         common.Time `json:"ctime"`
+	Mode          uint32      `json:"mode"`
+	Ino           uint64      `json:"ino"`
+	FsUUID        string      `json:"fsUuid"`
+	Generation    uint32      `json:"generation"`
+	Size          uint64      `json:"size"`
+	Nlink         uint32      `json:"nlink"`
+	Blocks        uint64      `json:"blocks"`
+	Crtime        common.Time `json:"crtime"`
+	Flags         uint32      `json:"flags"`
+	Projid        uint32      `json:"projid"`
+	// Private is set when uid, gid and mode are kept in a private data
+	// collection; they are zero here then.
+	Private *privateOwnership `json:"private,omitempty"`
+	// Deleted is only set on tombstones, which ReadAsset never returns.
+	Deleted *tombstone `json:"deleted,omitempty"`
+}
+
+// privateOwnership mirrors the PrivateOwnership type of the chaincode.
+type privateOwnership struct {
+	Collection string `json:"collection"`
+	Hash       string `json:"hash"`
+}
+
+// ownership mirrors the Ownership type of the chaincode, the private
+// record of uid, gid and mode.
+type ownership struct {
+	FsUUID     string `json:"fsUuid"`
+	Ino        uint64 `json:"ino"`
+	Generation uint32 `json:"generation"`
+	Uid        uint32 `json:"uid"`
+	Gid        uint32 `json:"gid"`
+	Mode       uint32 `json:"mode"`
+	Salt       []byte `json:"salt,omitempty"`
+}
+
+// assetUpdate mirrors the AssetUpdate type of the chaincode.
+type assetUpdate struct {
+	asset
+	Valid uint32 `json:"valid"`
+}
+
+type tombstone struct {
+	Time      time.Time `json:"time"`
+	Submitter Submitter `json:"submitter"`
+}
+
+func (t *tombstone) toBackend() *backend.Tombstone {
+	return &backend.Tombstone{
+		Time:      t.Time,
+		MSPID:     t.Submitter.MSPID,
+		Submitter: t.Submitter.ID,
 	}
+}
+
+// xattr mirrors the Xattr type of the chaincode.
+type xattr struct {
+	FsUUID     string     `json:"fsUuid"`
+	Ino        uint64     `json:"ino"`
+	Generation uint32     `json:"generation"`
+	Name       string     `json:"name"`
+	Digest     string     `json:"digest"`
+	Value      string     `json:"value"`
+	Removed    *tombstone `json:"removed,omitempty"`
+}
+
+func (x *xattr) parse() (*common.Xattr, error) {
+	digest, err := hex.DecodeString(x.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid digest of xattr %s: %w", x.Name, err)
+	}
+
+	value, err := base64.StdEncoding.DecodeString(x.Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value of xattr %s: %w", x.Name, err)
+	}
+
+	result := &common.Xattr{
+		Ref:  common.InodeRef{FsUUID: x.FsUUID, Ino: x.Ino, Generation: x.Generation},
+		Name: x.Name,
+	}
+	if len(digest) > 0 {
+		result.Digest = digest
+	}
+	if len(value) > 0 {
+		result.Value = value
+	}
+	return result, nil
+}
+
+func toAsset(attrs *common.Attrs) asset {
+	return asset{
+		Uid:        attrs.Uid,
+		Gid:        attrs.Gid,
+		Atime:      attrs.Atime,
+		Mtime:      attrs.Mtime,
+		Ctime:      attrs.Ctime,
+		Mode:       attrs.Mode,
+		Ino:        attrs.Ino,
+		FsUUID:     attrs.FsUUID,
+		Generation: attrs.Generation,
+		Size:       attrs.Size,
+		Nlink:      attrs.Nlink,
+		Blocks:     attrs.Blocks,
+		Crtime:     attrs.Crtime,
+		Flags:      attrs.Flags,
+		Projid:     attrs.Projid,
+	}
+}
+
+func toAssetUpdate(attrs *common.Attrs) assetUpdate {
+	return assetUpdate{asset: toAsset(attrs), Valid: attrs.Valid}
+}
+
+// assetKey mirrors the AssetKey type of the chaincode.
+type assetKey struct {
+	FsUUID     string `json:"fsUuid"`
+	Ino        uint64 `json:"ino"`
+	Generation uint32 `json:"generation"`
+}
+
+func (k assetKey) ref() common.InodeRef {
+	return common.InodeRef{FsUUID: k.FsUUID, Ino: k.Ino, Generation: k.Generation}
+}
+
+// refArgs returns the chaincode arguments identifying an asset.
+func refArgs(ref common.InodeRef) []string {
+	return []string{ref.FsUUID, formatUint(ref.Ino), formatUint(uint64(ref.Generation))}
+}
+
+// dentryArgs returns the chaincode arguments identifying a directory entry.
+func dentryArgs(dentry *common.Dentry) []string {
+	return []string{formatUint(dentry.Parent.Ino), formatUint(uint64(dentry.Parent.Generation)), dentry.Name}
+}
+
+func decodeAsset(data []byte) (*asset, error) {
+	var asset asset
 
 	err := json.Unmarshal(data, &asset)
 	if err != nil {
-		log.Printf("Failed to unmarshal asset: %v", err)
-		return nil, err
+		return nil, fmt.Errorf("failed to parse asset: %w", err)
+	}
+	return &asset, nil
+}
+
+// parseAsset parses a stored asset, returning its tombstone, if any, as well.
+func parseAsset(data []byte) (*common.Attrs, *backend.Tombstone, error) {
+	asset, err := decodeAsset(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var deleted *backend.Tombstone
+	if asset.Deleted != nil {
+		deleted = asset.Deleted.toBackend()
 	}
 
-	uid, _ := strconv.ParseUint(asset.Uid, 10, 32)
-	gid, _ := strconv.ParseUint(asset.Gid, 10, 32)
-	atimeSec, _ := strconv.ParseUint(asset.Atime.Sec, 10, 64)
-	atimeNsec, _ := strconv.ParseUint(asset.Atime.Nsec, 10, 32)
-	mtimeSec, _ := strconv.ParseUint(asset.Mtime.Sec, 10, 64)
-	mtimeNsec, _ := strconv.ParseUint(asset.Mtime.Nsec, 10, 32)
-	ctimeSec, _ := strconv.ParseUint(asset.Ctime.Sec, 10, 64)
-	ctimeNsec, _ := strconv.ParseUint(asset.Ctime.Nsec, 10, 32)
-	mode, _ := strconv.ParseUint(asset.Mode, 10, 32)
-	ino, _ := strconv.ParseUint(asset.Ino, 10, 64)
-
-	attrs := common.Attrs{
-		Uid: uint32(uid),
-		Gid: uint32(gid),
-		Atime: common.Time{
-			Sec:  atimeSec,
-			Nsec: uint32(atimeNsec),
-		},
-		Mtime: common.Time{
-			Sec:  mtimeSec,
-			Nsec: uint32(mtimeNsec),
-		},
-		Ctime: common.Time{
-			Sec:  ctimeSec,
-			Nsec: uint32(ctimeNsec),
-		},
-		Mode: uint32(mode),
-		Ino:  ino,
-	}
-
-	return &attrs, nil
+	return asset.attrs(), deleted, nil
+}
+
+func (asset *asset) attrs() *common.Attrs {
+	return &common.Attrs{
+		Uid:        asset.Uid,
+		Gid:        asset.Gid,
+		Atime:      asset.Atime,
+		Mtime:      asset.Mtime,
+		Ctime:      asset.Ctime,
+		Mode:       asset.Mode,
+		Ino:        asset.Ino,
+		FsUUID:     asset.FsUUID,
+		Generation: asset.Generation,
+		Size:       asset.Size,
+		Nlink:      asset.Nlink,
+		Blocks:     asset.Blocks,
+		Crtime:     asset.Crtime,
+		Flags:      asset.Flags,
+		Projid:     asset.Projid,
+		Valid:      common.EXT4B_VALID_ALL,
+	}
 }
@@ -0,0 +1,142 @@
+package fabric
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	fabriccommon "github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/backend"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+	"google.golang.org/protobuf/proto"
+)
+
+// Record is an asset as stored on the ledger, which may be a tombstone.
+type Record struct {
+	*common.Attrs
+	Deleted *backend.Tombstone `json:"deleted,omitempty"`
+}
+
+// RecordPage is one page of a paginated query. A page with fewer records
+// than requested is the last one; otherwise pass Bookmark to the next call.
+type RecordPage struct {
+	Records  []Record
+	Bookmark string
+}
+
+// ListAssets returns a page of the assets of a filesystem in key order,
+// tombstones included.
+func (b *Backend) ListAssets(fsUUID string, pageSize int32, bookmark string) (*RecordPage, error) {
+	return b.queryPage("ListAssets", []string{fsUUID}, pageSize, bookmark)
+}
+
+// ListAssetsByUid returns a page of the live assets of a filesystem owned by
+// uid, leaving out those whose ownership is private.
+func (b *Backend) ListAssetsByUid(fsUUID string, uid uint32, pageSize int32, bookmark string) (*RecordPage, error) {
+	return b.queryPage("ListAssetsByUid", []string{fsUUID, formatUint(uint64(uid))}, pageSize, bookmark)
+}
+
+// ListSetuidAssets returns a page of the live setuid assets of a
+// filesystem, leaving out those whose ownership is private.
+func (b *Backend) ListSetuidAssets(fsUUID string, pageSize int32, bookmark string) (*RecordPage, error) {
+	return b.queryPage("ListSetuidAssets", []string{fsUUID}, pageSize, bookmark)
+}
+
+// ListAssetsModifiedBetween returns a page of the live assets of a
+// filesystem modified at or after from and before to, at second
+// granularity.
+func (b *Backend) ListAssetsModifiedBetween(fsUUID string, from, to time.Time, pageSize int32, bookmark string) (*RecordPage, error) {
+	args := []string{fsUUID, strconv.FormatInt(from.Unix(), 10), strconv.FormatInt(to.Unix(), 10)}
+	return b.queryPage("ListAssetsModifiedBetween", args, pageSize, bookmark)
+}
+
+// ListImmutableAssets returns the live immutable assets of a filesystem.
+func (b *Backend) ListImmutableAssets(fsUUID string) ([]Record, error) {
+	return b.queryRecords("ListImmutableAssets", fsUUID)
+}
+
+// ListAssetGenerations returns every generation recorded for an inode
+// number, tombstones included.
+func (b *Backend) ListAssetGenerations(fsUUID string, ino uint64) ([]Record, error) {
+	return b.queryRecords("ListAssetGenerations", fsUUID, formatUint(ino))
+}
+
+func (b *Backend) queryPage(function string, args []string, pageSize int32, bookmark string) (*RecordPage, error) {
+	args = append(args, strconv.FormatInt(int64(pageSize), 10), bookmark)
+	evaluateResult, err := b.contract.EvaluateTransaction(function, args...)
+	if err != nil {
+		return nil, handleError(err)
+	}
+
+	var page struct {
+		Assets   []json.RawMessage `json:"assets"`
+		Bookmark string            `json:"bookmark"`
+	}
+	err = json.Unmarshal(evaluateResult, &page)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s result: %w", function, err)
+	}
+
+	records, err := parseRecords(page.Assets)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordPage{Records: records, Bookmark: page.Bookmark}, nil
+}
+
+func (b *Backend) queryRecords(function string, args ...string) ([]Record, error) {
+	evaluateResult, err := b.contract.EvaluateTransaction(function, args...)
+	if err != nil {
+		return nil, handleError(err)
+	}
+
+	var assets []json.RawMessage
+	err = json.Unmarshal(evaluateResult, &assets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s result: %w", function, err)
+	}
+	return parseRecords(assets)
+}
+
+func parseRecords(assets []json.RawMessage) ([]Record, error) {
+	records := make([]Record, 0, len(assets))
+	for _, data := range assets {
+		attrs, deleted, err := parseAsset(data)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, Record{Attrs: attrs, Deleted: deleted})
+	}
+	return records, nil
+}
+
+// ChainInfo describes the state of the channel as seen by the gateway peer.
+type ChainInfo struct {
+	Height            uint64 `json:"height"`
+	CurrentBlockHash  []byte `json:"currentBlockHash"`
+	PreviousBlockHash []byte `json:"previousBlockHash"`
+}
+
+// QueryChainInfo asks the query system chaincode of network for the height
+// of the channel.
+func QueryChainInfo(network *client.Network) (*ChainInfo, error) {
+	qscc := network.GetContract("qscc")
+	evaluateResult, err := qscc.EvaluateTransaction("GetChainInfo", network.Name())
+	if err != nil {
+		return nil, handleError(err)
+	}
+
+	var info fabriccommon.BlockchainInfo
+	err = proto.Unmarshal(evaluateResult, &info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse GetChainInfo result: %w", err)
+	}
+
+	return &ChainInfo{
+		Height:            info.GetHeight(),
+		CurrentBlockHash:  info.GetCurrentBlockHash(),
+		PreviousBlockHash: info.GetPreviousBlockHash(),
+	}, nil
+}
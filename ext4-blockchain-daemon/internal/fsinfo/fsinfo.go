@@ -0,0 +1,101 @@
+// Package fsinfo reads what the tools walking a mounted filesystem need to
+// know about it, beyond what stat reports.
+package fsinfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"unsafe"
+
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+	"golang.org/x/sys/unix"
+)
+
+// fsIocGetfsuuid is FS_IOC_GETFSUUID, _IOR(0x15, 0, struct fsuuid2), which
+// x/sys/unix does not define. It is available since Linux 6.5.
+const fsIocGetfsuuid = 2<<30 | fsuuid2Size<<16 | 0x15<<8 | 0
+
+// fsuuid2Size is the size of struct fsuuid2: the length of the UUID
+// followed by up to 16 bytes of it.
+const fsuuid2Size = 17
+
+// fileidIno32Gen is FILEID_INO32_GEN, the file handle type of ext4: the
+// 32-bit inode number followed by the generation.
+const fileidIno32Gen = 1
+
+// CheckUUID returns an error unless root is on the ext4 filesystem with the
+// UUID fsUUID. Tools writing to the ledger call it before walking a mount,
+// as records filed under the wrong filesystem cannot be taken back.
+func CheckUUID(root, fsUUID string) error {
+	want, err := common.ParseUUID(fsUUID)
+	if err != nil {
+		return err
+	}
+
+	var st unix.Statfs_t
+	if err := unix.Statfs(root, &st); err != nil {
+		return &os.PathError{Op: "statfs", Path: root, Err: err}
+	}
+	if st.Type != unix.EXT4_SUPER_MAGIC {
+		return fmt.Errorf("%s is not on an ext4 filesystem", root)
+	}
+
+	got, err := getfsuuid(root)
+	if err == nil {
+		if !bytes.Equal(got, want) {
+			actual, _ := common.FormatUUID(got)
+			return fmt.Errorf("%s is on filesystem %s, not %s", root, actual, fsUUID)
+		}
+		return nil
+	}
+	if !errors.Is(err, unix.ENOTTY) && !errors.Is(err, unix.EINVAL) {
+		return &os.PathError{Op: "FS_IOC_GETFSUUID", Path: root, Err: err}
+	}
+
+	// Older kernels: ext4 derives f_fsid from the UUID, folding its two
+	// little endian halves into one.
+	fsid := binary.LittleEndian.Uint64(want[:8]) ^ binary.LittleEndian.Uint64(want[8:])
+	if uint32(st.Fsid.Val[0]) != uint32(fsid) || uint32(st.Fsid.Val[1]) != uint32(fsid>>32) {
+		return fmt.Errorf("%s is not on filesystem %s", root, fsUUID)
+	}
+	return nil
+}
+
+func getfsuuid(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var buf [fsuuid2Size]byte
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fsIocGetfsuuid, uintptr(unsafe.Pointer(&buf[0])))
+	if errno != 0 {
+		return nil, errno
+	}
+	if buf[0] != 16 {
+		return nil, fmt.Errorf("%s: unexpected UUID length %d", path, buf[0])
+	}
+	return buf[1:], nil
+}
+
+// Generation returns the i_generation of the inode at path from its file
+// handle, which works for every type of inode without opening it.
+func Generation(path string, ino uint64) (uint32, error) {
+	handle, _, err := unix.NameToHandleAt(unix.AT_FDCWD, path, unix.AT_SYMLINK_NOFOLLOW)
+	if err != nil {
+		return 0, &os.PathError{Op: "name_to_handle_at", Path: path, Err: err}
+	}
+
+	b := handle.Bytes()
+	if handle.Type() != fileidIno32Gen || len(b) < 8 {
+		return 0, fmt.Errorf("%s: unexpected file handle type %d, not on ext4?", path, handle.Type())
+	}
+	if uint64(binary.NativeEndian.Uint32(b[0:])) != ino {
+		return 0, fmt.Errorf("%s: file handle of another inode", path)
+	}
+	return binary.NativeEndian.Uint32(b[4:]), nil
+}
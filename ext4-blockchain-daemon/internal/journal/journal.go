@@ -0,0 +1,430 @@
+// Package journal keeps the changes the ledger could not take while it was
+// unreachable, so that they are recorded once it is reachable again.
+package journal
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/backend"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/config"
+)
+
+// Journal is a Backend that appends the changes failing with
+// backend.ErrUnavailable to a file and replays them in order in the
+// background.
+//
+// While the journal holds changes, every new change is appended behind them
+// rather than sent to the ledger, so that the ledger sees the changes of an
+// inode in the order they were made. Reads are not journaled and keep going
+// to the ledger, which does not reflect the journaled changes until they
+// are replayed.
+//
+// The file records how far the replay got, synced after every replayed
+// change, so a daemon stopped while replaying resumes where it stopped;
+// only the change being replayed then is sent again. Changes are removed
+// from the file once the journal is empty.
+type Journal struct {
+	backend.Backend
+
+	fail          bool
+	retryInterval time.Duration
+
+	mu   sync.Mutex
+	file *os.File
+	// head is the offset of the next record to replay and tail the end
+	// of the file; the journal is empty when they are equal.
+	head, tail int64
+	pending    int
+
+	wake   chan struct{}
+	closed chan struct{}
+
+	offline  atomic.Bool
+	queued   atomic.Uint64
+	replayed atomic.Uint64
+	dropped  atomic.Uint64
+}
+
+// Stats is a snapshot of the journal counters.
+type Stats struct {
+	// Pending is the number of changes waiting to be replayed, Bytes
+	// their size in the file.
+	Pending int   `json:"pending"`
+	Bytes   int64 `json:"bytes"`
+	// Offline reports whether the last attempt to reach the ledger
+	// failed.
+	Offline  bool   `json:"offline"`
+	Queued   uint64 `json:"queued"`
+	Replayed uint64 `json:"replayed"`
+	// Dropped counts replayed changes the ledger rejected.
+	Dropped uint64 `json:"dropped"`
+}
+
+// Open opens or creates the journal file. Changes left by a previous run
+// are replayed once Start is called. A record cut short by a crash is
+// discarded together with everything after it.
+func Open(b backend.Backend, cfg *config.JournalConfig) (*Journal, error) {
+	file, err := os.OpenFile(cfg.Path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	j, err := open(b, cfg, file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+func open(b backend.Backend, cfg *config.JournalConfig, file *os.File) (*Journal, error) {
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := readHead(file)
+	valid := err == nil
+	if errors.Is(err, errDamaged) {
+		if fi.Size() > 0 {
+			log.Printf("journal: invalid header in %s, replaying it from the start", cfg.Path)
+		}
+		head = fileHeaderSize
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read journal %s: %w", cfg.Path, err)
+	}
+
+	pending, size, ok, err := scan(file, head)
+	if err == nil && !ok {
+		log.Printf("journal: invalid replay offset %d in %s, replaying it from the start", head, cfg.Path)
+		head, valid = fileHeaderSize, false
+		pending, size, _, err = scan(file, head)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal %s: %w", cfg.Path, err)
+	}
+
+	if fi.Size() > size {
+		log.Printf("journal: discarding %d bytes of damaged records at the end of %s", fi.Size()-size, cfg.Path)
+		if err := truncate(file, size); err != nil {
+			return nil, err
+		}
+	}
+	if !valid {
+		if err := writeHead(file, head); err != nil {
+			return nil, err
+		}
+	}
+	if pending > 0 {
+		log.Printf("journal: %d changes left to replay in %s", pending, cfg.Path)
+	}
+
+	return &Journal{
+		Backend:       b,
+		fail:          cfg.Offline == config.JournalOfflineFail,
+		retryInterval: cfg.RetryInterval,
+		file:          file,
+		head:          head,
+		tail:          size,
+		pending:       pending,
+		wake:          make(chan struct{}, 1),
+		closed:        make(chan struct{}),
+	}, nil
+}
+
+// Start replays the journal in the background until Close is called.
+func (j *Journal) Start() {
+	go j.replay()
+	j.notify()
+}
+
+// Close stops the replay and closes the file. Changes not replayed yet stay
+// in the file.
+func (j *Journal) Close() error {
+	select {
+	case <-j.closed:
+	default:
+		close(j.closed)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+func (j *Journal) Stats() Stats {
+	j.mu.Lock()
+	pending, bytes := j.pending, j.tail-j.head
+	j.mu.Unlock()
+
+	return Stats{
+		Pending:  pending,
+		Bytes:    bytes,
+		Offline:  j.offline.Load(),
+		Queued:   j.queued.Load(),
+		Replayed: j.replayed.Load(),
+		Dropped:  j.dropped.Load(),
+	}
+}
+
+func (j *Journal) CreateInode(attrs *common.Attrs) error {
+	return j.do(&record{Op: opCreate, Attrs: attrs}, func() error {
+		return j.Backend.CreateInode(attrs)
+	})
+}
+
+func (j *Journal) UpdateInode(attrs *common.Attrs) error {
+	return j.do(&record{Op: opUpdate, Attrs: attrs}, func() error {
+		return j.Backend.UpdateInode(attrs)
+	})
+}
+
+// UpdateInodes reports no missing inodes for journaled updates; those found
+// missing when replaying are logged and dropped.
+func (j *Journal) UpdateInodes(updates []*common.Attrs) ([]common.InodeRef, error) {
+	var missing []common.InodeRef
+	err := j.do(&record{Op: opUpdateBatch, Updates: updates}, func() error {
+		var err error
+		missing, err = j.Backend.UpdateInodes(updates)
+		return err
+	})
+	return missing, err
+}
+
+func (j *Journal) DeleteInode(ref common.InodeRef) error {
+	return j.do(&record{Op: opDelete, Ref: &ref}, func() error {
+		return j.Backend.DeleteInode(ref)
+	})
+}
+
+func (j *Journal) SetXattr(x *common.Xattr) error {
+	return j.do(&record{Op: opSetXattr, Xattr: x}, func() error {
+		return j.Backend.SetXattr(x)
+	})
+}
+
+func (j *Journal) RemoveXattr(ref common.InodeRef, name string) error {
+	return j.do(&record{Op: opRemoveXattr, Ref: &ref, Name: name}, func() error {
+		return j.Backend.RemoveXattr(ref, name)
+	})
+}
+
+func (j *Journal) ChangeDentry(change *common.DentryChange) error {
+	return j.do(&record{Op: opChangeDentry, Dentry: change}, func() error {
+		return j.Backend.ChangeDentry(change)
+	})
+}
+
+func (j *Journal) RecordDigest(ref common.InodeRef, digest *common.ContentDigest) error {
+	return j.do(&record{Op: opRecordDigest, Ref: &ref, Digest: digest}, func() error {
+		return j.Backend.RecordDigest(ref, digest)
+	})
+}
+
+// do applies a change directly while the journal is empty, and journals it
+// if it is not or if the ledger turns out to be unreachable.
+func (j *Journal) do(r *record, apply func() error) error {
+	j.mu.Lock()
+	if j.head != j.tail {
+		err := j.append(r)
+		j.mu.Unlock()
+		return j.queuedResult(err, backend.ErrUnavailable)
+	}
+	j.mu.Unlock()
+
+	err := apply()
+	if !errors.Is(err, backend.ErrUnavailable) {
+		return err
+	}
+	if !j.offline.Swap(true) {
+		log.Printf("journal: ledger unavailable, journaling changes")
+	}
+
+	j.mu.Lock()
+	appendErr := j.append(r)
+	j.mu.Unlock()
+	if appendErr == nil {
+		j.notify()
+	}
+	return j.queuedResult(appendErr, err)
+}
+
+// queuedResult is what the caller is told about a change that was to be
+// journaled because of cause.
+func (j *Journal) queuedResult(appendErr, cause error) error {
+	if appendErr != nil {
+		log.Printf("journal: change lost: %v", appendErr)
+		return errors.Join(cause, appendErr)
+	}
+	j.queued.Add(1)
+	if j.fail {
+		return fmt.Errorf("%w: change journaled for replay", cause)
+	}
+	return nil
+}
+
+// append writes a record to the end of the file and syncs it. j.mu must be
+// held.
+func (j *Journal) append(r *record) error {
+	r.Time = time.Now()
+	data, err := encode(r)
+	if err != nil {
+		return err
+	}
+
+	if _, err := j.file.WriteAt(data, j.tail); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	j.tail += int64(len(data))
+	j.pending++
+	return nil
+}
+
+func (j *Journal) notify() {
+	select {
+	case j.wake <- struct{}{}:
+	default:
+	}
+}
+
+// replay applies the journaled records in order, waiting retryInterval
+// whenever the ledger is unreachable.
+func (j *Journal) replay() {
+	for {
+		r, next, err := j.next()
+		if err != nil {
+			// The file was validated when opened and is only appended to
+			// since, so this is an I/O error; try again later.
+			log.Printf("journal: %v", err)
+		}
+		if r == nil {
+			var retry <-chan time.Time
+			if err != nil {
+				retry = time.After(j.retryInterval)
+			}
+			select {
+			case <-j.wake:
+			case <-retry:
+			case <-j.closed:
+				return
+			}
+			continue
+		}
+
+		err = j.apply(r)
+		if errors.Is(err, backend.ErrUnavailable) {
+			if !j.offline.Swap(true) {
+				log.Printf("journal: ledger unavailable, retrying every %v", j.retryInterval)
+			}
+			select {
+			case <-time.After(j.retryInterval):
+			case <-j.closed:
+				return
+			}
+			continue
+		}
+		if j.offline.Swap(false) {
+			log.Printf("journal: ledger available, replaying %d changes", j.Stats().Pending)
+		}
+		if err != nil {
+			log.Printf("journal: dropping %s journaled at %v: %v", r.Op, r.Time.Format(time.RFC3339), err)
+			j.dropped.Add(1)
+		} else {
+			j.replayed.Add(1)
+		}
+
+		j.mu.Lock()
+		j.head = next
+		j.pending--
+		err = writeHead(j.file, j.head)
+		j.mu.Unlock()
+		if err != nil {
+			// The change is sent again after a restart, like the one being
+			// replayed when the daemon stops.
+			log.Printf("journal: %v", err)
+		}
+	}
+}
+
+// next returns the record to replay and the offset following it, or nil
+// once the journal is empty. Emptying the journal truncates the file.
+func (j *Journal) next() (*record, int64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.head == j.tail {
+		if j.tail > fileHeaderSize {
+			// Truncating first: should the header not be written, the
+			// offset past the end is found invalid on the next Open,
+			// which then replays the empty journal from the start.
+			if err := truncate(j.file, fileHeaderSize); err != nil {
+				return nil, 0, err
+			}
+			if err := writeHead(j.file, fileHeaderSize); err != nil {
+				return nil, 0, err
+			}
+			log.Printf("journal: replay complete")
+			j.head, j.tail, j.pending = fileHeaderSize, fileHeaderSize, 0
+		}
+		return nil, 0, nil
+	}
+
+	r, size, err := read(j.file, j.head)
+	if err != nil {
+		return nil, 0, err
+	}
+	return r, j.head + size, nil
+}
+
+// apply sends a journaled change to the ledger. Changes the ledger already
+// has, from a replay interrupted by a crash, count as applied.
+func (j *Journal) apply(r *record) error {
+	switch r.Op {
+	case opCreate:
+		err := j.Backend.CreateInode(r.Attrs)
+		if errors.Is(err, backend.ErrExists) {
+			return nil
+		}
+		return err
+	case opUpdate:
+		return j.Backend.UpdateInode(r.Attrs)
+	case opUpdateBatch:
+		missing, err := j.Backend.UpdateInodes(r.Updates)
+		for _, ref := range missing {
+			log.Printf("journal: dropping update of missing inode %v", ref)
+		}
+		return err
+	case opDelete:
+		return j.Backend.DeleteInode(*r.Ref)
+	case opSetXattr:
+		return j.Backend.SetXattr(r.Xattr)
+	case opRemoveXattr:
+		return j.Backend.RemoveXattr(*r.Ref, r.Name)
+	case opChangeDentry:
+		return j.Backend.ChangeDentry(r.Dentry)
+	case opRecordDigest:
+		return j.Backend.RecordDigest(*r.Ref, r.Digest)
+	default:
+		return fmt.Errorf("unknown operation %q", r.Op)
+	}
+}
+
+func truncate(file *os.File, size int64) error {
+	if err := file.Truncate(size); err != nil {
+		return fmt.Errorf("failed to truncate journal: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+package journal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/backend"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/config"
+)
+
+const testUUID = "0f0e0d0c-0b0a-0908-0706-050403020100"
+
+// ledger is a Backend recording the changes it takes. It becomes
+// unavailable once it took budget changes, unless budget is negative.
+type ledger struct {
+	mu      sync.Mutex
+	budget  int
+	applied []string
+}
+
+func (l *ledger) setBudget(budget int) {
+	l.mu.Lock()
+	l.budget = budget
+	l.mu.Unlock()
+}
+
+func (l *ledger) changes() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.applied)
+}
+
+func (l *ledger) take(op string, ino uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.budget == 0 {
+		return fmt.Errorf("%w: connection refused", backend.ErrUnavailable)
+	}
+	if l.budget > 0 {
+		l.budget--
+	}
+	l.applied = append(l.applied, fmt.Sprintf("%s %d", op, ino))
+	return nil
+}
+
+func (l *ledger) CreateInode(attrs *common.Attrs) error { return l.take("create", attrs.Ino) }
+func (l *ledger) UpdateInode(attrs *common.Attrs) error { return l.take("update", attrs.Ino) }
+
+func (l *ledger) UpdateInodes(updates []*common.Attrs) ([]common.InodeRef, error) {
+	return nil, l.take("updateBatch", updates[0].Ino)
+}
+
+func (l *ledger) ReadInode(ref common.InodeRef) (*common.Attrs, error) {
+	return nil, backend.ErrNotFound
+}
+
+func (l *ledger) DeleteInode(ref common.InodeRef) error { return l.take("delete", ref.Ino) }
+func (l *ledger) SetXattr(x *common.Xattr) error        { return l.take("setXattr", x.Ref.Ino) }
+
+func (l *ledger) RemoveXattr(ref common.InodeRef, name string) error {
+	return l.take("removeXattr", ref.Ino)
+}
+
+func (l *ledger) ChangeDentry(change *common.DentryChange) error {
+	return l.take("changeDentry", change.Ref.Ino)
+}
+
+func (l *ledger) RecordDigest(ref common.InodeRef, digest *common.ContentDigest) error {
+	return l.take("recordDigest", ref.Ino)
+}
+
+func testConfig(t *testing.T, offline string) *config.JournalConfig {
+	return &config.JournalConfig{
+		Path:          filepath.Join(t.TempDir(), "journal"),
+		Offline:       offline,
+		RetryInterval: 5 * time.Millisecond,
+	}
+}
+
+func openJournal(t *testing.T, l *ledger, cfg *config.JournalConfig) *Journal {
+	t.Helper()
+	j, err := Open(l, cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { j.Close() })
+	return j
+}
+
+func attrs(ino uint64) *common.Attrs {
+	return &common.Attrs{FsUUID: testUUID, Ino: ino, Generation: 1, Valid: common.EXT4B_VALID_ALL}
+}
+
+func ref(ino uint64) common.InodeRef {
+	return common.InodeRef{FsUUID: testUUID, Ino: ino, Generation: 1}
+}
+
+// change makes the i-th of a sequence of changes of different operations.
+func change(t *testing.T, j *Journal, i int) {
+	t.Helper()
+	var err error
+	switch ino := uint64(i); i % 3 {
+	case 0:
+		err = j.CreateInode(attrs(ino))
+	case 1:
+		err = j.UpdateInode(attrs(ino))
+	case 2:
+		err = j.DeleteInode(ref(ino))
+	}
+	if err != nil {
+		t.Fatalf("change %d: %v", i, err)
+	}
+}
+
+func expected(from, to int) []string {
+	var changes []string
+	for i := from; i < to; i++ {
+		changes = append(changes, fmt.Sprintf("%s %d", []string{"create", "update", "delete"}[i%3], i))
+	}
+	return changes
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReplayKeepsOrder(t *testing.T) {
+	l := &ledger{budget: 0}
+	j := openJournal(t, l, testConfig(t, config.JournalOfflineQueue))
+
+	change(t, j, 0)
+	// Once the journal holds changes, later ones queue behind them even
+	// though the ledger is back.
+	l.setBudget(-1)
+	for i := 1; i < 6; i++ {
+		change(t, j, i)
+	}
+	if got := l.changes(); len(got) != 0 {
+		t.Fatalf("changes applied before the replay: %v", got)
+	}
+	if stats := j.Stats(); stats.Pending != 6 || stats.Queued != 6 {
+		t.Fatalf("stats = %+v, want 6 pending and queued", stats)
+	}
+
+	j.Start()
+	waitFor(t, "the replay", func() bool { return j.Stats().Pending == 0 })
+
+	if got, want := l.changes(), expected(0, 6); !slices.Equal(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+	if stats := j.Stats(); stats.Replayed != 6 || stats.Dropped != 0 || stats.Offline {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	// The emptied journal is applied directly again.
+	change(t, j, 6)
+	if got := l.changes(); len(got) != 7 {
+		t.Errorf("change after the replay not applied directly: %v", got)
+	}
+}
+
+func TestReplayRetriesUnavailableLedger(t *testing.T) {
+	l := &ledger{budget: 0}
+	j := openJournal(t, l, testConfig(t, config.JournalOfflineQueue))
+	for i := 0; i < 4; i++ {
+		change(t, j, i)
+	}
+
+	l.setBudget(2)
+	j.Start()
+	waitFor(t, "two changes", func() bool { return j.Stats().Replayed == 2 })
+	waitFor(t, "the ledger to go offline", func() bool { return j.Stats().Offline })
+
+	l.setBudget(-1)
+	waitFor(t, "the replay", func() bool { return j.Stats().Pending == 0 })
+	if got, want := l.changes(), expected(0, 4); !slices.Equal(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+}
+
+func TestReplayResumesAfterRestart(t *testing.T) {
+	cfg := testConfig(t, config.JournalOfflineQueue)
+	l := &ledger{budget: 0}
+	j, err := Open(l, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		change(t, j, i)
+	}
+
+	l.setBudget(3)
+	j.Start()
+	// The replay goes offline after recording how far it got.
+	waitFor(t, "three changes", func() bool {
+		stats := j.Stats()
+		return stats.Replayed == 3 && stats.Offline
+	})
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	l.setBudget(-1)
+	j = openJournal(t, l, cfg)
+	if stats := j.Stats(); stats.Pending != 2 {
+		t.Fatalf("%d changes pending after the restart, want 2", stats.Pending)
+	}
+	j.Start()
+	waitFor(t, "the replay", func() bool { return j.Stats().Pending == 0 })
+
+	if got, want := l.changes(), expected(0, 5); !slices.Equal(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+	waitFor(t, "the truncation", func() bool {
+		fi, err := os.Stat(cfg.Path)
+		return err == nil && fi.Size() == fileHeaderSize
+	})
+}
+
+func TestOpenDiscardsTornRecord(t *testing.T) {
+	cfg := testConfig(t, config.JournalOfflineQueue)
+	l := &ledger{budget: 0}
+	j, err := Open(l, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		change(t, j, i)
+	}
+	size := j.Stats().Bytes + fileHeaderSize
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A record whose payload was cut short by a crash.
+	data, err := encode(&record{Op: opCreate, Attrs: attrs(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(cfg.Path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(data[:len(data)-4]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	j = openJournal(t, l, cfg)
+	if stats := j.Stats(); stats.Pending != 3 {
+		t.Errorf("%d changes pending, want 3", stats.Pending)
+	}
+	if fi, err := os.Stat(cfg.Path); err != nil || fi.Size() != size {
+		t.Errorf("journal size = %v, %v; want %d", fi.Size(), err, size)
+	}
+
+	l.setBudget(-1)
+	j.Start()
+	waitFor(t, "the replay", func() bool { return j.Stats().Pending == 0 })
+	if got, want := l.changes(), expected(0, 3); !slices.Equal(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+}
+
+func TestOpenReplaysFromStartOnInvalidHeader(t *testing.T) {
+	cfg := testConfig(t, config.JournalOfflineQueue)
+	l := &ledger{budget: 0}
+	j, err := Open(l, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		change(t, j, i)
+	}
+	if err := writeHead(j.file, fileHeaderSize+1); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	j = openJournal(t, l, cfg)
+	if stats := j.Stats(); stats.Pending != 2 {
+		t.Errorf("%d changes pending, want 2", stats.Pending)
+	}
+}
+
+func TestOfflineFailReportsJournaledChanges(t *testing.T) {
+	l := &ledger{budget: 0}
+	j := openJournal(t, l, testConfig(t, config.JournalOfflineFail))
+
+	err := j.CreateInode(attrs(1))
+	if !errors.Is(err, backend.ErrUnavailable) {
+		t.Fatalf("CreateInode = %v, want ErrUnavailable", err)
+	}
+	if status := backend.Status(err); status != common.EXT4BD_STATUS_FAIL {
+		t.Errorf("status = %d, want EXT4BD_STATUS_FAIL", status)
+	}
+	if stats := j.Stats(); stats.Pending != 1 {
+		t.Errorf("%d changes pending, want 1", stats.Pending)
+	}
+}
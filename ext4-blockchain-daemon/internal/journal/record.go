@@ -0,0 +1,171 @@
+package journal
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+)
+
+// Operations of the journaled changes, one per mutating Backend method.
+const (
+	opCreate       = "create"
+	opUpdate       = "update"
+	opUpdateBatch  = "updateBatch"
+	opDelete       = "delete"
+	opSetXattr     = "setXattr"
+	opRemoveXattr  = "removeXattr"
+	opChangeDentry = "changeDentry"
+	opRecordDigest = "recordDigest"
+)
+
+// The journal file starts with a header of fileHeaderSize bytes: a magic
+// number, the CRC-32C of the rest of the header, and the offset of the next
+// record to replay, all little endian. The records follow.
+const (
+	fileHeaderSize = 16
+	fileMagic      = 0x6a623465 // "e4bj"
+)
+
+// headerSize is the size of the header preceding every record: the length
+// of the payload and its CRC-32C, both little endian. The payload is the
+// record encoded as JSON.
+const headerSize = 8
+
+// maxRecordSize bounds the payload length read from a header, so that a
+// damaged header is not taken for a huge record.
+const maxRecordSize = 64 << 20
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errDamaged = errors.New("damaged record")
+
+// record is a journaled change. Which fields are set depends on Op.
+type record struct {
+	Op      string                `json:"op"`
+	Time    time.Time             `json:"time"`
+	Attrs   *common.Attrs         `json:"attrs,omitempty"`
+	Updates []*common.Attrs       `json:"updates,omitempty"`
+	Ref     *common.InodeRef      `json:"ref,omitempty"`
+	Name    string                `json:"name,omitempty"`
+	Xattr   *common.Xattr         `json:"xattr,omitempty"`
+	Dentry  *common.DentryChange  `json:"dentry,omitempty"`
+	Digest  *common.ContentDigest `json:"digest,omitempty"`
+}
+
+func encode(r *record) ([]byte, error) {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > maxRecordSize {
+		return nil, fmt.Errorf("%s record of %d bytes exceeds the journal limit", r.Op, len(payload))
+	}
+
+	data := make([]byte, headerSize, headerSize+len(payload))
+	binary.LittleEndian.PutUint32(data[0:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(data[4:], crc32.Checksum(payload, crcTable))
+	return append(data, payload...), nil
+}
+
+// read decodes the record at offset and returns it with its size in the
+// file. Records cut short or failing their checksum are errDamaged.
+func read(file *os.File, offset int64) (*record, int64, error) {
+	var header [headerSize]byte
+	if _, err := file.ReadAt(header[:], offset); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, 0, errDamaged
+		}
+		return nil, 0, err
+	}
+
+	length := binary.LittleEndian.Uint32(header[0:])
+	if length > maxRecordSize {
+		return nil, 0, errDamaged
+	}
+
+	payload := make([]byte, length)
+	if _, err := file.ReadAt(payload, offset+headerSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, 0, errDamaged
+		}
+		return nil, 0, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:]) {
+		return nil, 0, errDamaged
+	}
+
+	var r record
+	if err := json.Unmarshal(payload, &r); err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", errDamaged, err)
+	}
+	return &r, headerSize + int64(length), nil
+}
+
+// readHead returns the replay offset stored in the file header, or
+// errDamaged if the header is missing or invalid.
+func readHead(file *os.File) (int64, error) {
+	var header [fileHeaderSize]byte
+	if _, err := file.ReadAt(header[:], 0); err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, errDamaged
+		}
+		return 0, err
+	}
+	if binary.LittleEndian.Uint32(header[0:]) != fileMagic ||
+		binary.LittleEndian.Uint32(header[4:]) != crc32.Checksum(header[8:], crcTable) {
+		return 0, errDamaged
+	}
+	return int64(binary.LittleEndian.Uint64(header[8:])), nil
+}
+
+// writeHead stores the replay offset in the file header and syncs it.
+func writeHead(file *os.File, head int64) error {
+	var header [fileHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[0:], fileMagic)
+	binary.LittleEndian.PutUint64(header[8:], uint64(head))
+	binary.LittleEndian.PutUint32(header[4:], crc32.Checksum(header[8:], crcTable))
+
+	if _, err := file.WriteAt(header[:], 0); err != nil {
+		return fmt.Errorf("failed to write journal header: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	return nil
+}
+
+// scan walks the intact records following the file header and returns the
+// offset where they end and how many of them start at or after head. ok is
+// false if head is not the start of a record or the end of the last one.
+func scan(file *os.File, head int64) (pending int, end int64, ok bool, err error) {
+	fi, err := file.Stat()
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	end = fileHeaderSize
+	for end < fi.Size() {
+		_, size, err := read(file, end)
+		if errors.Is(err, errDamaged) {
+			break
+		}
+		if err != nil {
+			return 0, 0, false, err
+		}
+		if end == head {
+			ok = true
+		}
+		if end >= head {
+			pending++
+		}
+		end += size
+	}
+	return pending, end, ok || end == head, nil
+}
@@ -0,0 +1,287 @@
+// Package scrub compares a mounted ext4 filesystem with its records on the
+// ledger.
+package scrub
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/common"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/fabric"
+	"github.com/przemyslawS99/ext4-blockchain-integration/ext4-blockchain-daemon/internal/fsinfo"
+	"golang.org/x/sys/unix"
+)
+
+// Kinds of findings.
+const (
+	// KindMismatch is a field whose recorded value differs from the one
+	// on disk.
+	KindMismatch = "mismatch"
+	// KindMissing is an inode on disk without a live ledger record.
+	KindMissing = "missing"
+	// KindOrphan is a live ledger record without an inode on disk.
+	KindOrphan = "orphan"
+	// KindError is an entry that could not be examined.
+	KindError = "error"
+)
+
+// ext4RootIno is the inode number of the root directory of ext4.
+const ext4RootIno = 2
+
+// Finding is one difference between the disk and the ledger.
+type Finding struct {
+	Kind  string          `json:"kind"`
+	Ref   common.InodeRef `json:"ref"`
+	Path  string          `json:"path,omitempty"`
+	Field string          `json:"field,omitempty"`
+	// Ledger and Disk are the differing values of Field.
+	Ledger any    `json:"ledger,omitempty"`
+	Disk   any    `json:"disk,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Summary counts what a scrub examined and found.
+type Summary struct {
+	Inodes     int `json:"inodes"`
+	Records    int `json:"records"`
+	Mismatches int `json:"mismatches"`
+	Missing    int `json:"missing"`
+	Orphans    int `json:"orphans"`
+	Errors     int `json:"errors"`
+	// Complete is false when the walk did not start at the root of the
+	// filesystem or could not read some entries, in which case orphans
+	// are not looked for.
+	Complete bool `json:"complete"`
+}
+
+// Drift reports whether the scrub found any difference.
+func (s *Summary) Drift() bool {
+	return s.Mismatches+s.Missing+s.Orphans > 0
+}
+
+// Ledger is the part of fabric.Backend a scrub reads.
+type Ledger interface {
+	ListAssets(fsUUID string, pageSize int32, bookmark string) (*fabric.RecordPage, error)
+	// ReadInode returns the uid, gid and mode of records whose ownership
+	// is private, which ListAssets leaves zero.
+	ReadInode(ref common.InodeRef) (*common.Attrs, error)
+}
+
+// Options tune a scrub.
+type Options struct {
+	// PageSize is the number of records read per ListAssets call.
+	PageSize int32
+	// Atime includes access times in the comparison. They change on
+	// every read, so they are left out by default.
+	Atime bool
+}
+
+// Scrub walks the filesystem fsUUID mounted at root and calls report for
+// every difference with the ledger. It does not cross into other mounts,
+// and refuses to start if root is on another filesystem.
+func Scrub(ledger Ledger, fsUUID, root string, opts Options, report func(*Finding) error) (*Summary, error) {
+	if err := fsinfo.CheckUUID(root, fsUUID); err != nil {
+		return nil, err
+	}
+
+	records, err := readRecords(ledger, fsUUID, opts.PageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var rootStat unix.Statx_t
+	if err := statx(root, &rootStat); err != nil {
+		return nil, err
+	}
+
+	summary := &Summary{Records: len(records), Complete: rootStat.Ino == ext4RootIno}
+	emit := func(f *Finding) error {
+		switch f.Kind {
+		case KindMismatch:
+			summary.Mismatches++
+		case KindMissing:
+			summary.Missing++
+		case KindOrphan:
+			summary.Orphans++
+		case KindError:
+			summary.Errors++
+		}
+		return report(f)
+	}
+
+	// seen holds the inodes found on disk, visited their inode numbers,
+	// and unknown the inode numbers whose generation could not be read.
+	seen := make(map[common.InodeRef]bool)
+	visited := make(map[uint64]bool)
+	unknown := make(map[uint64]bool)
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		// The inodes below an entry that cannot be read are not seen, so
+		// their records would be taken for orphans.
+		if err != nil {
+			summary.Complete = false
+			return emit(&Finding{Kind: KindError, Ref: common.InodeRef{FsUUID: fsUUID}, Path: path, Error: err.Error()})
+		}
+
+		var stx unix.Statx_t
+		if err := statx(path, &stx); err != nil {
+			summary.Complete = false
+			return emit(&Finding{Kind: KindError, Ref: common.InodeRef{FsUUID: fsUUID}, Path: path, Error: err.Error()})
+		}
+
+		if stx.Dev_major != rootStat.Dev_major || stx.Dev_minor != rootStat.Dev_minor {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Hard links are compared once.
+		if visited[stx.Ino] {
+			return nil
+		}
+		visited[stx.Ino] = true
+		summary.Inodes++
+
+		ref := common.InodeRef{FsUUID: fsUUID, Ino: stx.Ino}
+		ref.Generation, err = fsinfo.Generation(path, stx.Ino)
+		if err != nil {
+			unknown[stx.Ino] = true
+			return emit(&Finding{Kind: KindError, Ref: ref, Path: path, Error: err.Error()})
+		}
+		seen[ref] = true
+
+		// A record of an earlier inode with the same number does not
+		// describe this one; it is an orphan, and this inode is missing.
+		record, ok := records[ref]
+		if !ok {
+			return emit(&Finding{Kind: KindMissing, Ref: ref, Path: path})
+		}
+
+		findings, err := compare(ledger, record, &stx, opts)
+		if err != nil {
+			return emit(&Finding{Kind: KindError, Ref: ref, Path: path, Error: err.Error()})
+		}
+		for _, f := range findings {
+			f.Path = path
+			if err := emit(f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return summary, err
+	}
+
+	if !summary.Complete {
+		return summary, nil
+	}
+
+	orphans := make([]common.InodeRef, 0)
+	for ref := range records {
+		if !seen[ref] && !unknown[ref.Ino] {
+			orphans = append(orphans, ref)
+		}
+	}
+	sort.Slice(orphans, func(i, j int) bool {
+		if orphans[i].Ino != orphans[j].Ino {
+			return orphans[i].Ino < orphans[j].Ino
+		}
+		return orphans[i].Generation < orphans[j].Generation
+	})
+	for _, ref := range orphans {
+		if err := emit(&Finding{Kind: KindOrphan, Ref: ref}); err != nil {
+			return summary, err
+		}
+	}
+
+	return summary, nil
+}
+
+// readRecords returns the live records of a filesystem.
+func readRecords(ledger Ledger, fsUUID string, pageSize int32) (map[common.InodeRef]*common.Attrs, error) {
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("invalid page size %d", pageSize)
+	}
+
+	records := make(map[common.InodeRef]*common.Attrs)
+	bookmark := ""
+	for {
+		page, err := ledger.ListAssets(fsUUID, pageSize, bookmark)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range page.Records {
+			if r.Deleted != nil {
+				continue
+			}
+			records[r.Attrs.Ref()] = r.Attrs
+		}
+
+		if len(page.Records) < int(pageSize) || page.Bookmark == "" {
+			return records, nil
+		}
+		bookmark = page.Bookmark
+	}
+}
+
+func statx(path string, stx *unix.Statx_t) error {
+	mask := unix.STATX_BASIC_STATS | unix.STATX_BTIME
+	err := unix.Statx(unix.AT_FDCWD, path, unix.AT_SYMLINK_NOFOLLOW|unix.AT_STATX_DONT_SYNC, mask, stx)
+	if err != nil {
+		return &fs.PathError{Op: "statx", Path: path, Err: err}
+	}
+	return nil
+}
+
+// compare returns the fields of record that differ from stx. Ownership
+// found to differ is read again through ReadInode, which resolves private
+// ownership and sees updates committed since the listing.
+func compare(ledger Ledger, record *common.Attrs, stx *unix.Statx_t, opts Options) ([]*Finding, error) {
+	findings := ownershipFindings(record, stx)
+	if len(findings) > 0 {
+		current, err := ledger.ReadInode(record.Ref())
+		if err != nil {
+			return nil, err
+		}
+		record = current
+		findings = ownershipFindings(record, stx)
+	}
+
+	mismatch := func(field string, ledger, disk any) {
+		findings = append(findings, &Finding{Kind: KindMismatch, Ref: record.Ref(), Field: field, Ledger: ledger, Disk: disk})
+	}
+	compareTime := func(field string, ledger common.Time, disk unix.StatxTimestamp) {
+		if ledger.Sec != disk.Sec || ledger.Nsec != disk.Nsec {
+			mismatch(field, ledger, common.Time{Sec: disk.Sec, Nsec: disk.Nsec})
+		}
+	}
+
+	compareTime("mtime", record.Mtime, stx.Mtime)
+	compareTime("ctime", record.Ctime, stx.Ctime)
+	if stx.Mask&unix.STATX_BTIME != 0 {
+		compareTime("crtime", record.Crtime, stx.Btime)
+	}
+	if opts.Atime {
+		compareTime("atime", record.Atime, stx.Atime)
+	}
+
+	return findings, nil
+}
+
+func ownershipFindings(record *common.Attrs, stx *unix.Statx_t) []*Finding {
+	var findings []*Finding
+	mismatch := func(field string, ledger, disk uint32) {
+		if ledger != disk {
+			findings = append(findings, &Finding{Kind: KindMismatch, Ref: record.Ref(), Field: field, Ledger: ledger, Disk: disk})
+		}
+	}
+
+	mismatch("uid", record.Uid, stx.Uid)
+	mismatch("gid", record.Gid, stx.Gid)
+	mismatch("mode", record.Mode, uint32(stx.Mode))
+	return findings
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Every asset records the identity that created it as its owner, and only
+// the owner may change it afterwards. Identities whose certificate carries
+// the admin attribute with the value "true" may change any asset; assets
+// recorded before owners were tracked have none and can only be changed by
+// admins.
+//
+// Violations fail with an error starting with accessDenied, which clients
+// can tell apart from every other failure.
+const accessDenied = "access denied"
+
+// adminAttribute is the name of the admin attribute. It is read from the
+// EXT4_ADMIN_ATTRIBUTE environment variable of the chaincode process.
+var adminAttribute = adminAttributeName()
+
+func adminAttributeName() string {
+    if name := os.Getenv("EXT4_ADMIN_ATTRIBUTE"); name != "" {
+        return name
+    }
+
+    return "ext4.admin"
+}
+
+func isAdmin(ctx contractapi.TransactionContextInterface) (bool, error) {
+    value, found, err := ctx.GetClientIdentity().GetAttributeValue(adminAttribute)
+    if err != nil {
+        return false, fmt.Errorf("failed to read submitter attributes: %v", err)
+    }
+
+    return found && value == "true", nil
+}
+
+// requireAdmin fails unless the submitter is an admin.
+func requireAdmin(ctx contractapi.TransactionContextInterface, action string) error {
+    admin, err := isAdmin(ctx)
+    if err != nil {
+        return err
+    }
+
+    if !admin {
+        return fmt.Errorf("%s: %s requires the %s attribute", accessDenied, action, adminAttribute)
+    }
+
+    return nil
+}
+
+// authorize fails unless the submitter may change asset.
+func authorize(ctx contractapi.TransactionContextInterface, asset *Asset) error {
+    submitter, err := getSubmitter(ctx)
+    if err != nil {
+        return err
+    }
+
+    if asset.Owner != nil && *asset.Owner == submitter {
+        return nil
+    }
+
+    admin, err := isAdmin(ctx)
+    if err != nil {
+        return err
+    }
+
+    if !admin {
+        return fmt.Errorf("%s: %s of %s may not change asset %s/%d@%d", accessDenied, submitter.ID, submitter.MSPID, asset.FsUUID, asset.Ino, asset.Generation)
+    }
+
+    return nil
+}
+
+// getMutableAsset returns a live asset the submitter may change.
+func getMutableAsset(ctx contractapi.TransactionContextInterface, fsUUID string, ino uint64, generation uint32) (*Asset, error) {
+    asset, err := getAsset(ctx, fsUUID, ino, generation)
+    if err != nil {
+        return nil, err
+    }
+
+    if asset == nil || asset.Deleted != nil {
+        return nil, fmt.Errorf("the asset %s/%d@%d does not exist", fsUUID, ino, generation)
+    }
+
+    err = authorize(ctx, asset)
+    if err != nil {
+        return nil, err
+    }
+
+    return asset, nil
+}
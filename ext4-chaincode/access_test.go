@@ -0,0 +1,137 @@
+package main
+
+import (
+    "encoding/json"
+    "strings"
+    "testing"
+)
+
+func uidUpdate(ino uint64, uid uint32) []AssetUpdate {
+    return []AssetUpdate{{Asset: Asset{FsUUID: testUUID, Ino: ino, Generation: 1, Uid: uid}, Valid: validUid}}
+}
+
+func requireDenied(t *testing.T, action string, err error) {
+    t.Helper()
+    if err == nil || !strings.HasPrefix(err.Error(), accessDenied) {
+        t.Errorf("%s: %v, want %q", action, err, accessDenied)
+    }
+}
+
+func TestOnlyOwnerOrAdminMayUpdate(t *testing.T) {
+    stub := newMockStub()
+    createAssets(t, stub, alice, testAsset(1))
+    s := &SmartContract{}
+
+    _, err := s.BatchUpdateAssets(newContext(stub, bob), uidUpdate(1, 1))
+    requireDenied(t, "update by another identity of the organization", err)
+
+    for i, identity := range []*mockIdentity{alice, admin} {
+        _, err := s.BatchUpdateAssets(newContext(stub, identity), uidUpdate(1, uint32(i+2)))
+        if err != nil {
+            t.Errorf("update by %s: %v", identity.id, err)
+        }
+        stub.commit()
+    }
+
+    asset := readAsset(t, stub, 1)
+    if asset.Uid != 3 {
+        t.Errorf("uid = %d, want the admin's 3", asset.Uid)
+    }
+
+    if asset.Owner == nil || asset.Owner.ID != alice.id {
+        t.Errorf("owner = %+v, want %s: updates do not change it", asset.Owner, alice.id)
+    }
+}
+
+func TestOnlyAdminMayUpdateAssetWithoutOwner(t *testing.T) {
+    stub := newMockStub()
+    asset := testAsset(1)
+    asset.SchemaVersion = schemaVersion
+    assetJSON, err := json.Marshal(asset)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    key, err := assetKey(newContext(stub, alice), testUUID, 1, 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stub.state[key] = assetJSON
+
+    s := &SmartContract{}
+    _, err = s.BatchUpdateAssets(newContext(stub, alice), uidUpdate(1, 1))
+    requireDenied(t, "update of an asset without owner", err)
+
+    _, err = s.BatchUpdateAssets(newContext(stub, admin), uidUpdate(1, 1))
+    if err != nil {
+        t.Errorf("update by an admin: %v", err)
+    }
+}
+
+func TestDeleteAndRecreate(t *testing.T) {
+    stub := newMockStub()
+    createAssets(t, stub, alice, testAsset(1))
+    s := &SmartContract{}
+
+    err := s.DeleteAsset(newContext(stub, bob), testUUID, 1, 1)
+    requireDenied(t, "delete by another identity", err)
+
+    err = s.DeleteAsset(newContext(stub, alice), testUUID, 1, 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stub.commit()
+
+    asset := readAsset(t, stub, 1)
+    if asset.Deleted == nil || asset.Deleted.Submitter.ID != alice.id {
+        t.Fatalf("tombstone = %+v", asset.Deleted)
+    }
+
+    // Only whoever could change the deleted asset may replace its
+    // tombstone.
+    _, err = s.BatchCreateAssets(newContext(stub, bob), []Asset{testAsset(1)})
+    requireDenied(t, "replacing the tombstone of another identity", err)
+
+    createAssets(t, stub, alice, testAsset(1))
+    if asset := readAsset(t, stub, 1); asset.Deleted != nil {
+        t.Errorf("asset is still deleted: %+v", asset.Deleted)
+    }
+}
+
+func TestFilesystemBelongsToFirstOrganization(t *testing.T) {
+    stub := newMockStub()
+    createAssets(t, stub, alice, testAsset(1))
+    s := &SmartContract{}
+
+    other := &mockIdentity{id: "carol", mspID: "Org3MSP"}
+    _, err := s.BatchCreateAssets(newContext(stub, other), []Asset{testAsset(2)})
+    requireDenied(t, "create by another organization", err)
+
+    // bob is of the owning organization, and admins may create anywhere.
+    createAssets(t, stub, bob, testAsset(2))
+    createAssets(t, stub, admin, testAsset(3))
+
+    fs, err := s.GetFilesystem(newContext(stub, other), testUUID)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if fs.MSPID != alice.mspID {
+        t.Errorf("filesystem belongs to %s, want %s", fs.MSPID, alice.mspID)
+    }
+}
+
+func TestMigrationRequiresAdmin(t *testing.T) {
+    stub := newMockStub()
+    s := &SmartContract{}
+
+    _, err := s.ListMigrations(newContext(stub, alice), 10, "")
+    requireDenied(t, "ListMigrations", err)
+
+    _, err = s.MigrateAssets(newContext(stub, alice), testUUID, nil)
+    requireDenied(t, "MigrateAssets", err)
+
+    notAdmin := &mockIdentity{id: "dave", mspID: "Org1MSP", attributes: map[string]string{adminAttribute: "false"}}
+    _, err = s.ListMigrations(newContext(stub, notAdmin), 10, "")
+    requireDenied(t, "ListMigrations with the admin attribute false", err)
+}
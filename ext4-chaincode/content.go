@@ -0,0 +1,121 @@
+package main
+
+import (
+    "encoding/hex"
+    "fmt"
+    "strings"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Content digest algorithms: the SHA-256 of the content, or the fs-verity
+// file digest using SHA-256.
+const (
+    digestSHA256   = "sha256"
+    digestFsverity = "fsverity-sha256"
+)
+
+// ContentDigest is a hex digest of the content of a file, taken while the
+// file had the given mtime.
+type ContentDigest struct {
+    Algorithm string `json:"algorithm"`
+    Digest    string `json:"digest"`
+    Mtime     Time   `json:"mtime"`
+}
+
+// DigestVerification is the result of VerifyContentDigest. Current tells
+// whether the recorded digest was taken at the mtime the ledger holds for
+// the asset, that is whether no later write has been recorded since.
+type DigestVerification struct {
+    Match    bool           `json:"match"`
+    Current  bool           `json:"current"`
+    Recorded *ContentDigest `json:"recorded,omitempty" metadata:",optional"`
+}
+
+// normalizeDigest validates a hex digest and returns it in lower case.
+func normalizeDigest(algorithm, digest string) (string, error) {
+    switch algorithm {
+    case digestSHA256, digestFsverity:
+    default:
+        return "", fmt.Errorf("unsupported digest algorithm %q", algorithm)
+    }
+
+    sum, err := hex.DecodeString(digest)
+    if err != nil || len(sum) != 32 {
+        return "", fmt.Errorf("invalid %s digest %q", algorithm, digest)
+    }
+
+    return strings.ToLower(digest), nil
+}
+
+func timeBefore(a, b Time) bool {
+    return a.Sec < b.Sec || (a.Sec == b.Sec && a.Nsec < b.Nsec)
+}
+
+// RecordContentDigest records the digest of the content of a live asset.
+// Digests taken before the recorded mtime are stale and rejected; a digest
+// newer than the recorded mtime is accepted, as its SETATTR may still be on
+// its way.
+func (s *SmartContract) RecordContentDigest(ctx contractapi.TransactionContextInterface, fsUUID string, ino uint64, generation uint32, algorithm, digest string, mtimeSec int64, mtimeNsec uint32) error {
+    digest, err := normalizeDigest(algorithm, digest)
+    if err != nil {
+        return err
+    }
+
+    mtime := Time{Sec: mtimeSec, Nsec: mtimeNsec}
+    err = validateTime("mtime", mtime)
+    if err != nil {
+        return err
+    }
+
+    asset, err := getMutableAsset(ctx, fsUUID, ino, generation)
+    if err != nil {
+        return err
+    }
+
+    if timeBefore(mtime, asset.Mtime) {
+        return fmt.Errorf("digest of asset %s/%d@%d is older than its recorded mtime", fsUUID, ino, generation)
+    }
+
+    asset.Content = &ContentDigest{
+        Algorithm: algorithm,
+        Digest:    digest,
+        Mtime:     mtime,
+    }
+
+    fs, err := getFilesystem(ctx, fsUUID)
+    if err != nil {
+        return err
+    }
+
+    err = putAsset(ctx, asset, fs)
+    if err != nil {
+        return err
+    }
+
+    return emitAssetEvent(ctx, eventUpdate, []AssetChange{{FsUUID: fsUUID, Ino: ino, Generation: generation, Fields: []string{"content"}}})
+}
+
+// VerifyContentDigest compares a supplied digest with the one recorded for
+// the asset.
+func (s *SmartContract) VerifyContentDigest(ctx contractapi.TransactionContextInterface, fsUUID string, ino uint64, generation uint32, algorithm, digest string) (*DigestVerification, error) {
+    digest, err := normalizeDigest(algorithm, digest)
+    if err != nil {
+        return nil, err
+    }
+
+    asset, err := s.ReadAsset(ctx, fsUUID, ino, generation)
+    if err != nil {
+        return nil, err
+    }
+
+    if asset.Content == nil {
+        return nil, fmt.Errorf("no content digest recorded for asset %s/%d@%d", fsUUID, ino, generation)
+    }
+
+    recorded := asset.Content
+    return &DigestVerification{
+        Match:    recorded.Algorithm == algorithm && recorded.Digest == digest,
+        Current:  recorded.Mtime == asset.Mtime,
+        Recorded: recorded,
+    }, nil
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "strings"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Dentry is a directory entry: Name within the directory (ParentIno,
+// ParentGeneration) points at the asset (Ino, Generation).
+type Dentry struct {
+    FsUUID           string `json:"fsUuid"`
+    ParentIno        uint64 `json:"parentIno"`
+    ParentGeneration uint32 `json:"parentGeneration"`
+    Name             string `json:"name"`
+    Ino              uint64 `json:"ino"`
+    Generation       uint32 `json:"generation"`
+}
+
+// AssetPath is a path of an asset. Paths that could not be followed up to
+// the root directory start with "inode:<ino>@<generation>", naming the
+// topmost directory whose own entry is not recorded.
+type AssetPath struct {
+    Path     string `json:"path"`
+    Complete bool   `json:"complete"`
+}
+
+// Directory entries are stored under composite keys (fsUUID, parentIno,
+// parentGeneration, name), so the history of a key shows which inode a
+// name pointed at over time. The reverse index lists the entries of each
+// asset, which is what path resolution walks.
+const (
+    dentryObjectType = "dentry"
+    dentryIndex      = "dentry~fsUuid~ino~generation~parentIno~parentGeneration~name"
+)
+
+// ext4RootIno is EXT4_ROOT_INO, the inode of the root directory.
+const ext4RootIno = 2
+
+// maxPathDepth bounds path resolution, so that a corrupted index cannot
+// make it loop.
+const maxPathDepth = 4096
+
+func dentryKey(ctx contractapi.TransactionContextInterface, fsUUID string, parentIno uint64, parentGeneration uint32, name string) (string, error) {
+    if fsUUID == "" {
+        return "", fmt.Errorf("missing filesystem UUID")
+    }
+
+    if parentIno == 0 || name == "" {
+        return "", fmt.Errorf("missing parent inode number or name")
+    }
+
+    return ctx.GetStub().CreateCompositeKey(dentryObjectType, []string{fsUUID, formatUint(parentIno), formatUint(uint64(parentGeneration)), name})
+}
+
+func dentryIndexKey(ctx contractapi.TransactionContextInterface, dentry *Dentry) (string, error) {
+    return ctx.GetStub().CreateCompositeKey(dentryIndex, []string{
+        dentry.FsUUID,
+        formatUint(dentry.Ino),
+        formatUint(uint64(dentry.Generation)),
+        formatUint(dentry.ParentIno),
+        formatUint(uint64(dentry.ParentGeneration)),
+        dentry.Name,
+    })
+}
+
+// LinkAsset records a new directory entry for a live asset, as created by
+// create, mkdir or link. An entry of the same name may only be replaced if
+// it points at the same asset or at one that is no longer live, which the
+// ledger missed the removal of.
+func (s *SmartContract) LinkAsset(ctx contractapi.TransactionContextInterface, fsUUID string, ino uint64, generation uint32, parentIno uint64, parentGeneration uint32, name string) error {
+    _, err := getMutableAsset(ctx, fsUUID, ino, generation)
+    if err != nil {
+        return err
+    }
+
+    fs, err := getFilesystem(ctx, fsUUID)
+    if err != nil {
+        return err
+    }
+
+    err = addDentry(ctx, fs, &Dentry{
+        FsUUID:           fsUUID,
+        ParentIno:        parentIno,
+        ParentGeneration: parentGeneration,
+        Name:             name,
+        Ino:              ino,
+        Generation:       generation,
+    }, false)
+    if err != nil {
+        return err
+    }
+
+    return emitAssetEvent(ctx, eventLink, []AssetChange{{FsUUID: fsUUID, Ino: ino, Generation: generation, Fields: []string{"dentry"}}})
+}
+
+// UnlinkAsset records the removal of a directory entry of an asset.
+// Entries that were never recorded are removed all the same, as they may
+// predate the tracking; a recorded entry must point at the asset.
+func (s *SmartContract) UnlinkAsset(ctx contractapi.TransactionContextInterface, fsUUID string, ino uint64, generation uint32, parentIno uint64, parentGeneration uint32, name string) error {
+    _, err := getMutableAsset(ctx, fsUUID, ino, generation)
+    if err != nil {
+        return err
+    }
+
+    err = removeDentry(ctx, fsUUID, parentIno, parentGeneration, name, ino, generation)
+    if err != nil {
+        return err
+    }
+
+    return emitAssetEvent(ctx, eventUnlink, []AssetChange{{FsUUID: fsUUID, Ino: ino, Generation: generation, Fields: []string{"dentry"}}})
+}
+
+// RenameAsset moves a directory entry of an asset in a single transaction.
+// The entry at the old name must point at the asset. An entry at the new
+// name pointing at another live asset is replaced only if the submitter may
+// change that asset too.
+func (s *SmartContract) RenameAsset(ctx contractapi.TransactionContextInterface, fsUUID string, ino uint64, generation uint32, oldParentIno uint64, oldParentGeneration uint32, oldName string, newParentIno uint64, newParentGeneration uint32, newName string) error {
+    _, err := getMutableAsset(ctx, fsUUID, ino, generation)
+    if err != nil {
+        return err
+    }
+
+    err = removeDentry(ctx, fsUUID, oldParentIno, oldParentGeneration, oldName, ino, generation)
+    if err != nil {
+        return err
+    }
+
+    fs, err := getFilesystem(ctx, fsUUID)
+    if err != nil {
+        return err
+    }
+
+    err = addDentry(ctx, fs, &Dentry{
+        FsUUID:           fsUUID,
+        ParentIno:        newParentIno,
+        ParentGeneration: newParentGeneration,
+        Name:             newName,
+        Ino:              ino,
+        Generation:       generation,
+    }, true)
+    if err != nil {
+        return err
+    }
+
+    return emitAssetEvent(ctx, eventRename, []AssetChange{{FsUUID: fsUUID, Ino: ino, Generation: generation, Fields: []string{"dentry"}}})
+}
+
+func getDentry(ctx contractapi.TransactionContextInterface, key string) (*Dentry, error) {
+    dentryJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read dentry: %v", err)
+    }
+
+    if dentryJSON == nil {
+        return nil, nil
+    }
+
+    var dentry Dentry
+    err = json.Unmarshal(dentryJSON, &dentry)
+    if err != nil {
+        return nil, fmt.Errorf("failed to unmarshal dentry: %v", err)
+    }
+
+    return &dentry, nil
+}
+
+// addDentry stores dentry and its reverse index entry under the endorsement
+// policy of the filesystem fs. An entry of the same name pointing at another
+// live asset is refused, unless replace is set and the submitter may change
+// that asset.
+func addDentry(ctx contractapi.TransactionContextInterface, fs *Filesystem, dentry *Dentry, replace bool) error {
+    key, err := dentryKey(ctx, dentry.FsUUID, dentry.ParentIno, dentry.ParentGeneration, dentry.Name)
+    if err != nil {
+        return err
+    }
+
+    old, err := getDentry(ctx, key)
+    if err != nil {
+        return err
+    }
+
+    if old != nil {
+        if !old.pointsAt(dentry.Ino, dentry.Generation) {
+            err = checkReplace(ctx, old, replace)
+            if err != nil {
+                return err
+            }
+        }
+
+        err = removeDentryIndex(ctx, old)
+        if err != nil {
+            return err
+        }
+    }
+
+    dentryJSON, err := json.Marshal(dentry)
+    if err != nil {
+        return err
+    }
+
+    err = ctx.GetStub().PutState(key, dentryJSON)
+    if err != nil {
+        return err
+    }
+
+    err = setEndorsementPolicy(ctx, key, fs)
+    if err != nil {
+        return err
+    }
+
+    indexKey, err := dentryIndexKey(ctx, dentry)
+    if err != nil {
+        return err
+    }
+
+    err = ctx.GetStub().PutState(indexKey, indexValue)
+    if err != nil {
+        return err
+    }
+
+    return setEndorsementPolicy(ctx, indexKey, fs)
+}
+
+// checkReplace decides whether the entry old, which points at another asset
+// than the one being linked, may be replaced.
+func checkReplace(ctx contractapi.TransactionContextInterface, old *Dentry, replace bool) error {
+    displaced, err := getAsset(ctx, old.FsUUID, old.Ino, old.Generation)
+    if err != nil {
+        return err
+    }
+
+    if displaced == nil || displaced.Deleted != nil {
+        return nil
+    }
+
+    if !replace {
+        return fmt.Errorf("%s: the entry %s in %d@%d points at asset %s/%d@%d", accessDenied, old.Name, old.ParentIno, old.ParentGeneration, old.FsUUID, old.Ino, old.Generation)
+    }
+
+    return authorize(ctx, displaced)
+}
+
+// removeDentry removes the entry (parentIno, parentGeneration, name) of the
+// asset (ino, generation). A recorded entry pointing at another asset is
+// refused.
+func removeDentry(ctx contractapi.TransactionContextInterface, fsUUID string, parentIno uint64, parentGeneration uint32, name string, ino uint64, generation uint32) error {
+    key, err := dentryKey(ctx, fsUUID, parentIno, parentGeneration, name)
+    if err != nil {
+        return err
+    }
+
+    old, err := getDentry(ctx, key)
+    if err != nil {
+        return err
+    }
+
+    if old != nil {
+        if !old.pointsAt(ino, generation) {
+            return fmt.Errorf("%s: the entry %s in %d@%d points at asset %s/%d@%d", accessDenied, name, parentIno, parentGeneration, fsUUID, old.Ino, old.Generation)
+        }
+
+        err = removeDentryIndex(ctx, old)
+        if err != nil {
+            return err
+        }
+    }
+
+    return ctx.GetStub().DelState(key)
+}
+
+func (d *Dentry) pointsAt(ino uint64, generation uint32) bool {
+    return d.Ino == ino && d.Generation == generation
+}
+
+// removeDentryIndex drops the reverse index entry of old. The dentry itself
+// is left to the caller.
+func removeDentryIndex(ctx contractapi.TransactionContextInterface, old *Dentry) error {
+    indexKey, err := dentryIndexKey(ctx, old)
+    if err != nil {
+        return err
+    }
+
+    return ctx.GetStub().DelState(indexKey)
+}
+
+// assetDentries returns the current directory entries of an asset.
+func assetDentries(ctx contractapi.TransactionContextInterface, fsUUID string, ino uint64, generation uint32) ([]Dentry, error) {
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(dentryIndex, []string{fsUUID, formatUint(ino), formatUint(uint64(generation))})
+    if err != nil {
+        return nil, fmt.Errorf("failed to query index %s: %v", dentryIndex, err)
+    }
+    defer resultsIterator.Close()
+
+    dentries := []Dentry{}
+    for resultsIterator.HasNext() {
+        result, err := resultsIterator.Next()
+        if err != nil {
+            return nil, fmt.Errorf("failed to read query result: %v", err)
+        }
+
+        _, keyParts, err := ctx.GetStub().SplitCompositeKey(result.GetKey())
+        if err != nil {
+            return nil, err
+        }
+
+        if len(keyParts) != 6 {
+            return nil, fmt.Errorf("invalid key %q in index %s", result.GetKey(), dentryIndex)
+        }
+
+        parentIno, err := strconv.ParseUint(keyParts[3], 10, 64)
+        if err != nil {
+            return nil, fmt.Errorf("invalid key %q in index %s", result.GetKey(), dentryIndex)
+        }
+
+        parentGeneration, err := strconv.ParseUint(keyParts[4], 10, 32)
+        if err != nil {
+            return nil, fmt.Errorf("invalid key %q in index %s", result.GetKey(), dentryIndex)
+        }
+
+        dentries = append(dentries, Dentry{
+            FsUUID:           fsUUID,
+            ParentIno:        parentIno,
+            ParentGeneration: uint32(parentGeneration),
+            Name:             keyParts[5],
+            Ino:              ino,
+            Generation:       generation,
+        })
+    }
+
+    return dentries, nil
+}
+
+// ResolveAssetPaths returns the current paths of an asset, one per hard
+// link, by following directory entries up to the root directory.
+func (s *SmartContract) ResolveAssetPaths(ctx contractapi.TransactionContextInterface, fsUUID string, ino uint64, generation uint32) ([]AssetPath, error) {
+    _, err := assetKey(ctx, fsUUID, ino, generation)
+    if err != nil {
+        return nil, err
+    }
+
+    dentries, err := assetDentries(ctx, fsUUID, ino, generation)
+    if err != nil {
+        return nil, err
+    }
+
+    paths := []AssetPath{}
+    for _, dentry := range dentries {
+        path, err := resolvePath(ctx, dentry)
+        if err != nil {
+            return nil, err
+        }
+
+        paths = append(paths, path)
+    }
+
+    return paths, nil
+}
+
+// resolvePath walks up from dentry. Directories have a single entry, so
+// only the first one found is followed.
+func resolvePath(ctx contractapi.TransactionContextInterface, dentry Dentry) (AssetPath, error) {
+    names := []string{dentry.Name}
+    ino, generation := dentry.ParentIno, dentry.ParentGeneration
+
+    for depth := 0; ino != ext4RootIno; depth++ {
+        if depth == maxPathDepth {
+            return AssetPath{}, fmt.Errorf("path of %s/%d@%d is deeper than %d", dentry.FsUUID, dentry.Ino, dentry.Generation, maxPathDepth)
+        }
+
+        parents, err := assetDentries(ctx, dentry.FsUUID, ino, generation)
+        if err != nil {
+            return AssetPath{}, err
+        }
+
+        if len(parents) == 0 {
+            names = append(names, fmt.Sprintf("inode:%d@%d", ino, generation))
+            return AssetPath{Path: joinPath(names, false)}, nil
+        }
+
+        names = append(names, parents[0].Name)
+        ino, generation = parents[0].ParentIno, parents[0].ParentGeneration
+    }
+
+    return AssetPath{Path: joinPath(names, true), Complete: true}, nil
+}
+
+// joinPath joins names collected from the leaf up.
+func joinPath(names []string, absolute bool) string {
+    reversed := make([]string, len(names))
+    for i, name := range names {
+        reversed[len(names)-1-i] = name
+    }
+
+    path := strings.Join(reversed, "/")
+    if absolute {
+        path = "/" + path
+    }
+
+    return path
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Every filesystem belongs to the organization whose identity created its
+// first asset. Every key written for the filesystem, public or private,
+// carries a key-level endorsement policy requiring a peer of that
+// organization, and of the auditor organization if one is configured, so
+// that the peers of other organizations cannot rewrite them on their own:
+// the assets, their extended attributes, directory entries and index
+// entries, and the private ownership records. Keys written before the
+// filesystem was registered keep the chaincode-level policy until they are
+// written again.
+
+// auditorMSPID is the MSP ID of the auditor organization, read from the
+// EXT4_AUDITOR_MSPID environment variable of the chaincode process. It is
+// empty when there is no auditor.
+var auditorMSPID = os.Getenv("EXT4_AUDITOR_MSPID")
+
+// Filesystem records the organization a filesystem belongs to.
+type Filesystem struct {
+    FsUUID string `json:"fsUuid"`
+    MSPID  string `json:"mspId"`
+}
+
+const filesystemObjectType = "filesystem"
+
+func filesystemKey(ctx contractapi.TransactionContextInterface, fsUUID string) (string, error) {
+    if fsUUID == "" {
+        return "", fmt.Errorf("missing filesystem UUID")
+    }
+
+    return ctx.GetStub().CreateCompositeKey(filesystemObjectType, []string{fsUUID})
+}
+
+// GetFilesystem returns the organization a filesystem belongs to.
+func (s *SmartContract) GetFilesystem(ctx contractapi.TransactionContextInterface, fsUUID string) (*Filesystem, error) {
+    fs, err := getFilesystem(ctx, fsUUID)
+    if err != nil {
+        return nil, err
+    }
+
+    if fs == nil {
+        return nil, fmt.Errorf("the filesystem %s does not exist", fsUUID)
+    }
+
+    return fs, nil
+}
+
+func getFilesystem(ctx contractapi.TransactionContextInterface, fsUUID string) (*Filesystem, error) {
+    key, err := filesystemKey(ctx, fsUUID)
+    if err != nil {
+        return nil, err
+    }
+
+    fsJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read from world state: %v", err)
+    }
+
+    if fsJSON == nil {
+        return nil, nil
+    }
+
+    var fs Filesystem
+    err = json.Unmarshal(fsJSON, &fs)
+    if err != nil {
+        return nil, err
+    }
+
+    return &fs, nil
+}
+
+// claimFilesystem returns the filesystem an asset is being created in,
+// registering it to the submitter's organization if it is new. Only
+// identities of the owning organization and admins may create assets in a
+// registered filesystem.
+func claimFilesystem(ctx contractapi.TransactionContextInterface, fsUUID string, submitter Submitter) (*Filesystem, error) {
+    fs, err := getFilesystem(ctx, fsUUID)
+    if err != nil {
+        return nil, err
+    }
+
+    if fs != nil {
+        if fs.MSPID == submitter.MSPID {
+            return fs, nil
+        }
+
+        admin, err := isAdmin(ctx)
+        if err != nil {
+            return nil, err
+        }
+
+        if !admin {
+            return nil, fmt.Errorf("%s: the filesystem %s belongs to %s", accessDenied, fsUUID, fs.MSPID)
+        }
+
+        return fs, nil
+    }
+
+    fs = &Filesystem{FsUUID: fsUUID, MSPID: submitter.MSPID}
+
+    key, err := filesystemKey(ctx, fsUUID)
+    if err != nil {
+        return nil, err
+    }
+
+    fsJSON, err := json.Marshal(fs)
+    if err != nil {
+        return nil, err
+    }
+
+    err = ctx.GetStub().PutState(key, fsJSON)
+    if err != nil {
+        return nil, err
+    }
+
+    err = setEndorsementPolicy(ctx, key, fs)
+    if err != nil {
+        return nil, err
+    }
+
+    return fs, nil
+}
+
+// lookupFilesystem returns the filesystem of an asset, caching it in
+// filesystems for transactions touching many assets. It returns nil for
+// filesystems registered before key-level policies were introduced.
+func lookupFilesystem(ctx contractapi.TransactionContextInterface, filesystems map[string]*Filesystem, fsUUID string) (*Filesystem, error) {
+    if fs, ok := filesystems[fsUUID]; ok {
+        return fs, nil
+    }
+
+    fs, err := getFilesystem(ctx, fsUUID)
+    if err != nil {
+        return nil, err
+    }
+
+    filesystems[fsUUID] = fs
+    return fs, nil
+}
+
+// setEndorsementPolicy restricts the endorsement of later changes to key
+// to the peers of the organization fs belongs to and of the auditor. It
+// does nothing if fs is nil.
+func setEndorsementPolicy(ctx contractapi.TransactionContextInterface, key string, fs *Filesystem) error {
+    if fs == nil {
+        return nil
+    }
+
+    policy, err := endorsementPolicy(fs)
+    if err != nil {
+        return err
+    }
+
+    err = ctx.GetStub().SetStateValidationParameter(key, policy)
+    if err != nil {
+        return fmt.Errorf("failed to set endorsement policy: %v", err)
+    }
+
+    return nil
+}
+
+// setPrivateEndorsementPolicy is setEndorsementPolicy for a key of a
+// private data collection.
+func setPrivateEndorsementPolicy(ctx contractapi.TransactionContextInterface, collection, key string, fs *Filesystem) error {
+    if fs == nil {
+        return nil
+    }
+
+    policy, err := endorsementPolicy(fs)
+    if err != nil {
+        return err
+    }
+
+    err = ctx.GetStub().SetPrivateDataValidationParameter(collection, key, policy)
+    if err != nil {
+        return fmt.Errorf("failed to set endorsement policy: %v", err)
+    }
+
+    return nil
+}
+
+func endorsementPolicy(fs *Filesystem) ([]byte, error) {
+    ep, err := statebased.NewStateEP(nil)
+    if err != nil {
+        return nil, err
+    }
+
+    orgs := []string{fs.MSPID}
+    if auditorMSPID != "" {
+        orgs = append(orgs, auditorMSPID)
+    }
+
+    err = ep.AddOrgs(statebased.RoleTypePeer, orgs...)
+    if err != nil {
+        return nil, fmt.Errorf("failed to build endorsement policy: %v", err)
+    }
+
+    policy, err := ep.Policy()
+    if err != nil {
+        return nil, fmt.Errorf("failed to build endorsement policy: %v", err)
+    }
+
+    return policy, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Fabric keeps a single chaincode event per transaction, so every mutating
+// function emits exactly one AssetChanged event describing all the assets it
+// touched.
+const assetChangedEvent = "AssetChanged"
+
+const (
+    eventCreate = "create"
+    eventUpdate = "update"
+    eventDelete = "delete"
+    eventLink   = "link"
+    eventUnlink = "unlink"
+    eventRename = "rename"
+)
+
+type Submitter struct {
+    MSPID string `json:"mspId"`
+    ID    string `json:"id"`
+}
+
+type AssetChange struct {
+    FsUUID     string   `json:"fsUuid"`
+    Ino        uint64   `json:"ino"`
+    Generation uint32   `json:"generation"`
+    Fields     []string `json:"fields"`
+}
+
+type AssetEvent struct {
+    Type      string        `json:"type"`
+    Submitter Submitter     `json:"submitter"`
+    Changes   []AssetChange `json:"changes"`
+}
+
+func getSubmitter(ctx contractapi.TransactionContextInterface) (Submitter, error) {
+    clientID := ctx.GetClientIdentity()
+
+    mspID, err := clientID.GetMSPID()
+    if err != nil {
+        return Submitter{}, fmt.Errorf("failed to get submitter MSP ID: %v", err)
+    }
+
+    id, err := clientID.GetID()
+    if err != nil {
+        return Submitter{}, fmt.Errorf("failed to get submitter ID: %v", err)
+    }
+
+    return Submitter{MSPID: mspID, ID: id}, nil
+}
+
+// emitAssetEvent sets the AssetChanged event of the transaction. Changes
+// without any modified field are left out, and nothing is emitted when no
+// change remains.
+func emitAssetEvent(ctx contractapi.TransactionContextInterface, eventType string, changes []AssetChange) error {
+    var changed []AssetChange
+    for _, change := range changes {
+        if len(change.Fields) > 0 {
+            changed = append(changed, change)
+        }
+    }
+
+    if len(changed) == 0 {
+        return nil
+    }
+
+    submitter, err := getSubmitter(ctx)
+    if err != nil {
+        return err
+    }
+
+    eventJSON, err := json.Marshal(AssetEvent{
+        Type:      eventType,
+        Submitter: submitter,
+        Changes:   changed,
+    })
+    if err != nil {
+        return err
+    }
+
+    return ctx.GetStub().SetEvent(assetChangedEvent, eventJSON)
+}
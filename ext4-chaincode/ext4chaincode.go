@@ -4,6 +4,8 @@ import (
     "fmt"
     "encoding/json"
     "log"
+    "strconv"
+    "time"
     "github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
@@ -11,32 +13,126 @@ type SmartContract struct {
     contractapi.Contract
 }
 
+// Time is a timestamp as ext4 stores it. Seconds are signed, so times
+// before 1970 can be recorded.
 type Time struct {
-    Sec  string `json:"sec"`
-    Nsec string `json:"nsec"`
+    Sec  int64  `json:"sec"`
+    Nsec uint32 `json:"nsec"`
 }
 
 type Asset struct {
-    Uid   string `json:"uid"`
-    Gid   string `json:"gid"`
-    Atime Time   `json:"atime"`
-    Mtime Time   `json:"mtime"`
-    Ctime Time   `json:"ctime"`
-    Mode  string `json:"mode"`
-    Ino   string `json:"ino"`
+    // SchemaVersion is the version of the record layout, see schema.go.
+    SchemaVersion int    `json:"schemaVersion"`
+    Uid           uint32 `json:"uid"`
+    Gid           uint32 `json:"gid"`
+    Atime         Time   `json:"atime"`
+    Mtime         Time   `json:"mtime"`
+    Ctime         Time   `json:"ctime"`
+    Mode          uint32 `json:"mode"`
+    Ino           uint64 `json:"ino"`
+    FsUUID        string `json:"fsUuid"`
+    Generation    uint32 `json:"generation"`
+    Size          uint64 `json:"size"`
+    Nlink         uint32 `json:"nlink"`
+    // Blocks is i_blocks, counted in 512-byte sectors.
+    Blocks uint64 `json:"blocks"`
+    // Crtime is ext4's i_crtime, the birth time of the inode.
+    Crtime Time `json:"crtime"`
+    // Flags are the ext4 inode flags (EXT4_*_FL) as chattr sets them.
+    Flags  uint32 `json:"flags"`
+    Projid uint32 `json:"projid"`
+    // Content is the last recorded digest of the content of a regular
+    // file, see content.go.
+    Content *ContentDigest `json:"content,omitempty" metadata:",optional"`
+    // Owner is the identity that created the asset, see access.go.
+    Owner *Submitter `json:"owner,omitempty" metadata:",optional"`
+    // Private is set when uid, gid and mode are kept in a private data
+    // collection, see private.go. They are zero in public state then.
+    Private *PrivateOwnership `json:"private,omitempty" metadata:",optional"`
+    // Deleted is set once the inode has been deleted. The record is kept
+    // as a tombstone so that the ledger shows when and by whom.
+    Deleted *Tombstone `json:"deleted,omitempty" metadata:",optional"`
 }
 
-func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface, uid, gid, atimeSec, atimeNsec, mtimeSec, mtimeNsec, ctimeSec, ctimeNsec, mode, ino string) error {
-    exists, err := s.AssetExists(ctx, ino)
-    
-    if err != nil {
-        return err
+// AssetKey identifies an asset: inode numbers are only unique within the
+// filesystem with the given superblock UUID, and ext4 bumps the generation
+// whenever it reuses one.
+type AssetKey struct {
+    FsUUID     string `json:"fsUuid"`
+    Ino        uint64 `json:"ino"`
+    Generation uint32 `json:"generation"`
+}
+
+// AssetUpdate is an update passed to BatchUpdateAssets. Valid is the
+// validity mask described at UpdateAsset.
+type AssetUpdate struct {
+    Asset
+    Valid uint32 `json:"valid"`
+}
+
+type Tombstone struct {
+    Time      string    `json:"time"`
+    Submitter Submitter `json:"submitter"`
+}
+
+// Assets are stored under composite keys (fsUUID, ino, generation), so the
+// records of each filesystem are isolated and can be range-queried, and a
+// reused inode number starts a fresh record with its own history.
+const assetObjectType = "asset"
+
+func assetKey(ctx contractapi.TransactionContextInterface, fsUUID string, ino uint64, generation uint32) (string, error) {
+    if fsUUID == "" {
+        return "", fmt.Errorf("missing filesystem UUID")
     }
-    
-    if exists {
-        return fmt.Errorf("the asset %s already exists", ino)
+
+    if ino == 0 {
+        return "", fmt.Errorf("missing inode number")
     }
-    
+
+    return ctx.GetStub().CreateCompositeKey(assetObjectType, []string{fsUUID, formatUint(ino), formatUint(uint64(generation))})
+}
+
+func formatUint(value uint64) string {
+    return strconv.FormatUint(value, 10)
+}
+
+func validateTime(name string, t Time) error {
+    if t.Nsec >= 1e9 {
+        return fmt.Errorf("invalid %s nanoseconds %d", name, t.Nsec)
+    }
+
+    return nil
+}
+
+// validateAsset checks the fields of asset that the validity mask marks as
+// set.
+func validateAsset(asset *Asset, valid uint32) error {
+    times := []struct {
+        name string
+        bit  uint32
+        time Time
+    }{
+        {"atime", validAtime, asset.Atime},
+        {"mtime", validMtime, asset.Mtime},
+        {"ctime", validCtime, asset.Ctime},
+        {"crtime", validCrtime, asset.Crtime},
+    }
+
+    for _, t := range times {
+        if valid&t.bit == 0 {
+            continue
+        }
+
+        err := validateTime(t.name, t.time)
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface, fsUUID string, uid, gid uint32, atimeSec int64, atimeNsec uint32, mtimeSec int64, mtimeNsec uint32, ctimeSec int64, ctimeNsec uint32, mode uint32, ino uint64, generation uint32, size uint64, nlink uint32, blocks uint64, crtimeSec int64, crtimeNsec uint32, flags, projid uint32) error {
     asset := Asset{
         Uid: uid,
         Gid: gid,
@@ -52,102 +148,479 @@ func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface,
             Sec:  ctimeSec,
             Nsec: ctimeNsec,
         },
-        Mode: mode,
-        Ino:  ino,
+        Mode:       mode,
+        Ino:        ino,
+        FsUUID:     fsUUID,
+        Generation: generation,
+        Size:       size,
+        Nlink:      nlink,
+        Blocks:     blocks,
+        Crtime: Time{
+            Sec:  crtimeSec,
+            Nsec: crtimeNsec,
+        },
+        Flags:  flags,
+        Projid: projid,
     }
-  
-    assetJSON, err := json.Marshal(asset)
+
+    current, err := getAsset(ctx, fsUUID, ino, generation)
+    if err != nil {
+        return err
+    }
+
+    if current != nil && current.Deleted == nil {
+        return fmt.Errorf("the asset %s/%d@%d already exists", fsUUID, ino, generation)
+    }
+
+    ownership, err := transientOwnership(ctx)
+    if err != nil {
+        return err
+    }
+
+    change, err := createAsset(ctx, &asset, current, ownership, map[string]*Filesystem{})
     if err != nil {
         return err
     }
 
-    assetKey := fmt.Sprintf("asset_%s", ino)
-    return ctx.GetStub().PutState(assetKey, assetJSON)
+    return emitAssetEvent(ctx, eventCreate, []AssetChange{*change})
 }
 
-func (s *SmartContract) UpdateAsset(ctx contractapi.TransactionContextInterface, uid, gid, atimeSec, atimeNsec, mtimeSec, mtimeNsec, ctimeSec, ctimeNsec, mode, ino string) error {
-    exists, err := s.AssetExists(ctx, ino)
-    
+// BatchCreateAssets creates many assets in a single transaction, as when
+// an existing filesystem is enrolled. Assets that already exist are
+// skipped and their keys are returned, so that an interrupted enrollment
+// can simply be repeated.
+func (s *SmartContract) BatchCreateAssets(ctx contractapi.TransactionContextInterface, assets []Asset) ([]AssetKey, error) {
+    ownership, err := transientOwnership(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    existing := []AssetKey{}
+    changes := []AssetChange{}
+    created := make(map[AssetKey]bool)
+    filesystems := make(map[string]*Filesystem)
+
+    for i := range assets {
+        asset := &assets[i]
+        key := AssetKey{FsUUID: asset.FsUUID, Ino: asset.Ino, Generation: asset.Generation}
+
+        current, err := getAsset(ctx, asset.FsUUID, asset.Ino, asset.Generation)
+        if err != nil {
+            return nil, err
+        }
+
+        // Reads do not see the writes of the transaction, so repeated
+        // keys are caught here.
+        if created[key] || (current != nil && current.Deleted == nil) {
+            existing = append(existing, key)
+            continue
+        }
+
+        change, err := createAsset(ctx, asset, current, ownership, filesystems)
+        if err != nil {
+            return nil, err
+        }
+
+        created[key] = true
+        changes = append(changes, *change)
+    }
+
+    err = emitAssetEvent(ctx, eventCreate, changes)
+    if err != nil {
+        return nil, err
+    }
+
+    return existing, nil
+}
+
+// createAsset stores asset as a new asset owned by the submitter, replacing
+// current if it is a tombstone. filesystems caches the filesystems claimed
+// in the transaction, which their records do not show yet.
+func createAsset(ctx contractapi.TransactionContextInterface, asset *Asset, current *Asset, ownership map[AssetKey]Ownership, filesystems map[string]*Filesystem) (*AssetChange, error) {
+    // Only whoever could change the deleted asset may replace its
+    // tombstone.
+    if current != nil {
+        err := authorize(ctx, current)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    owner, err := getSubmitter(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    fs, ok := filesystems[asset.FsUUID]
+    if !ok {
+        fs, err = claimFilesystem(ctx, asset.FsUUID, owner)
+        if err != nil {
+            return nil, err
+        }
+        filesystems[asset.FsUUID] = fs
+    }
+
+    asset.Owner = &owner
+    asset.Private = nil
+    asset.Content = nil
+    asset.Deleted = nil
+
+    err = validateAsset(asset, validAll)
+    if err != nil {
+        return nil, err
+    }
+
+    valid, privateFields, err := applyOwnership(ctx, asset, ownership, validAll, fs)
+    if err != nil {
+        return nil, err
+    }
+
+    err = putAsset(ctx, asset, fs)
+    if err != nil {
+        return nil, err
+    }
+
+    return &AssetChange{
+        FsUUID:     asset.FsUUID,
+        Ino:        asset.Ino,
+        Generation: asset.Generation,
+        Fields:     append(applyUpdate(&Asset{}, *asset, valid), privateFields...),
+    }, nil
+}
+
+// UpdateAsset sets the fields whose bit is set in valid, a mask of the
+// valid* bits, and leaves the others unchanged, so that zero uids and
+// timestamps can be recorded.
+func (s *SmartContract) UpdateAsset(ctx contractapi.TransactionContextInterface, fsUUID string, uid, gid uint32, atimeSec int64, atimeNsec uint32, mtimeSec int64, mtimeNsec uint32, ctimeSec int64, ctimeNsec uint32, mode uint32, ino uint64, generation uint32, size uint64, nlink uint32, blocks uint64, crtimeSec int64, crtimeNsec uint32, flags, projid uint32, valid uint32) error {
+    asset, err := getMutableAsset(ctx, fsUUID, ino, generation)
     if err != nil {
         return err
     }
-    
-    if !exists {
-        return fmt.Errorf("the asset %s does not exist", ino)
+
+    update := Asset{
+        Uid: uid,
+        Gid: gid,
+        Atime: Time{
+            Sec:  atimeSec,
+            Nsec: atimeNsec,
+        },
+        Mtime: Time{
+            Sec:  mtimeSec,
+            Nsec: mtimeNsec,
+        },
+        Ctime: Time{
+            Sec:  ctimeSec,
+            Nsec: ctimeNsec,
+        },
+        Mode:   mode,
+        Size:   size,
+        Nlink:  nlink,
+        Blocks: blocks,
+        Crtime: Time{
+            Sec:  crtimeSec,
+            Nsec: crtimeNsec,
+        },
+        Flags:  flags,
+        Projid: projid,
+    }
+
+    err = validateAsset(&update, valid)
+    if err != nil {
+        return err
     }
 
-    asset, err := s.ReadAsset(ctx, ino)
+    ownership, err := transientOwnership(ctx)
     if err != nil {
         return err
     }
-   
-    if uid != "" {
-        asset.Uid = uid
+
+    fs, err := getFilesystem(ctx, fsUUID)
+    if err != nil {
+        return err
     }
-    if gid != "" {
-        asset.Gid = gid
+
+    valid, fields, err := applyOwnership(ctx, asset, ownership, valid, fs)
+    if err != nil {
+        return err
     }
-    if atimeSec != "" {
-        asset.Atime.Sec = atimeSec
+
+    fields = append(fields, applyUpdate(asset, update, valid)...)
+
+    err = putAsset(ctx, asset, fs)
+    if err != nil {
+        return err
     }
-    if atimeNsec != "" {
-        asset.Atime.Nsec = atimeNsec
+
+    return emitAssetEvent(ctx, eventUpdate, []AssetChange{{FsUUID: fsUUID, Ino: ino, Generation: generation, Fields: fields}})
+}
+
+// BatchUpdateAssets applies many updates in a single transaction. Updates
+// follow the rules of UpdateAsset; several updates of the same asset are
+// merged in order. Updates for assets that do not exist are skipped and
+// their keys are returned, so that one missing inode does not fail the
+// whole batch.
+func (s *SmartContract) BatchUpdateAssets(ctx contractapi.TransactionContextInterface, updates []AssetUpdate) ([]AssetKey, error) {
+    missing := []AssetKey{}
+    changes := []AssetChange{}
+    filesystems := make(map[string]*Filesystem)
+
+    ownership, err := transientOwnership(ctx)
+    if err != nil {
+        return nil, err
     }
-    if mtimeSec != "" {
-        asset.Mtime.Sec = mtimeSec
+
+    for _, update := range mergeUpdates(updates) {
+        err := validateAsset(&update.Asset, update.Valid)
+        if err != nil {
+            return nil, err
+        }
+
+        exists, err := s.AssetExists(ctx, update.FsUUID, update.Ino, update.Generation)
+        if err != nil {
+            return nil, err
+        }
+
+        if !exists {
+            missing = append(missing, AssetKey{FsUUID: update.FsUUID, Ino: update.Ino, Generation: update.Generation})
+            continue
+        }
+
+        asset, err := getMutableAsset(ctx, update.FsUUID, update.Ino, update.Generation)
+        if err != nil {
+            return nil, err
+        }
+
+        fs, err := lookupFilesystem(ctx, filesystems, update.FsUUID)
+        if err != nil {
+            return nil, err
+        }
+
+        valid, fields, err := applyOwnership(ctx, asset, ownership, update.Valid, fs)
+        if err != nil {
+            return nil, err
+        }
+
+        fields = append(fields, applyUpdate(asset, update.Asset, valid)...)
+
+        err = putAsset(ctx, asset, fs)
+        if err != nil {
+            return nil, err
+        }
+
+        changes = append(changes, AssetChange{FsUUID: update.FsUUID, Ino: update.Ino, Generation: update.Generation, Fields: fields})
     }
-    if mtimeNsec != "" {
-        asset.Mtime.Nsec = mtimeNsec
+
+    err = emitAssetEvent(ctx, eventUpdate, changes)
+    if err != nil {
+        return nil, err
+    }
+
+    return missing, nil
+}
+
+// mergeUpdates merges the updates of the same asset into the first one, as
+// reads do not see the writes of the transaction and a second update would
+// be applied to the committed record, losing the first.
+func mergeUpdates(updates []AssetUpdate) []AssetUpdate {
+    merged := make([]AssetUpdate, 0, len(updates))
+    positions := make(map[AssetKey]int, len(updates))
+
+    for _, update := range updates {
+        key := AssetKey{FsUUID: update.FsUUID, Ino: update.Ino, Generation: update.Generation}
+        i, ok := positions[key]
+        if !ok {
+            positions[key] = len(merged)
+            merged = append(merged, update)
+            continue
+        }
+
+        // The later update wins for the fields it sets, and the
+        // earlier one keeps the others.
+        applyUpdate(&merged[i].Asset, update.Asset, update.Valid)
+        merged[i].Valid |= update.Valid
+    }
+
+    return merged
+}
+
+// Bits of the validity mask of updates. They have the values of the
+// daemon's EXT4B_VALID_*, which follow the kernel's ia_valid where it has a
+// matching bit.
+const (
+    validMode   = 1 << 0
+    validUid    = 1 << 1
+    validGid    = 1 << 2
+    validSize   = 1 << 3
+    validAtime  = 1 << 4
+    validMtime  = 1 << 5
+    validCtime  = 1 << 6
+    validNlink  = 1 << 24
+    validBlocks = 1 << 25
+    validCrtime = 1 << 26
+    validFlags  = 1 << 27
+    validProjid = 1 << 28
+
+    validAll = validMode | validUid | validGid | validSize | validAtime | validMtime | validCtime | validNlink | validBlocks | validCrtime | validFlags | validProjid
+)
+
+// applyUpdate copies the fields of update whose bit is set in valid to asset
+// and returns the names of the fields whose value changed.
+func applyUpdate(asset *Asset, update Asset, valid uint32) []string {
+    var fields []string
+    set := func(name string, bit uint32, field *uint32, value uint32) {
+        if valid&bit != 0 && value != *field {
+            *field = value
+            fields = append(fields, name)
+        }
     }
-    if ctimeSec != "" {
-        asset.Ctime.Sec = ctimeSec
+    set64 := func(name string, bit uint32, field *uint64, value uint64) {
+        if valid&bit != 0 && value != *field {
+            *field = value
+            fields = append(fields, name)
+        }
     }
-    if ctimeNsec != "" {
-        asset.Ctime.Nsec = ctimeNsec
+    setTime := func(name string, bit uint32, field *Time, value Time) {
+        if valid&bit == 0 {
+            return
+        }
+
+        if value.Sec != field.Sec {
+            field.Sec = value.Sec
+            fields = append(fields, name+".sec")
+        }
+
+        set(name+".nsec", bit, &field.Nsec, value.Nsec)
     }
-    if mode != "" {
-        asset.Mode = mode
+
+    set("uid", validUid, &asset.Uid, update.Uid)
+    set("gid", validGid, &asset.Gid, update.Gid)
+    setTime("atime", validAtime, &asset.Atime, update.Atime)
+    setTime("mtime", validMtime, &asset.Mtime, update.Mtime)
+    setTime("ctime", validCtime, &asset.Ctime, update.Ctime)
+    set("mode", validMode, &asset.Mode, update.Mode)
+    set64("size", validSize, &asset.Size, update.Size)
+    set("nlink", validNlink, &asset.Nlink, update.Nlink)
+    set64("blocks", validBlocks, &asset.Blocks, update.Blocks)
+    setTime("crtime", validCrtime, &asset.Crtime, update.Crtime)
+    set("flags", validFlags, &asset.Flags, update.Flags)
+    set("projid", validProjid, &asset.Projid, update.Projid)
+
+    return fields
+}
+
+// putAsset stores asset in the current schema version under the
+// endorsement policy of its filesystem fs, and keeps the indexes of
+// index.go up to date. The index entries to replace are those of the
+// committed version of the asset, so an asset must be put at most once per
+// transaction.
+func putAsset(ctx contractapi.TransactionContextInterface, asset *Asset, fs *Filesystem) error {
+    old, err := getAsset(ctx, asset.FsUUID, asset.Ino, asset.Generation)
+    if err != nil {
+        return err
     }
 
+    asset.SchemaVersion = schemaVersion
     assetJSON, err := json.Marshal(asset)
     if err != nil {
         return err
     }
 
-    assetKey := fmt.Sprintf("asset_%s", ino)
-    return ctx.GetStub().PutState(assetKey, assetJSON)
+    key, err := assetKey(ctx, asset.FsUUID, asset.Ino, asset.Generation)
+    if err != nil {
+        return err
+    }
+
+    err = ctx.GetStub().PutState(key, assetJSON)
+    if err != nil {
+        return err
+    }
+
+    err = setEndorsementPolicy(ctx, key, fs)
+    if err != nil {
+        return err
+    }
+
+    return updateIndexes(ctx, old, asset, fs)
 }
 
-func (s *SmartContract) ReadAsset(ctx contractapi.TransactionContextInterface, ino string) (*Asset, error) {
-    assetKey := fmt.Sprintf("asset_%s", ino)
-    assetJSON, err := ctx.GetStub().GetState(assetKey)
-    
+// DeleteAsset replaces the asset with a tombstone recording the deletion
+// time and the deleting identity. A later CreateAsset for the same inode
+// number starts a fresh record on the same key.
+func (s *SmartContract) DeleteAsset(ctx contractapi.TransactionContextInterface, fsUUID string, ino uint64, generation uint32) error {
+    asset, err := getMutableAsset(ctx, fsUUID, ino, generation)
     if err != nil {
-        return nil, fmt.Errorf("failed to read asset: %v", err)
+        return err
     }
-    
-    if assetJSON == nil {
-        return nil, fmt.Errorf("asset %s does not exist", ino)
+
+    submitter, err := getSubmitter(ctx)
+    if err != nil {
+        return err
+    }
+
+    timestamp, err := ctx.GetStub().GetTxTimestamp()
+    if err != nil {
+        return fmt.Errorf("failed to get transaction timestamp: %v", err)
+    }
+
+    asset.Deleted = &Tombstone{
+        Time:      timestamp.AsTime().Format(time.RFC3339Nano),
+        Submitter: submitter,
     }
 
-    var asset Asset
-    err = json.Unmarshal(assetJSON, &asset)
+    fs, err := getFilesystem(ctx, fsUUID)
     if err != nil {
-        return nil, fmt.Errorf("failed to unmarshal asset: %v", err)
+        return err
+    }
+
+    err = putAsset(ctx, asset, fs)
+    if err != nil {
+        return err
+    }
+
+    return emitAssetEvent(ctx, eventDelete, []AssetChange{{FsUUID: fsUUID, Ino: ino, Generation: generation, Fields: []string{"deleted"}}})
+}
+
+func (s *SmartContract) ReadAsset(ctx contractapi.TransactionContextInterface, fsUUID string, ino uint64, generation uint32) (*Asset, error) {
+    asset, err := getAsset(ctx, fsUUID, ino, generation)
+    if err != nil {
+        return nil, err
+    }
+
+    if asset == nil || asset.Deleted != nil {
+        return nil, fmt.Errorf("asset %s/%d@%d does not exist", fsUUID, ino, generation)
     }
 
-    return &asset, nil
+    return asset, nil
 }
 
-func (s *SmartContract) AssetExists(ctx contractapi.TransactionContextInterface, ino string) (bool, error) {
-    assetKey := fmt.Sprintf("asset_%s", ino)
-    assetJSON, err := ctx.GetStub().GetState(assetKey)
+func (s *SmartContract) AssetExists(ctx contractapi.TransactionContextInterface, fsUUID string, ino uint64, generation uint32) (bool, error) {
+    asset, err := getAsset(ctx, fsUUID, ino, generation)
+    if err != nil {
+        return false, err
+    }
+
+    return asset != nil && asset.Deleted == nil, nil
+}
+
+// getAsset returns the stored record of the inode, tombstones included, or
+// nil if the key was never written.
+func getAsset(ctx contractapi.TransactionContextInterface, fsUUID string, ino uint64, generation uint32) (*Asset, error) {
+    key, err := assetKey(ctx, fsUUID, ino, generation)
+    if err != nil {
+        return nil, err
+    }
+
+    assetJSON, err := ctx.GetStub().GetState(key)
     
     if err != nil {
-        return false, fmt.Errorf("failed to read asset: %v", err)
+        return nil, fmt.Errorf("failed to read asset: %v", err)
     }
     
-    return assetJSON != nil, nil
+    if assetJSON == nil {
+        return nil, nil
+    }
+
+    return decodeAsset(assetJSON)
 }
 
 func main() {
@@ -0,0 +1,81 @@
+package main
+
+import (
+    "encoding/json"
+    "reflect"
+    "testing"
+)
+
+func TestMergeUpdates(t *testing.T) {
+    first := AssetUpdate{Asset: Asset{FsUUID: testUUID, Ino: 1, Generation: 1, Uid: 7, Mode: 0o100600}, Valid: validUid | validMode}
+    other := AssetUpdate{Asset: Asset{FsUUID: testUUID, Ino: 2, Generation: 1, Uid: 8}, Valid: validUid}
+    second := AssetUpdate{Asset: Asset{FsUUID: testUUID, Ino: 1, Generation: 1, Mode: 0o100755, Size: 10}, Valid: validMode | validSize}
+    reused := AssetUpdate{Asset: Asset{FsUUID: testUUID, Ino: 1, Generation: 2, Uid: 9}, Valid: validUid}
+
+    merged := mergeUpdates([]AssetUpdate{first, other, second, reused})
+
+    want := []AssetUpdate{
+        {Asset: Asset{FsUUID: testUUID, Ino: 1, Generation: 1, Uid: 7, Mode: 0o100755, Size: 10}, Valid: validUid | validMode | validSize},
+        other,
+        reused,
+    }
+    if !reflect.DeepEqual(merged, want) {
+        t.Errorf("merged = %+v\nwant %+v", merged, want)
+    }
+}
+
+func TestBatchUpdateAssetsAppliesEveryUpdateOfAnAsset(t *testing.T) {
+    stub := newMockStub()
+    createAssets(t, stub, alice, testAsset(1), testAsset(2))
+
+    updates := []AssetUpdate{
+        {Asset: Asset{FsUUID: testUUID, Ino: 1, Generation: 1, Uid: 7}, Valid: validUid},
+        {Asset: Asset{FsUUID: testUUID, Ino: 2, Generation: 1, Size: 4096}, Valid: validSize},
+        {Asset: Asset{FsUUID: testUUID, Ino: 3, Generation: 1, Uid: 7}, Valid: validUid},
+        {Asset: Asset{FsUUID: testUUID, Ino: 1, Generation: 1, Mode: 0o100600}, Valid: validMode},
+    }
+    missing, err := (&SmartContract{}).BatchUpdateAssets(newContext(stub, alice), updates)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if want := []AssetKey{{FsUUID: testUUID, Ino: 3, Generation: 1}}; !reflect.DeepEqual(missing, want) {
+        t.Errorf("missing = %v, want %v", missing, want)
+    }
+
+    var event AssetEvent
+    err = json.Unmarshal(stub.events[assetChangedEvent], &event)
+    if err != nil {
+        t.Fatalf("invalid event: %v", err)
+    }
+
+    wantChanges := []AssetChange{
+        {FsUUID: testUUID, Ino: 1, Generation: 1, Fields: []string{"uid", "mode"}},
+        {FsUUID: testUUID, Ino: 2, Generation: 1, Fields: []string{"size"}},
+    }
+    if !reflect.DeepEqual(event.Changes, wantChanges) {
+        t.Errorf("changes = %+v, want %+v", event.Changes, wantChanges)
+    }
+    stub.commit()
+
+    // Both updates of inode 1 are kept, although neither saw the other.
+    asset := readAsset(t, stub, 1)
+    if asset.Uid != 7 || asset.Mode != 0o100600 || asset.Gid != 1000 {
+        t.Errorf("asset 1 has uid %d, mode %o, gid %d; want 7, 100600 and the unchanged 1000", asset.Uid, asset.Mode, asset.Gid)
+    }
+
+    if asset := readAsset(t, stub, 2); asset.Size != 4096 {
+        t.Errorf("asset 2 has size %d, want 4096", asset.Size)
+    }
+}
+
+func TestBatchUpdateAssetsRejectsInvalidTimes(t *testing.T) {
+    stub := newMockStub()
+    createAssets(t, stub, alice, testAsset(1))
+
+    updates := []AssetUpdate{{Asset: Asset{FsUUID: testUUID, Ino: 1, Generation: 1, Mtime: Time{Nsec: 1e9}}, Valid: validMtime}}
+    _, err := (&SmartContract{}).BatchUpdateAssets(newContext(stub, alice), updates)
+    if err == nil {
+        t.Error("BatchUpdateAssets accepted one billion nanoseconds")
+    }
+}
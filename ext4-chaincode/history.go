@@ -0,0 +1,59 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+type AssetHistoryEntry struct {
+    TxID      string `json:"txId"`
+    Timestamp string `json:"timestamp"`
+    IsDelete  bool   `json:"isDelete"`
+    Asset     Asset  `json:"asset"`
+}
+
+// GetAssetHistory returns every version of the asset recorded by the ledger,
+// newest first. Entries with IsDelete set carry an empty Asset.
+func (s *SmartContract) GetAssetHistory(ctx contractapi.TransactionContextInterface, fsUUID string, ino uint64, generation uint32) ([]AssetHistoryEntry, error) {
+    key, err := assetKey(ctx, fsUUID, ino, generation)
+    if err != nil {
+        return nil, err
+    }
+
+    resultsIterator, err := ctx.GetStub().GetHistoryForKey(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read asset history: %v", err)
+    }
+    defer resultsIterator.Close()
+
+    history := []AssetHistoryEntry{}
+    for resultsIterator.HasNext() {
+        modification, err := resultsIterator.Next()
+        if err != nil {
+            return nil, fmt.Errorf("failed to read asset history: %v", err)
+        }
+
+        entry := AssetHistoryEntry{
+            TxID:      modification.GetTxId(),
+            Timestamp: modification.GetTimestamp().AsTime().Format(time.RFC3339Nano),
+            IsDelete:  modification.GetIsDelete(),
+        }
+
+        if !entry.IsDelete {
+            asset, err := decodeAsset(modification.GetValue())
+            if err != nil {
+                return nil, err
+            }
+            entry.Asset = *asset
+        }
+
+        history = append(history, entry)
+    }
+
+    if len(history) == 0 {
+        return nil, fmt.Errorf("asset %s/%d@%d does not exist", fsUUID, ino, generation)
+    }
+
+    return history, nil
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// EXT4_IMMUTABLE_FL from fs/ext4/ext4.h.
+const ext4ImmutableFl = 0x00000010
+
+// S_ISUID from include/uapi/linux/stat.h.
+const modeSetuid = 0o4000
+
+// Index entries are composite keys with an empty value. Their attributes
+// are the filesystem UUID, the indexed values and the attributes of the
+// asset key, so that the asset can be found again from the index key alone.
+// Composite-key indexes work on LevelDB as well as CouchDB.
+const (
+    immutableIndex = "immutable~fsUuid~ino~generation"
+    uidIndex       = "uid~fsUuid~uid~ino~generation"
+    setuidIndex    = "setuid~fsUuid~ino~generation"
+    // Modification times are indexed by UTC calendar day, so that a time
+    // range can be covered by whole years, months and days; see
+    // mtimePrefixes.
+    mtimeIndex = "mtime~fsUuid~year~month~day~second~nanosecond~ino~generation"
+)
+
+var indexes = []string{immutableIndex, uidIndex, setuidIndex, mtimeIndex}
+
+// The year in keys of mtimeIndex is offset to be positive and zero-padded,
+// so that the keys sort by time, years before 1 and after 9999 included.
+// The width covers every year a Time can hold.
+const (
+    mtimeYearOffset = 300000000000
+    mtimeYearWidth  = 12
+)
+
+// indexValue is stored under index keys, as PutState rejects empty values.
+var indexValue = []byte{0x00}
+
+// maxIndexPrefixes bounds the partial keys a single query may cover.
+const maxIndexPrefixes = 4096
+
+// indexAttributes returns the attributes under which index lists asset, or
+// nil if it does not. Tombstones are in no index, and neither are uid and
+// mode kept in a private data collection.
+func indexAttributes(index string, asset *Asset) []string {
+    if asset == nil || asset.Deleted != nil {
+        return nil
+    }
+
+    key := []string{formatUint(asset.Ino), formatUint(uint64(asset.Generation))}
+    switch index {
+    case immutableIndex:
+        if asset.Flags&ext4ImmutableFl != 0 {
+            return append([]string{asset.FsUUID}, key...)
+        }
+    case uidIndex:
+        if asset.Private == nil {
+            return append([]string{asset.FsUUID, formatUint(uint64(asset.Uid))}, key...)
+        }
+    case setuidIndex:
+        if asset.Private == nil && asset.Mode&modeSetuid != 0 {
+            return append([]string{asset.FsUUID}, key...)
+        }
+    case mtimeIndex:
+        return append(append([]string{asset.FsUUID}, mtimeAttributes(asset.Mtime, asset.SchemaVersion)...), key...)
+    }
+
+    return nil
+}
+
+// mtimeAttributes returns the attributes of mtimeIndex for mtime. Records
+// of schema version 2 were indexed with the year as is.
+func mtimeAttributes(mtime Time, version int) []string {
+    t := time.Unix(mtime.Sec, int64(mtime.Nsec)).UTC()
+    year := formatMtimeYear(t.Year())
+    if version < 3 {
+        year = strconv.Itoa(t.Year())
+    }
+
+    return []string{
+        year,
+        fmt.Sprintf("%02d", t.Month()),
+        fmt.Sprintf("%02d", t.Day()),
+        fmt.Sprintf("%05d", t.Hour()*3600+t.Minute()*60+t.Second()),
+        fmt.Sprintf("%09d", t.Nanosecond()),
+    }
+}
+
+func formatMtimeYear(year int) string {
+    return fmt.Sprintf("%0*d", mtimeYearWidth, int64(year)+mtimeYearOffset)
+}
+
+// updateIndexes moves asset from the index entries of its previous
+// version, old, to those of its current state. old is nil for a new asset.
+// Records of an older schema version may be missing from some indexes, so
+// all of their entries are written. New entries get the endorsement policy
+// of the filesystem fs.
+func updateIndexes(ctx contractapi.TransactionContextInterface, old, asset *Asset, fs *Filesystem) error {
+    reindex := old != nil && old.SchemaVersion != schemaVersion
+    for _, index := range indexes {
+        before := indexAttributes(index, old)
+        after := indexAttributes(index, asset)
+        if !reindex && strings.Join(before, "\x00") == strings.Join(after, "\x00") {
+            continue
+        }
+
+        if before != nil {
+            err := setIndex(ctx, index, before, false, fs)
+            if err != nil {
+                return err
+            }
+        }
+
+        if after != nil {
+            err := setIndex(ctx, index, after, true, fs)
+            if err != nil {
+                return err
+            }
+        }
+    }
+
+    return nil
+}
+
+func setIndex(ctx contractapi.TransactionContextInterface, index string, attributes []string, present bool, fs *Filesystem) error {
+    key, err := ctx.GetStub().CreateCompositeKey(index, attributes)
+    if err != nil {
+        return err
+    }
+
+    if !present {
+        err = ctx.GetStub().DelState(key)
+        if err != nil {
+            return fmt.Errorf("failed to update index %s: %v", index, err)
+        }
+
+        return nil
+    }
+
+    err = ctx.GetStub().PutState(key, indexValue)
+    if err != nil {
+        return fmt.Errorf("failed to update index %s: %v", index, err)
+    }
+
+    return setEndorsementPolicy(ctx, key, fs)
+}
+
+// indexedAssets returns the assets an index lists under the given leading
+// attributes.
+func indexedAssets(ctx contractapi.TransactionContextInterface, index string, attributes []string) ([]Asset, error) {
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, attributes)
+    if err != nil {
+        return nil, fmt.Errorf("failed to query index %s: %v", index, err)
+    }
+    defer resultsIterator.Close()
+
+    assets := []Asset{}
+    for resultsIterator.HasNext() {
+        result, err := resultsIterator.Next()
+        if err != nil {
+            return nil, fmt.Errorf("failed to read query result: %v", err)
+        }
+
+        _, keyParts, err := ctx.GetStub().SplitCompositeKey(result.GetKey())
+        if err != nil {
+            return nil, err
+        }
+
+        asset, err := indexedAsset(ctx, index, result.GetKey(), keyParts)
+        if err != nil {
+            return nil, err
+        }
+
+        assets = append(assets, *asset)
+    }
+
+    return assets, nil
+}
+
+// indexedAssetPage returns a page of the assets an index lists under each
+// of prefixes in turn, leaving out those match rejects. match may be nil.
+// The bookmark is opaque to callers: it is the position in prefixes
+// followed by the bookmark of the query for that prefix. A page with
+// FetchedCount below pageSize is the last one.
+func indexedAssetPage(ctx contractapi.TransactionContextInterface, index string, prefixes [][]string, match func(keyParts []string) (bool, error), pageSize int32, bookmark string) (*AssetPage, error) {
+    if pageSize <= 0 {
+        return nil, fmt.Errorf("invalid page size %d", pageSize)
+    }
+
+    segment := 0
+    inner := ""
+    if bookmark != "" {
+        position, rest, ok := strings.Cut(bookmark, ":")
+        n, err := strconv.Atoi(position)
+        if !ok || err != nil || n < 0 || n > len(prefixes) {
+            return nil, fmt.Errorf("invalid bookmark %q", bookmark)
+        }
+
+        segment = n
+        inner = rest
+    }
+
+    assets := []Asset{}
+    for int32(len(assets)) < pageSize && segment < len(prefixes) {
+        requested := pageSize - int32(len(assets))
+        resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(index, prefixes[segment], requested, inner)
+        if err != nil {
+            return nil, fmt.Errorf("failed to query index %s: %v", index, err)
+        }
+
+        for resultsIterator.HasNext() {
+            result, err := resultsIterator.Next()
+            if err != nil {
+                resultsIterator.Close()
+                return nil, fmt.Errorf("failed to read query result: %v", err)
+            }
+
+            _, keyParts, err := ctx.GetStub().SplitCompositeKey(result.GetKey())
+            if err != nil {
+                resultsIterator.Close()
+                return nil, err
+            }
+
+            if match != nil {
+                ok, err := match(keyParts)
+                if err != nil {
+                    resultsIterator.Close()
+                    return nil, err
+                }
+
+                if !ok {
+                    continue
+                }
+            }
+
+            asset, err := indexedAsset(ctx, index, result.GetKey(), keyParts)
+            if err != nil {
+                resultsIterator.Close()
+                return nil, err
+            }
+
+            assets = append(assets, *asset)
+        }
+        resultsIterator.Close()
+
+        // The bookmark is empty when the results of a prefix end
+        // exactly at the page size; restarting from it would list them
+        // again.
+        if metadata.GetFetchedRecordsCount() < requested || metadata.GetBookmark() == "" {
+            segment++
+            inner = ""
+        } else {
+            inner = metadata.GetBookmark()
+        }
+    }
+
+    next := ""
+    if segment < len(prefixes) {
+        next = strconv.Itoa(segment) + ":" + inner
+    }
+
+    return &AssetPage{
+        Assets:       assets,
+        FetchedCount: int32(len(assets)),
+        Bookmark:     next,
+    }, nil
+}
+
+// indexedAsset returns the asset an index key refers to: the filesystem
+// UUID leads the key, and the inode number and generation end it.
+func indexedAsset(ctx contractapi.TransactionContextInterface, index, key string, keyParts []string) (*Asset, error) {
+    n := len(keyParts)
+    if n < 3 {
+        return nil, fmt.Errorf("invalid key %q in index %s", key, index)
+    }
+
+    ino, err := strconv.ParseUint(keyParts[n-2], 10, 64)
+    if err != nil {
+        return nil, fmt.Errorf("invalid key %q in index %s", key, index)
+    }
+
+    generation, err := strconv.ParseUint(keyParts[n-1], 10, 32)
+    if err != nil {
+        return nil, fmt.Errorf("invalid key %q in index %s", key, index)
+    }
+
+    asset, err := getAsset(ctx, keyParts[0], ino, uint32(generation))
+    if err != nil {
+        return nil, err
+    }
+
+    if asset == nil {
+        return nil, fmt.Errorf("index %s lists missing asset %q", index, key)
+    }
+
+    return asset, nil
+}
+
+// mtimePrefixes covers the UTC days from the one of from to the one of the
+// last instant before to with as few partial keys of mtimeIndex as
+// possible: whole years, then whole months, then single days.
+func mtimePrefixes(fsUUID string, from, to time.Time) ([][]string, error) {
+    last := to.Add(-time.Nanosecond).UTC()
+    end := time.Date(last.Year(), last.Month(), last.Day(), 0, 0, 0, 0, time.UTC)
+    from = from.UTC()
+    day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+
+    var prefixes [][]string
+    for !day.After(end) {
+        if len(prefixes) == maxIndexPrefixes {
+            return nil, fmt.Errorf("time range too large")
+        }
+
+        year := formatMtimeYear(day.Year())
+        month := fmt.Sprintf("%02d", day.Month())
+        switch {
+        case day.YearDay() == 1 && !day.AddDate(1, 0, -1).After(end):
+            prefixes = append(prefixes, []string{fsUUID, year})
+            day = day.AddDate(1, 0, 0)
+        case day.Day() == 1 && !day.AddDate(0, 1, -1).After(end):
+            prefixes = append(prefixes, []string{fsUUID, year, month})
+            day = day.AddDate(0, 1, 0)
+        default:
+            prefixes = append(prefixes, []string{fsUUID, year, month, fmt.Sprintf("%02d", day.Day())})
+            day = day.AddDate(0, 0, 1)
+        }
+    }
+
+    return prefixes, nil
+}
+
+// mtimeOf returns the modification time a key of mtimeIndex records.
+func mtimeOf(keyParts []string) (time.Time, error) {
+    if len(keyParts) < 6 {
+        return time.Time{}, fmt.Errorf("invalid key in index %s", mtimeIndex)
+    }
+
+    var values [5]int
+    for i := range values {
+        n, err := strconv.Atoi(keyParts[i+1])
+        if err != nil {
+            return time.Time{}, fmt.Errorf("invalid key in index %s", mtimeIndex)
+        }
+        values[i] = n
+    }
+    values[0] -= mtimeYearOffset
+
+    return time.Date(values[0], time.Month(values[1]), values[2], 0, 0, values[3], values[4], time.UTC), nil
+}
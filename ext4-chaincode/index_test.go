@@ -0,0 +1,216 @@
+package main
+
+import (
+    "reflect"
+    "sort"
+    "testing"
+    "time"
+)
+
+func TestMtimeKeysSortByTime(t *testing.T) {
+    times := []time.Time{
+        time.Date(-2000, time.June, 1, 0, 0, 0, 0, time.UTC),
+        time.Date(-5, time.January, 1, 0, 0, 0, 0, time.UTC),
+        time.Date(1, time.January, 1, 0, 0, 0, 0, time.UTC),
+        time.Date(999, time.December, 31, 23, 59, 59, 0, time.UTC),
+        time.Date(1969, time.December, 31, 23, 59, 59, 999999999, time.UTC),
+        time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC),
+        time.Date(2024, time.March, 1, 12, 0, 0, 1, time.UTC),
+        time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC),
+        time.Date(10000, time.January, 1, 0, 0, 0, 0, time.UTC),
+    }
+
+    stub := newMockStub()
+    var keys []string
+    for _, mtime := range times {
+        attributes := mtimeAttributes(Time{Sec: mtime.Unix(), Nsec: uint32(mtime.Nanosecond())}, schemaVersion)
+        key, err := stub.CreateCompositeKey(mtimeIndex, append(append([]string{testUUID}, attributes...), "1", "1"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        keys = append(keys, key)
+
+        _, keyParts, _ := stub.SplitCompositeKey(key)
+        got, err := mtimeOf(keyParts)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        if !got.Equal(mtime) {
+            t.Errorf("mtimeOf(%q) = %v, want %v", keyParts, got, mtime)
+        }
+    }
+
+    if !sort.StringsAreSorted(keys) {
+        t.Errorf("keys do not sort by time: %q", keys)
+    }
+}
+
+func TestMtimePrefixes(t *testing.T) {
+    from := time.Date(2023, time.December, 30, 15, 0, 0, 0, time.UTC)
+    to := time.Date(2025, time.March, 3, 0, 0, 0, 0, time.UTC)
+    prefixes, err := mtimePrefixes(testUUID, from, to)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    y2023, y2024, y2025 := formatMtimeYear(2023), formatMtimeYear(2024), formatMtimeYear(2025)
+    want := [][]string{
+        {testUUID, y2023, "12", "30"},
+        {testUUID, y2023, "12", "31"},
+        {testUUID, y2024},
+        {testUUID, y2025, "01"},
+        {testUUID, y2025, "02"},
+        {testUUID, y2025, "03", "01"},
+        {testUUID, y2025, "03", "02"},
+    }
+    if !reflect.DeepEqual(prefixes, want) {
+        t.Errorf("prefixes = %q\nwant %q", prefixes, want)
+    }
+
+    _, err = mtimePrefixes(testUUID, time.Unix(0, 0), time.Date(20000, time.January, 2, 0, 0, 0, 0, time.UTC))
+    if err == nil {
+        t.Error("mtimePrefixes accepted a range of more than maxIndexPrefixes prefixes")
+    }
+}
+
+// inos returns the inode numbers of every page of a query, following the
+// bookmarks.
+func inos(t *testing.T, query func(bookmark string) (*AssetPage, error)) []uint64 {
+    t.Helper()
+    var inos []uint64
+    bookmark := ""
+    for {
+        page, err := query(bookmark)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        for _, asset := range page.Assets {
+            inos = append(inos, asset.Ino)
+        }
+
+        if page.Bookmark == "" {
+            return inos
+        }
+        bookmark = page.Bookmark
+    }
+}
+
+func TestListAssetsModifiedBetween(t *testing.T) {
+    mtimes := map[uint64]time.Time{
+        1: time.Date(1960, time.May, 1, 0, 0, 0, 0, time.UTC),
+        2: time.Date(2024, time.January, 1, 8, 0, 0, 0, time.UTC),
+        3: time.Date(2023, time.December, 30, 14, 59, 59, 0, time.UTC),
+        4: time.Date(2023, time.December, 30, 15, 0, 0, 0, time.UTC),
+        5: time.Date(2025, time.March, 2, 23, 59, 59, 0, time.UTC),
+        6: time.Date(2025, time.March, 3, 0, 0, 0, 0, time.UTC),
+        7: time.Date(2024, time.July, 4, 0, 0, 0, 0, time.UTC),
+    }
+
+    var assets []Asset
+    for ino := uint64(1); ino <= 7; ino++ {
+        asset := testAsset(ino)
+        asset.Mtime = Time{Sec: mtimes[ino].Unix()}
+        assets = append(assets, asset)
+    }
+
+    stub := newMockStub()
+    createAssets(t, stub, alice, assets...)
+    ctx := newContext(stub, alice)
+    s := &SmartContract{}
+
+    from := time.Date(2023, time.December, 30, 15, 0, 0, 0, time.UTC)
+    to := time.Date(2025, time.March, 3, 0, 0, 0, 0, time.UTC)
+    got := inos(t, func(bookmark string) (*AssetPage, error) {
+        return s.ListAssetsModifiedBetween(ctx, testUUID, from.Unix(), to.Unix(), 2, bookmark)
+    })
+    if want := []uint64{4, 2, 7, 5}; !reflect.DeepEqual(got, want) {
+        t.Errorf("modified between %v and %v: %v, want %v", from, to, got, want)
+    }
+
+    got = inos(t, func(bookmark string) (*AssetPage, error) {
+        return s.ListAssetsModifiedBetween(ctx, testUUID, time.Date(1950, time.January, 1, 0, 0, 0, 0, time.UTC).Unix(), 0, 10, bookmark)
+    })
+    if want := []uint64{1}; !reflect.DeepEqual(got, want) {
+        t.Errorf("modified before 1970: %v, want %v", got, want)
+    }
+
+    _, err := s.ListAssetsModifiedBetween(ctx, testUUID, to.Unix(), from.Unix(), 10, "")
+    if err == nil {
+        t.Error("ListAssetsModifiedBetween accepted an empty range")
+    }
+
+    _, err = s.ListAssetsModifiedBetween(ctx, testUUID, from.Unix(), to.Unix(), 10, "x")
+    if err == nil {
+        t.Error("ListAssetsModifiedBetween accepted an invalid bookmark")
+    }
+}
+
+func TestUpdatesMoveIndexEntries(t *testing.T) {
+    stub := newMockStub()
+    createAssets(t, stub, alice, testAsset(1), testAsset(2))
+    ctx := newContext(stub, alice)
+    s := &SmartContract{}
+
+    byUid := func(uid uint32) []uint64 {
+        return inos(t, func(bookmark string) (*AssetPage, error) {
+            return s.ListAssetsByUid(ctx, testUUID, uid, 10, bookmark)
+        })
+    }
+    setuid := func() []uint64 {
+        return inos(t, func(bookmark string) (*AssetPage, error) {
+            return s.ListSetuidAssets(ctx, testUUID, 10, bookmark)
+        })
+    }
+
+    if got := byUid(1000); !reflect.DeepEqual(got, []uint64{1, 2}) {
+        t.Errorf("uid 1000: %v, want [1 2]", got)
+    }
+
+    updates := []AssetUpdate{
+        {Asset: Asset{FsUUID: testUUID, Ino: 1, Generation: 1, Uid: 0, Mode: 0o104755}, Valid: validUid | validMode},
+        {Asset: Asset{FsUUID: testUUID, Ino: 2, Generation: 1, Flags: ext4ImmutableFl}, Valid: validFlags},
+    }
+    _, err := s.BatchUpdateAssets(ctx, updates)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stub.commit()
+
+    if got := byUid(1000); !reflect.DeepEqual(got, []uint64{2}) {
+        t.Errorf("uid 1000: %v, want [2]", got)
+    }
+
+    if got := byUid(0); !reflect.DeepEqual(got, []uint64{1}) {
+        t.Errorf("uid 0: %v, want [1]", got)
+    }
+
+    if got := setuid(); !reflect.DeepEqual(got, []uint64{1}) {
+        t.Errorf("setuid: %v, want [1]", got)
+    }
+
+    immutable, err := s.ListImmutableAssets(ctx, testUUID)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if len(immutable) != 1 || immutable[0].Ino != 2 {
+        t.Errorf("immutable: %+v, want inode 2", immutable)
+    }
+
+    // Tombstones are in no index.
+    err = s.DeleteAsset(ctx, testUUID, 1, 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stub.commit()
+
+    if got := byUid(0); len(got) != 0 {
+        t.Errorf("uid 0 after the delete: %v", got)
+    }
+
+    if got := setuid(); len(got) != 0 {
+        t.Errorf("setuid after the delete: %v", got)
+    }
+}
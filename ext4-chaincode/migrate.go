@@ -0,0 +1,223 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// legacyAssetPrefix starts the plain keys, asset_<ino>, under which the
+// first version of the chaincode stored assets. The range query for them
+// ends before legacyAssetEnd.
+const (
+    legacyAssetPrefix = "asset_"
+    legacyAssetEnd    = "asset`"
+)
+
+// MigrationPage is one page of ListMigrations: the keys of the records to
+// pass to MigrateAssets. Pass Bookmark to the next call until Done is set.
+type MigrationPage struct {
+    Scanned  int32    `json:"scanned"`
+    Keys     []string `json:"keys"`
+    Bookmark string   `json:"bookmark"`
+    Done     bool     `json:"done"`
+}
+
+// ListMigrations scans up to pageSize records after bookmark and returns
+// the keys of those stored in an older schema version or under a legacy
+// key. Paginated queries are not available to transactions that write, so
+// a migration evaluates ListMigrations and submits MigrateAssets with the
+// keys it returned, page by page. The bookmark is opaque to callers: the
+// legacy keys are scanned first, then the assets. Only admins may migrate.
+func (s *SmartContract) ListMigrations(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*MigrationPage, error) {
+    err := requireAdmin(ctx, "ListMigrations")
+    if err != nil {
+        return nil, err
+    }
+
+    if pageSize <= 0 {
+        return nil, fmt.Errorf("invalid page size %d", pageSize)
+    }
+
+    const segments = 2
+    segment := 0
+    inner := ""
+    if bookmark != "" {
+        position, rest, ok := strings.Cut(bookmark, ":")
+        n, err := strconv.Atoi(position)
+        if !ok || err != nil || n < 0 || n > segments {
+            return nil, fmt.Errorf("invalid bookmark %q", bookmark)
+        }
+
+        segment = n
+        inner = rest
+    }
+
+    stub := ctx.GetStub()
+    page := &MigrationPage{Keys: []string{}}
+    for page.Scanned < pageSize && segment < segments {
+        requested := pageSize - page.Scanned
+        var fetched int32
+        var next string
+        if segment == 0 {
+            resultsIterator, metadata, err := stub.GetStateByRangeWithPagination(legacyAssetPrefix, legacyAssetEnd, requested, inner)
+            if err != nil {
+                return nil, fmt.Errorf("failed to list legacy assets: %v", err)
+            }
+
+            for resultsIterator.HasNext() {
+                result, err := resultsIterator.Next()
+                if err != nil {
+                    resultsIterator.Close()
+                    return nil, fmt.Errorf("failed to read query result: %v", err)
+                }
+
+                page.Scanned++
+                page.Keys = append(page.Keys, result.GetKey())
+            }
+            resultsIterator.Close()
+            fetched, next = metadata.GetFetchedRecordsCount(), metadata.GetBookmark()
+        } else {
+            resultsIterator, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(assetObjectType, []string{}, requested, inner)
+            if err != nil {
+                return nil, fmt.Errorf("failed to list assets: %v", err)
+            }
+
+            for resultsIterator.HasNext() {
+                result, err := resultsIterator.Next()
+                if err != nil {
+                    resultsIterator.Close()
+                    return nil, fmt.Errorf("failed to read query result: %v", err)
+                }
+
+                page.Scanned++
+                asset, err := decodeAsset(result.GetValue())
+                if err != nil {
+                    resultsIterator.Close()
+                    return nil, err
+                }
+
+                if asset.SchemaVersion != schemaVersion {
+                    page.Keys = append(page.Keys, result.GetKey())
+                }
+            }
+            resultsIterator.Close()
+            fetched, next = metadata.GetFetchedRecordsCount(), metadata.GetBookmark()
+        }
+
+        // As in indexedAssetPage, an empty bookmark ends the segment.
+        if fetched < requested || next == "" {
+            segment++
+            inner = ""
+        } else {
+            inner = next
+        }
+    }
+
+    if segment < segments {
+        page.Bookmark = strconv.Itoa(segment) + ":" + inner
+    } else {
+        page.Done = true
+    }
+
+    return page, nil
+}
+
+// legacyGeneration is the generation given to records under legacy keys.
+// The first version of the chaincode recorded neither the filesystem nor
+// the generation of an inode, and the generation cannot be recovered from
+// the ledger; enrolling the filesystem records its live inodes under their
+// actual generations next to the migrated history.
+const legacyGeneration = 0
+
+// MigrateAssets rewrites the records at keys, as returned by ListMigrations,
+// in the current schema version and returns how many it rewrote. Records
+// migrated since they were listed are skipped. A record under a legacy key
+// moves to the key of filesystem fsUUID, its inode number and
+// legacyGeneration, unless a record is there already, which is newer.
+// fsUUID may be empty when keys holds no legacy key; the ledger records a
+// single filesystem under legacy keys. Only admins may migrate.
+func (s *SmartContract) MigrateAssets(ctx contractapi.TransactionContextInterface, fsUUID string, keys []string) (int32, error) {
+    err := requireAdmin(ctx, "MigrateAssets")
+    if err != nil {
+        return 0, err
+    }
+
+    // SplitCompositeKey panics on keys that are not composite, so keys
+    // are checked against the prefix of asset keys instead.
+    assetPrefix, err := ctx.GetStub().CreateCompositeKey(assetObjectType, []string{})
+    if err != nil {
+        return 0, err
+    }
+
+    var migrated int32
+    filesystems := make(map[string]*Filesystem)
+    for _, key := range keys {
+        legacy := strings.HasPrefix(key, legacyAssetPrefix)
+        if !legacy && !strings.HasPrefix(key, assetPrefix) {
+            return 0, fmt.Errorf("%q is not the key of an asset", key)
+        }
+
+        assetJSON, err := ctx.GetStub().GetState(key)
+        if err != nil {
+            return 0, fmt.Errorf("failed to read asset: %v", err)
+        }
+
+        if assetJSON == nil {
+            continue
+        }
+
+        asset, err := decodeAsset(assetJSON)
+        if err != nil {
+            return 0, err
+        }
+
+        if legacy {
+            if fsUUID == "" {
+                return 0, fmt.Errorf("missing filesystem UUID for legacy asset %q", key)
+            }
+
+            asset.FsUUID = fsUUID
+            asset.Generation = legacyGeneration
+        }
+
+        currentKey, err := assetKey(ctx, asset.FsUUID, asset.Ino, asset.Generation)
+        if err != nil {
+            return 0, fmt.Errorf("asset at %q: %v", key, err)
+        }
+
+        if legacy {
+            current, err := getAsset(ctx, asset.FsUUID, asset.Ino, asset.Generation)
+            if err != nil {
+                return 0, err
+            }
+
+            err = ctx.GetStub().DelState(key)
+            if err != nil {
+                return 0, fmt.Errorf("failed to delete legacy asset %s: %v", key, err)
+            }
+
+            if current != nil {
+                continue
+            }
+        } else if currentKey != key {
+            return 0, fmt.Errorf("asset at %q is stored under the wrong key", key)
+        } else if asset.SchemaVersion == schemaVersion {
+            continue
+        }
+
+        fs, err := lookupFilesystem(ctx, filesystems, asset.FsUUID)
+        if err != nil {
+            return 0, err
+        }
+
+        err = putAsset(ctx, asset, fs)
+        if err != nil {
+            return 0, err
+        }
+        migrated++
+    }
+
+    return migrated, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+    "encoding/json"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func putRecord(t *testing.T, stub *mockStub, key string, record any) {
+    t.Helper()
+    recordJSON, err := json.Marshal(record)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stub.state[key] = recordJSON
+}
+
+// baselineRecord is a record as the first version of the chaincode wrote
+// it under asset_<ino>.
+func baselineRecord(ino, uid, mode string) []byte {
+    return []byte(`{"uid":"` + uid + `","gid":"0","atime":{"sec":"1700000000","nsec":"5"},"mtime":{"sec":"1700000001","nsec":"0"},"ctime":{"sec":"1700000002","nsec":"0"},"mode":"` + mode + `","ino":"` + ino + `"}`)
+}
+
+func TestMigrateAssets(t *testing.T) {
+    stub := newMockStub()
+    recreated := testAsset(1)
+    recreated.Generation = legacyGeneration
+    createAssets(t, stub, alice, recreated)
+    ctx := newContext(stub, admin)
+    s := &SmartContract{}
+
+    // Two records under legacy keys, one of them recreated since, a
+    // version 0 record under a composite key and a version 2 record.
+    stub.state["asset_1"] = baselineRecord("1", "5", "33188")
+    stub.state["asset_2"] = baselineRecord("2", "0", "33261")
+    key3, err := assetKey(ctx, testUUID, 3, 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    old := testAsset(3)
+    old.SchemaVersion = 2
+    putRecord(t, stub, key3, old)
+    key4, err := assetKey(ctx, testUUID, 4, 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    putRecord(t, stub, key4, assetV0{FsUUID: testUUID, Ino: "4", Generation: "1", Uid: "5", Mode: "16877"})
+
+    // A page size of one makes every segment end exactly at a page.
+    var keys []string
+    bookmark := ""
+    for pages := 0; ; pages++ {
+        if pages > 10 {
+            t.Fatalf("ListMigrations did not finish, listed %q", keys)
+        }
+
+        page, err := s.ListMigrations(ctx, 1, bookmark)
+        if err != nil {
+            t.Fatal(err)
+        }
+        keys = append(keys, page.Keys...)
+        if page.Done {
+            break
+        }
+        bookmark = page.Bookmark
+    }
+
+    if want := []string{"asset_1", "asset_2", key3, key4}; !reflect.DeepEqual(keys, want) {
+        t.Fatalf("keys = %q, want %q", keys, want)
+    }
+
+    _, err = s.MigrateAssets(ctx, testUUID, []string{"filesystem"})
+    if err == nil {
+        t.Error("MigrateAssets accepted a key that is not an asset's")
+    }
+
+    _, err = s.MigrateAssets(ctx, "", keys)
+    if err == nil || !strings.Contains(err.Error(), "missing filesystem UUID") {
+        t.Errorf("MigrateAssets of legacy keys without a filesystem: %v", err)
+    }
+
+    migrated, err := s.MigrateAssets(ctx, testUUID, keys)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if migrated != 3 {
+        t.Errorf("migrated %d assets, want 3", migrated)
+    }
+    stub.commit()
+
+    for _, key := range []string{"asset_1", "asset_2"} {
+        if _, ok := stub.state[key]; ok {
+            t.Errorf("legacy key %s was kept", key)
+        }
+    }
+
+    asset, err := getAsset(ctx, testUUID, 1, legacyGeneration)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if asset.Uid != 1000 {
+        t.Errorf("asset 1 has uid %d; the legacy record replaced the newer one", asset.Uid)
+    }
+
+    asset, err = getAsset(ctx, testUUID, 2, legacyGeneration)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    want := &Asset{
+        SchemaVersion: schemaVersion,
+        FsUUID:        testUUID,
+        Ino:           2,
+        Generation:    legacyGeneration,
+        Mode:          0o100755,
+        Atime:         Time{Sec: 1700000000, Nsec: 5},
+        Mtime:         Time{Sec: 1700000001},
+        Ctime:         Time{Sec: 1700000002},
+    }
+    if !reflect.DeepEqual(asset, want) {
+        t.Errorf("legacy asset 2 migrated to %+v, want %+v", asset, want)
+    }
+
+    for _, ino := range []uint64{3, 4} {
+        if asset := readAsset(t, stub, ino); asset.SchemaVersion != schemaVersion {
+            t.Errorf("asset %d has schema version %d", ino, asset.SchemaVersion)
+        }
+    }
+
+    // The migrated records are in the indexes.
+    got := inos(t, func(bookmark string) (*AssetPage, error) {
+        return s.ListAssetsByUid(ctx, testUUID, 0, 10, bookmark)
+    })
+    if want := []uint64{2}; !reflect.DeepEqual(got, want) {
+        t.Errorf("uid 0: %v, want %v", got, want)
+    }
+
+    got = inos(t, func(bookmark string) (*AssetPage, error) {
+        return s.ListAssetsByUid(ctx, testUUID, 5, 10, bookmark)
+    })
+    if want := []uint64{4}; !reflect.DeepEqual(got, want) {
+        t.Errorf("uid 5: %v, want %v", got, want)
+    }
+
+    page, err := s.ListMigrations(ctx, 10, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if len(page.Keys) != 0 || !page.Done {
+        t.Errorf("after the migration: %+v", page)
+    }
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "os"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// In private mode the daemon keeps uid, gid and mode out of public state.
+// It passes zeros in the transaction arguments and the real values in the
+// transient map under ownershipTransientKey, as a JSON array of Ownership.
+// The chaincode stores them in a private data collection under the asset
+// key, and the public asset only records the collection and the SHA-256
+// hash of the private record. uid, gid and mode take few values, so every
+// record carries a random salt chosen by the daemon, which keeps the hash
+// from being reversed by trying them all. The salt travels in the transient
+// map as well, so that every endorser writes the same record.
+const ownershipTransientKey = "ownership"
+
+// minOwnershipSaltSize is the minimum size of the salt of a private record.
+const minOwnershipSaltSize = 16
+
+// privateCollection is the private data collection holding ownership
+// records, read from the EXT4_PRIVATE_COLLECTION environment variable of
+// the chaincode process. It must be defined in the collection config of
+// the chaincode definition.
+var privateCollection = privateCollectionName()
+
+func privateCollectionName() string {
+    if name := os.Getenv("EXT4_PRIVATE_COLLECTION"); name != "" {
+        return name
+    }
+
+    return "ext4Ownership"
+}
+
+const validOwnership = validMode | validUid | validGid
+
+// Ownership is the private record of an asset.
+type Ownership struct {
+    FsUUID     string `json:"fsUuid"`
+    Ino        uint64 `json:"ino"`
+    Generation uint32 `json:"generation"`
+    Uid        uint32 `json:"uid"`
+    Gid        uint32 `json:"gid"`
+    Mode       uint32 `json:"mode"`
+    // Salt is random data making the hash of the record unguessable.
+    // Records written before salts were introduced have none.
+    Salt []byte `json:"salt,omitempty" metadata:",optional"`
+}
+
+// PrivateOwnership locates the private record of an asset.
+type PrivateOwnership struct {
+    Collection string `json:"collection"`
+    // Hash is the hex-encoded SHA-256 hash of the private record.
+    Hash string `json:"hash"`
+}
+
+// transientOwnership returns the ownership records passed in the transient
+// map, or nil if the transaction is not in private mode.
+func transientOwnership(ctx contractapi.TransactionContextInterface) (map[AssetKey]Ownership, error) {
+    transient, err := ctx.GetStub().GetTransient()
+    if err != nil {
+        return nil, fmt.Errorf("failed to read transient data: %v", err)
+    }
+
+    ownershipJSON, ok := transient[ownershipTransientKey]
+    if !ok {
+        return nil, nil
+    }
+
+    var records []Ownership
+    err = json.Unmarshal(ownershipJSON, &records)
+    if err != nil {
+        return nil, fmt.Errorf("invalid transient %s: %v", ownershipTransientKey, err)
+    }
+
+    ownership := make(map[AssetKey]Ownership, len(records))
+    for _, record := range records {
+        ownership[AssetKey{FsUUID: record.FsUUID, Ino: record.Ino, Generation: record.Generation}] = record
+    }
+
+    return ownership, nil
+}
+
+// applyOwnership applies the uid, gid and mode passed for asset in the
+// transient map to its private record, which gets the endorsement policy
+// of the filesystem fs, clearing them in asset. It returns
+// the validity mask left for applyUpdate and the changed fields. Without a
+// transient record the update is left to applyUpdate, which is refused for
+// ownership already kept private.
+func applyOwnership(ctx contractapi.TransactionContextInterface, asset *Asset, ownership map[AssetKey]Ownership, valid uint32, fs *Filesystem) (uint32, []string, error) {
+    update, ok := ownership[AssetKey{FsUUID: asset.FsUUID, Ino: asset.Ino, Generation: asset.Generation}]
+    if !ok {
+        if asset.Private != nil && valid&validOwnership != 0 {
+            return 0, nil, fmt.Errorf("the ownership of asset %s/%d@%d is private", asset.FsUUID, asset.Ino, asset.Generation)
+        }
+
+        return valid, nil, nil
+    }
+
+    if asset.Private != nil && valid&validOwnership == 0 {
+        return valid, nil, nil
+    }
+
+    if len(update.Salt) < minOwnershipSaltSize {
+        return 0, nil, fmt.Errorf("the ownership of asset %s/%d@%d needs a salt of at least %d bytes", asset.FsUUID, asset.Ino, asset.Generation, minOwnershipSaltSize)
+    }
+
+    key, err := assetKey(ctx, asset.FsUUID, asset.Ino, asset.Generation)
+    if err != nil {
+        return 0, nil, err
+    }
+
+    // A partial update is merged into the current values, which are
+    // public until the first private update.
+    current := Ownership{
+        FsUUID:     asset.FsUUID,
+        Ino:        asset.Ino,
+        Generation: asset.Generation,
+        Uid:        asset.Uid,
+        Gid:        asset.Gid,
+        Mode:       asset.Mode,
+    }
+    if asset.Private != nil && valid&validOwnership != validOwnership {
+        record, err := getOwnership(ctx, key, asset.Private)
+        if err != nil {
+            return 0, nil, err
+        }
+
+        current = *record
+    }
+
+    record := current
+    if valid&validUid != 0 {
+        record.Uid = update.Uid
+    }
+    if valid&validGid != 0 {
+        record.Gid = update.Gid
+    }
+    if valid&validMode != 0 {
+        record.Mode = update.Mode
+    }
+    record.Salt = update.Salt
+
+    recordJSON, err := json.Marshal(record)
+    if err != nil {
+        return 0, nil, err
+    }
+
+    err = ctx.GetStub().PutPrivateData(privateCollection, key, recordJSON)
+    if err != nil {
+        return 0, nil, fmt.Errorf("failed to write private data: %v", err)
+    }
+
+    err = setPrivateEndorsementPolicy(ctx, privateCollection, key, fs)
+    if err != nil {
+        return 0, nil, err
+    }
+
+    hash := sha256.Sum256(recordJSON)
+    private := &PrivateOwnership{Collection: privateCollection, Hash: hex.EncodeToString(hash[:])}
+
+    var fields []string
+    if asset.Private == nil || *asset.Private != *private {
+        fields = append(fields, "ownership")
+    }
+
+    asset.Uid = 0
+    asset.Gid = 0
+    asset.Mode = 0
+    asset.Private = private
+
+    return valid &^ validOwnership, fields, nil
+}
+
+// getOwnership reads the private record of an asset and checks it against
+// the hash recorded in public state, which covers the salt.
+func getOwnership(ctx contractapi.TransactionContextInterface, key string, private *PrivateOwnership) (*Ownership, error) {
+    recordJSON, err := ctx.GetStub().GetPrivateData(private.Collection, key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read private data: %v", err)
+    }
+
+    if recordJSON == nil {
+        return nil, fmt.Errorf("the private ownership record is not available on this peer")
+    }
+
+    hash := sha256.Sum256(recordJSON)
+    if hex.EncodeToString(hash[:]) != private.Hash {
+        return nil, fmt.Errorf("the private ownership record does not match its public hash")
+    }
+
+    var record Ownership
+    err = json.Unmarshal(recordJSON, &record)
+    if err != nil {
+        return nil, err
+    }
+
+    return &record, nil
+}
+
+// ReadAssetOwnership returns the uid, gid and mode of an asset, reading
+// them from the private data collection if they are kept private. It must
+// be evaluated on a peer of an organization that is a member of the
+// collection.
+func (s *SmartContract) ReadAssetOwnership(ctx contractapi.TransactionContextInterface, fsUUID string, ino uint64, generation uint32) (*Ownership, error) {
+    asset, err := s.ReadAsset(ctx, fsUUID, ino, generation)
+    if err != nil {
+        return nil, err
+    }
+
+    if asset.Private == nil {
+        return &Ownership{FsUUID: fsUUID, Ino: ino, Generation: generation, Uid: asset.Uid, Gid: asset.Gid, Mode: asset.Mode}, nil
+    }
+
+    key, err := assetKey(ctx, fsUUID, ino, generation)
+    if err != nil {
+        return nil, err
+    }
+
+    return getOwnership(ctx, key, asset.Private)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "strings"
+    "testing"
+)
+
+var testSalt = bytes.Repeat([]byte{0x5a}, minOwnershipSaltSize)
+
+// setTransientOwnership passes records in the transient map of the next
+// transaction, as the daemon does in private mode.
+func setTransientOwnership(t *testing.T, stub *mockStub, records ...Ownership) {
+    t.Helper()
+    recordsJSON, err := json.Marshal(records)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stub.transient = map[string][]byte{ownershipTransientKey: recordsJSON}
+}
+
+// createPrivateAsset creates asset 1 with its ownership kept private.
+func createPrivateAsset(t *testing.T, stub *mockStub) {
+    t.Helper()
+    setTransientOwnership(t, stub, Ownership{FsUUID: testUUID, Ino: 1, Generation: 1, Uid: 1000, Gid: 100, Mode: 0o100600, Salt: testSalt})
+    createAssets(t, stub, alice, testAsset(1))
+    stub.transient = nil
+}
+
+func readOwnership(t *testing.T, stub *mockStub) *Ownership {
+    t.Helper()
+    record, err := (&SmartContract{}).ReadAssetOwnership(newContext(stub, alice), testUUID, 1, 1)
+    if err != nil {
+        t.Fatalf("ReadAssetOwnership: %v", err)
+    }
+
+    return record
+}
+
+func TestPrivateOwnership(t *testing.T) {
+    stub := newMockStub()
+    createPrivateAsset(t, stub)
+
+    key, err := assetKey(newContext(stub, alice), testUUID, 1, 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var public map[string]any
+    err = json.Unmarshal(stub.state[key], &public)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for _, field := range []string{"uid", "gid", "mode"} {
+        if public[field] != 0.0 {
+            t.Errorf("public %s = %v, want 0", field, public[field])
+        }
+    }
+
+    asset := readAsset(t, stub, 1)
+    if asset.Private == nil || asset.Private.Collection != privateCollection || len(asset.Private.Hash) != 64 {
+        t.Fatalf("private = %+v", asset.Private)
+    }
+
+    want := Ownership{FsUUID: testUUID, Ino: 1, Generation: 1, Uid: 1000, Gid: 100, Mode: 0o100600, Salt: testSalt}
+    if record := readOwnership(t, stub); record.Uid != want.Uid || record.Gid != want.Gid || record.Mode != want.Mode || !bytes.Equal(record.Salt, want.Salt) {
+        t.Errorf("ownership = %+v, want %+v", record, want)
+    }
+
+    // Private ownership is in no index.
+    got := inos(t, func(bookmark string) (*AssetPage, error) {
+        return (&SmartContract{}).ListAssetsByUid(newContext(stub, alice), testUUID, 0, 10, bookmark)
+    })
+    if len(got) != 0 {
+        t.Errorf("uid 0 lists %v", got)
+    }
+}
+
+func TestPrivateOwnershipUpdates(t *testing.T) {
+    stub := newMockStub()
+    createPrivateAsset(t, stub)
+    s := &SmartContract{}
+    hash := readAsset(t, stub, 1).Private.Hash
+
+    // A partial update is merged into the private record.
+    salt := bytes.Repeat([]byte{0xa5}, minOwnershipSaltSize)
+    setTransientOwnership(t, stub, Ownership{FsUUID: testUUID, Ino: 1, Generation: 1, Mode: 0o100640, Salt: salt})
+    updates := []AssetUpdate{{Asset: Asset{FsUUID: testUUID, Ino: 1, Generation: 1}, Valid: validMode}}
+    _, err := s.BatchUpdateAssets(newContext(stub, alice), updates)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stub.commit()
+    stub.transient = nil
+
+    if record := readOwnership(t, stub); record.Uid != 1000 || record.Gid != 100 || record.Mode != 0o100640 {
+        t.Errorf("ownership = %+v, want uid 1000, gid 100 and mode 100640", record)
+    }
+
+    if asset := readAsset(t, stub, 1); asset.Mode != 0 || asset.Private.Hash == hash {
+        t.Errorf("public record after the update: mode %o, private %+v", asset.Mode, asset.Private)
+    }
+
+    // Without a transient record the update would be public.
+    _, err = s.BatchUpdateAssets(newContext(stub, alice), uidUpdate(1, 0))
+    if err == nil || !strings.Contains(err.Error(), "is private") {
+        t.Errorf("public update of private ownership: %v", err)
+    }
+}
+
+func TestPrivateOwnershipRejectsShortSalt(t *testing.T) {
+    stub := newMockStub()
+    setTransientOwnership(t, stub, Ownership{FsUUID: testUUID, Ino: 1, Generation: 1, Uid: 1000, Salt: testSalt[1:]})
+
+    _, err := (&SmartContract{}).BatchCreateAssets(newContext(stub, alice), []Asset{testAsset(1)})
+    if err == nil || !strings.Contains(err.Error(), "salt") {
+        t.Errorf("BatchCreateAssets with a %d byte salt: %v", len(testSalt)-1, err)
+    }
+}
+
+func TestPrivateOwnershipChecksHash(t *testing.T) {
+    stub := newMockStub()
+    createPrivateAsset(t, stub)
+
+    key, err := assetKey(newContext(stub, alice), testUUID, 1, 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stored := stub.private[privateKey(privateCollection, key)]
+
+    var record Ownership
+    err = json.Unmarshal(stored, &record)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // The hash covers the values and the salt.
+    uid := record
+    uid.Uid = 0
+    salt := record
+    salt.Salt = bytes.Repeat([]byte{0x00}, minOwnershipSaltSize)
+    for name, tampered := range map[string]Ownership{"uid": uid, "salt": salt} {
+        tamperedJSON, err := json.Marshal(tampered)
+        if err != nil {
+            t.Fatal(err)
+        }
+        stub.private[privateKey(privateCollection, key)] = tamperedJSON
+
+        _, err = (&SmartContract{}).ReadAssetOwnership(newContext(stub, alice), testUUID, 1, 1)
+        if err == nil || !strings.Contains(err.Error(), "does not match") {
+            t.Errorf("ReadAssetOwnership with a changed %s: %v", name, err)
+        }
+    }
+
+    delete(stub.private, privateKey(privateCollection, key))
+    _, err = (&SmartContract{}).ReadAssetOwnership(newContext(stub, alice), testUUID, 1, 1)
+    if err == nil || !strings.Contains(err.Error(), "not available") {
+        t.Errorf("ReadAssetOwnership without the private record: %v", err)
+    }
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+    "fmt"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// AssetPage is one page of a paginated query. Pass Bookmark to the next
+// call to continue; a page with FetchedCount below the requested page size
+// is the last one.
+type AssetPage struct {
+    Assets       []Asset `json:"assets"`
+    FetchedCount int32   `json:"fetchedCount"`
+    Bookmark     string  `json:"bookmark"`
+}
+
+// ListAssets returns the assets of a filesystem in key order, tombstones
+// included.
+func (s *SmartContract) ListAssets(ctx contractapi.TransactionContextInterface, fsUUID string, pageSize int32, bookmark string) (*AssetPage, error) {
+    if fsUUID == "" {
+        return nil, fmt.Errorf("missing filesystem UUID")
+    }
+
+    resultsIterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(assetObjectType, []string{fsUUID}, pageSize, bookmark)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list assets: %v", err)
+    }
+    defer resultsIterator.Close()
+
+    assets, err := collectAssets(resultsIterator)
+    if err != nil {
+        return nil, err
+    }
+
+    return &AssetPage{
+        Assets:       assets,
+        FetchedCount: metadata.GetFetchedRecordsCount(),
+        Bookmark:     metadata.GetBookmark(),
+    }, nil
+}
+
+// ListAssetGenerations returns every generation recorded for an inode
+// number, so the records of a reused inode remain reachable after it has
+// been deleted.
+func (s *SmartContract) ListAssetGenerations(ctx contractapi.TransactionContextInterface, fsUUID string, ino uint64) ([]Asset, error) {
+    if fsUUID == "" {
+        return nil, fmt.Errorf("missing filesystem UUID")
+    }
+
+    if ino == 0 {
+        return nil, fmt.Errorf("missing inode number")
+    }
+
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(assetObjectType, []string{fsUUID, formatUint(ino)})
+    if err != nil {
+        return nil, fmt.Errorf("failed to list generations: %v", err)
+    }
+    defer resultsIterator.Close()
+
+    return collectAssets(resultsIterator)
+}
+
+// ListImmutableAssets returns the live assets of a filesystem that carry
+// the immutable flag (chattr +i).
+func (s *SmartContract) ListImmutableAssets(ctx contractapi.TransactionContextInterface, fsUUID string) ([]Asset, error) {
+    if fsUUID == "" {
+        return nil, fmt.Errorf("missing filesystem UUID")
+    }
+
+    return indexedAssets(ctx, immutableIndex, []string{fsUUID})
+}
+
+func collectAssets(resultsIterator shim.StateQueryIteratorInterface) ([]Asset, error) {
+    assets := []Asset{}
+    for resultsIterator.HasNext() {
+        result, err := resultsIterator.Next()
+        if err != nil {
+            return nil, fmt.Errorf("failed to read query result: %v", err)
+        }
+
+        asset, err := decodeAsset(result.GetValue())
+        if err != nil {
+            return nil, err
+        }
+
+        assets = append(assets, *asset)
+    }
+
+    return assets, nil
+}
+
+// ListAssetsByUid returns a page of the live assets of a filesystem owned
+// by uid. Assets whose ownership is kept private are not listed.
+func (s *SmartContract) ListAssetsByUid(ctx contractapi.TransactionContextInterface, fsUUID string, uid uint32, pageSize int32, bookmark string) (*AssetPage, error) {
+    if fsUUID == "" {
+        return nil, fmt.Errorf("missing filesystem UUID")
+    }
+
+    return indexedAssetPage(ctx, uidIndex, [][]string{{fsUUID, formatUint(uint64(uid))}}, nil, pageSize, bookmark)
+}
+
+// ListSetuidAssets returns a page of the live assets of a filesystem with
+// the setuid bit in their mode. Assets whose ownership is kept private are
+// not listed.
+func (s *SmartContract) ListSetuidAssets(ctx contractapi.TransactionContextInterface, fsUUID string, pageSize int32, bookmark string) (*AssetPage, error) {
+    if fsUUID == "" {
+        return nil, fmt.Errorf("missing filesystem UUID")
+    }
+
+    return indexedAssetPage(ctx, setuidIndex, [][]string{{fsUUID}}, nil, pageSize, bookmark)
+}
+
+// ListAssetsModifiedBetween returns a page of the live assets of a
+// filesystem whose mtime is at or after fromSec and before toSec, in
+// seconds since the epoch, ordered by mtime.
+func (s *SmartContract) ListAssetsModifiedBetween(ctx contractapi.TransactionContextInterface, fsUUID string, fromSec, toSec int64, pageSize int32, bookmark string) (*AssetPage, error) {
+    if fsUUID == "" {
+        return nil, fmt.Errorf("missing filesystem UUID")
+    }
+
+    if fromSec >= toSec {
+        return nil, fmt.Errorf("empty time range %d to %d", fromSec, toSec)
+    }
+
+    from := time.Unix(fromSec, 0)
+    to := time.Unix(toSec, 0)
+    prefixes, err := mtimePrefixes(fsUUID, from, to)
+    if err != nil {
+        return nil, err
+    }
+
+    match := func(keyParts []string) (bool, error) {
+        mtime, err := mtimeOf(keyParts)
+        if err != nil {
+            return false, err
+        }
+
+        return !mtime.Before(from) && mtime.Before(to), nil
+    }
+
+    return indexedAssetPage(ctx, mtimeIndex, prefixes, match, pageSize, bookmark)
+}
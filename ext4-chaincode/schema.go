@@ -0,0 +1,125 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "strconv"
+)
+
+// schemaVersion is the layout putAsset stores. Records without a
+// schemaVersion field are version 0, which kept every number as a decimal
+// string and stored an empty string for unset fields. getAsset reads both;
+// MigrateAssets rewrites old records in the current layout. Version 1
+// records written before size, nlink, blocks and crtime were recorded read
+// as zero for those fields. Version 2 has the layout of version 1 and marks
+// records that are in the uid, setuid and mtime indexes of index.go.
+// Version 3 marks records whose mtime index entry has a fixed width year;
+// ListAssetsModifiedBetween misses records of older versions until they
+// are migrated.
+const schemaVersion = 3
+
+// assetV0 is a version 0 record. Those of the first version of the
+// chaincode, under legacy keys, have neither fsUuid nor generation nor
+// deleted; MigrateAssets is told their filesystem. Those under composite
+// keys have all three.
+type assetV0 struct {
+    Uid        string     `json:"uid"`
+    Gid        string     `json:"gid"`
+    Atime      timeV0     `json:"atime"`
+    Mtime      timeV0     `json:"mtime"`
+    Ctime      timeV0     `json:"ctime"`
+    Mode       string     `json:"mode"`
+    Ino        string     `json:"ino"`
+    FsUUID     string     `json:"fsUuid"`
+    Generation string     `json:"generation"`
+    Deleted    *Tombstone `json:"deleted,omitempty"`
+}
+
+type timeV0 struct {
+    Sec  string `json:"sec"`
+    Nsec string `json:"nsec"`
+}
+
+// decodeAsset unmarshals a stored record of any schema version.
+func decodeAsset(data []byte) (*Asset, error) {
+    var version struct {
+        SchemaVersion int `json:"schemaVersion"`
+    }
+    err := json.Unmarshal(data, &version)
+    if err != nil {
+        return nil, fmt.Errorf("failed to unmarshal asset: %v", err)
+    }
+
+    switch version.SchemaVersion {
+    case 0:
+        var old assetV0
+        err = json.Unmarshal(data, &old)
+        if err != nil {
+            return nil, fmt.Errorf("failed to unmarshal asset: %v", err)
+        }
+
+        return old.upgrade()
+    case 1, 2, schemaVersion:
+        var asset Asset
+        err = json.Unmarshal(data, &asset)
+        if err != nil {
+            return nil, fmt.Errorf("failed to unmarshal asset: %v", err)
+        }
+
+        return &asset, nil
+    default:
+        return nil, fmt.Errorf("unsupported asset schema version %d", version.SchemaVersion)
+    }
+}
+
+// upgrade converts a version 0 record. Empty strings become zero.
+func (old *assetV0) upgrade() (*Asset, error) {
+    asset := Asset{
+        SchemaVersion: 0,
+        FsUUID:        old.FsUUID,
+        Deleted:       old.Deleted,
+    }
+
+    var err error
+    parse := func(name, value string, bitSize int) uint64 {
+        if value == "" || err != nil {
+            return 0
+        }
+
+        var n uint64
+        n, err = strconv.ParseUint(value, 10, bitSize)
+        if err != nil {
+            err = fmt.Errorf("invalid %s %q in asset %s/%s: %v", name, value, old.FsUUID, old.Ino, err)
+        }
+        return n
+    }
+    parseTime := func(name string, value timeV0) Time {
+        t := Time{Nsec: uint32(parse(name+" nanoseconds", value.Nsec, 32))}
+        if value.Sec != "" && err == nil {
+            t.Sec, err = strconv.ParseInt(value.Sec, 10, 64)
+            if err != nil {
+                err = fmt.Errorf("invalid %s seconds %q in asset %s/%s: %v", name, value.Sec, old.FsUUID, old.Ino, err)
+            }
+        }
+        return t
+    }
+
+    asset.Uid = uint32(parse("uid", old.Uid, 32))
+    asset.Gid = uint32(parse("gid", old.Gid, 32))
+    asset.Atime = parseTime("atime", old.Atime)
+    asset.Mtime = parseTime("mtime", old.Mtime)
+    asset.Ctime = parseTime("ctime", old.Ctime)
+    asset.Mode = uint32(parse("mode", old.Mode, 32))
+    asset.Ino = parse("inode number", old.Ino, 64)
+    asset.Generation = uint32(parse("generation", old.Generation, 32))
+    if err != nil {
+        return nil, err
+    }
+
+    err = validateAsset(&asset, validAll)
+    if err != nil {
+        return nil, fmt.Errorf("asset %s/%s: %v", old.FsUUID, old.Ino, err)
+    }
+
+    return &asset, nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+    "sort"
+    "strings"
+    "testing"
+    "time"
+    "github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+    "github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+    "github.com/hyperledger/fabric-protos-go/peer"
+    "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const testUUID = "0f0e0d0c-0b0a-0908-0706-050403020100"
+
+// testTime is the timestamp of every transaction.
+var testTime = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+// mockStub is the part of the stub the chaincode uses, backed by maps. As
+// on a peer, reads only see committed state: the writes of a transaction
+// are kept aside until commit. Private data is keyed by collection and key
+// joined with a zero byte.
+type mockStub struct {
+    shim.ChaincodeStubInterface
+    state         map[string][]byte
+    writes        map[string][]byte
+    private       map[string][]byte
+    privateWrites map[string][]byte
+    events        map[string][]byte
+    transient     map[string][]byte
+}
+
+func newMockStub() *mockStub {
+    return &mockStub{
+        state:         make(map[string][]byte),
+        writes:        make(map[string][]byte),
+        private:       make(map[string][]byte),
+        privateWrites: make(map[string][]byte),
+        events:        make(map[string][]byte),
+    }
+}
+
+// commit applies the writes of the transaction to the state. Deleted keys
+// are written as nil.
+func (s *mockStub) commit() {
+    apply := func(state, writes map[string][]byte) {
+        for key, value := range writes {
+            if value == nil {
+                delete(state, key)
+            } else {
+                state[key] = value
+            }
+        }
+    }
+    apply(s.state, s.writes)
+    apply(s.private, s.privateWrites)
+    s.writes = make(map[string][]byte)
+    s.privateWrites = make(map[string][]byte)
+    s.events = make(map[string][]byte)
+}
+
+func (s *mockStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+    return shim.CreateCompositeKey(objectType, attributes)
+}
+
+func (s *mockStub) SplitCompositeKey(key string) (string, []string, error) {
+    parts := strings.Split(strings.TrimPrefix(key, "\x00"), "\x00")
+    return parts[0], parts[1 : len(parts)-1], nil
+}
+
+func (s *mockStub) GetState(key string) ([]byte, error) {
+    return s.state[key], nil
+}
+
+func (s *mockStub) PutState(key string, value []byte) error {
+    s.writes[key] = value
+    return nil
+}
+
+func (s *mockStub) DelState(key string) error {
+    s.writes[key] = nil
+    return nil
+}
+
+func (s *mockStub) SetStateValidationParameter(key string, policy []byte) error {
+    return nil
+}
+
+func privateKey(collection, key string) string {
+    return collection + "\x00" + key
+}
+
+func (s *mockStub) GetPrivateData(collection, key string) ([]byte, error) {
+    return s.private[privateKey(collection, key)], nil
+}
+
+func (s *mockStub) PutPrivateData(collection, key string, value []byte) error {
+    s.privateWrites[privateKey(collection, key)] = value
+    return nil
+}
+
+func (s *mockStub) SetPrivateDataValidationParameter(collection, key string, policy []byte) error {
+    return nil
+}
+
+func (s *mockStub) GetTransient() (map[string][]byte, error) {
+    return s.transient, nil
+}
+
+func (s *mockStub) SetEvent(name string, payload []byte) error {
+    s.events[name] = payload
+    return nil
+}
+
+func (s *mockStub) GetTxTimestamp() (*timestamppb.Timestamp, error) {
+    return timestamppb.New(testTime), nil
+}
+
+// keys returns the committed keys for which match holds, in order.
+func (s *mockStub) keys(match func(key string) bool) []string {
+    var keys []string
+    for key := range s.state {
+        if match(key) {
+            keys = append(keys, key)
+        }
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+func (s *mockStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+    prefix, err := shim.CreateCompositeKey(objectType, attributes)
+    if err != nil {
+        return nil, err
+    }
+
+    return s.iterator(s.keys(func(key string) bool { return strings.HasPrefix(key, prefix) })), nil
+}
+
+func (s *mockStub) GetStateByPartialCompositeKeyWithPagination(objectType string, attributes []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+    prefix, err := shim.CreateCompositeKey(objectType, attributes)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    iterator, metadata := s.page(s.keys(func(key string) bool { return strings.HasPrefix(key, prefix) }), pageSize, bookmark)
+    return iterator, metadata, nil
+}
+
+func (s *mockStub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+    keys := s.keys(func(key string) bool {
+        return !strings.HasPrefix(key, "\x00") && key >= startKey && key < endKey
+    })
+    iterator, metadata := s.page(keys, pageSize, bookmark)
+    return iterator, metadata, nil
+}
+
+// page returns up to pageSize of keys, starting at the key bookmark names.
+// The bookmark of the page is the first key left out.
+func (s *mockStub) page(keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata) {
+    start := 0
+    if bookmark != "" {
+        start = sort.SearchStrings(keys, bookmark)
+    }
+
+    end := start + int(pageSize)
+    next := ""
+    if end < len(keys) {
+        next = keys[end]
+    } else {
+        end = len(keys)
+    }
+
+    return s.iterator(keys[start:end]), &peer.QueryResponseMetadata{FetchedRecordsCount: int32(end - start), Bookmark: next}
+}
+
+func (s *mockStub) iterator(keys []string) *mockIterator {
+    results := make([]*queryresult.KV, 0, len(keys))
+    for _, key := range keys {
+        results = append(results, &queryresult.KV{Key: key, Value: s.state[key]})
+    }
+
+    return &mockIterator{results: results}
+}
+
+type mockIterator struct {
+    results []*queryresult.KV
+}
+
+func (it *mockIterator) HasNext() bool {
+    return len(it.results) > 0
+}
+
+func (it *mockIterator) Next() (*queryresult.KV, error) {
+    result := it.results[0]
+    it.results = it.results[1:]
+    return result, nil
+}
+
+func (it *mockIterator) Close() error {
+    return nil
+}
+
+// mockIdentity is a submitter of the given organization whose certificate
+// carries attributes.
+type mockIdentity struct {
+    cid.ClientIdentity
+    id         string
+    mspID      string
+    attributes map[string]string
+}
+
+func (c *mockIdentity) GetID() (string, error) {
+    return c.id, nil
+}
+
+func (c *mockIdentity) GetMSPID() (string, error) {
+    return c.mspID, nil
+}
+
+func (c *mockIdentity) GetAttributeValue(name string) (string, bool, error) {
+    value, ok := c.attributes[name]
+    return value, ok, nil
+}
+
+var (
+    alice = &mockIdentity{id: "alice", mspID: "Org1MSP"}
+    bob   = &mockIdentity{id: "bob", mspID: "Org1MSP"}
+    admin = &mockIdentity{id: "admin", mspID: "Org2MSP", attributes: map[string]string{adminAttribute: "true"}}
+)
+
+func newContext(stub *mockStub, identity *mockIdentity) *contractapi.TransactionContext {
+    ctx := &contractapi.TransactionContext{}
+    ctx.SetStub(stub)
+    ctx.SetClientIdentity(identity)
+    return ctx
+}
+
+func testAsset(ino uint64) Asset {
+    return Asset{
+        FsUUID:     testUUID,
+        Ino:        ino,
+        Generation: 1,
+        Uid:        1000,
+        Gid:        1000,
+        Mode:       0o100644,
+        Nlink:      1,
+        Mtime:      Time{Sec: testTime.Unix()},
+    }
+}
+
+// createAssets creates assets as identity and commits them.
+func createAssets(t *testing.T, stub *mockStub, identity *mockIdentity, assets ...Asset) {
+    t.Helper()
+    existing, err := (&SmartContract{}).BatchCreateAssets(newContext(stub, identity), assets)
+    if err != nil {
+        t.Fatalf("BatchCreateAssets: %v", err)
+    }
+
+    if len(existing) != 0 {
+        t.Fatalf("BatchCreateAssets: %v exist already", existing)
+    }
+    stub.commit()
+}
+
+func readAsset(t *testing.T, stub *mockStub, ino uint64) *Asset {
+    t.Helper()
+    asset, err := getAsset(newContext(stub, alice), testUUID, ino, 1)
+    if err != nil {
+        t.Fatalf("getAsset(%d): %v", ino, err)
+    }
+
+    if asset == nil {
+        t.Fatalf("asset %d does not exist", ino)
+    }
+
+    return asset
+}
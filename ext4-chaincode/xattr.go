@@ -0,0 +1,221 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "time"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Xattr records an extended attribute of an asset. Digest is the hex
+// SHA-256 of the value; Value is the base64 value itself when the kernel
+// sent it, and empty otherwise.
+type Xattr struct {
+    FsUUID     string `json:"fsUuid"`
+    Ino        uint64 `json:"ino"`
+    Generation uint32 `json:"generation"`
+    Name       string `json:"name"`
+    Digest     string `json:"digest"`
+    Value      string `json:"value"`
+    // Removed is set once the attribute has been removed.
+    Removed *Tombstone `json:"removed,omitempty" metadata:",optional"`
+}
+
+type XattrHistoryEntry struct {
+    TxID      string `json:"txId"`
+    Timestamp string `json:"timestamp"`
+    Xattr     Xattr  `json:"xattr"`
+}
+
+// Extended attributes are stored under composite keys (fsUUID, ino,
+// generation, name), next to but separate from the asset itself.
+const xattrObjectType = "xattr"
+
+func xattrKey(ctx contractapi.TransactionContextInterface, fsUUID string, ino uint64, generation uint32, name string) (string, error) {
+    if name == "" {
+        return "", fmt.Errorf("missing xattr name")
+    }
+
+    _, err := assetKey(ctx, fsUUID, ino, generation)
+    if err != nil {
+        return "", err
+    }
+
+    return ctx.GetStub().CreateCompositeKey(xattrObjectType, []string{fsUUID, formatUint(ino), formatUint(uint64(generation)), name})
+}
+
+// SetXattr records the new value of an extended attribute of a live asset.
+// digest is the hex SHA-256 of the value; value is either empty or the
+// base64 encoded value, which must then match digest.
+func (s *SmartContract) SetXattr(ctx contractapi.TransactionContextInterface, fsUUID string, ino uint64, generation uint32, name, digest, value string) error {
+    sum, err := hex.DecodeString(digest)
+    if err != nil || len(sum) != sha256.Size {
+        return fmt.Errorf("invalid xattr digest %q", digest)
+    }
+
+    if value != "" {
+        data, err := base64.StdEncoding.DecodeString(value)
+        if err != nil {
+            return fmt.Errorf("invalid xattr value: %v", err)
+        }
+
+        valueSum := sha256.Sum256(data)
+        if hex.EncodeToString(valueSum[:]) != digest {
+            return fmt.Errorf("digest of xattr %s does not match its value", name)
+        }
+    }
+
+    return putXattr(ctx, &Xattr{
+        FsUUID:     fsUUID,
+        Ino:        ino,
+        Generation: generation,
+        Name:       name,
+        Digest:     digest,
+        Value:      value,
+    })
+}
+
+// RemoveXattr records the removal of an extended attribute of a live asset
+// as a tombstone. The removal is recorded even if the attribute was never
+// set through SetXattr, as it may predate the tracking.
+func (s *SmartContract) RemoveXattr(ctx contractapi.TransactionContextInterface, fsUUID string, ino uint64, generation uint32, name string) error {
+    submitter, err := getSubmitter(ctx)
+    if err != nil {
+        return err
+    }
+
+    timestamp, err := ctx.GetStub().GetTxTimestamp()
+    if err != nil {
+        return fmt.Errorf("failed to get transaction timestamp: %v", err)
+    }
+
+    return putXattr(ctx, &Xattr{
+        FsUUID:     fsUUID,
+        Ino:        ino,
+        Generation: generation,
+        Name:       name,
+        Removed: &Tombstone{
+            Time:      timestamp.AsTime().Format(time.RFC3339Nano),
+            Submitter: submitter,
+        },
+    })
+}
+
+// putXattr stores x under the endorsement policy of its filesystem,
+// provided its asset is live and the submitter may change it, and emits an
+// update event naming the attribute.
+func putXattr(ctx contractapi.TransactionContextInterface, x *Xattr) error {
+    key, err := xattrKey(ctx, x.FsUUID, x.Ino, x.Generation, x.Name)
+    if err != nil {
+        return err
+    }
+
+    _, err = getMutableAsset(ctx, x.FsUUID, x.Ino, x.Generation)
+    if err != nil {
+        return err
+    }
+
+    xattrJSON, err := json.Marshal(x)
+    if err != nil {
+        return err
+    }
+
+    err = ctx.GetStub().PutState(key, xattrJSON)
+    if err != nil {
+        return err
+    }
+
+    fs, err := getFilesystem(ctx, x.FsUUID)
+    if err != nil {
+        return err
+    }
+
+    err = setEndorsementPolicy(ctx, key, fs)
+    if err != nil {
+        return err
+    }
+
+    return emitAssetEvent(ctx, eventUpdate, []AssetChange{{
+        FsUUID:     x.FsUUID,
+        Ino:        x.Ino,
+        Generation: x.Generation,
+        Fields:     []string{"xattr." + x.Name},
+    }})
+}
+
+// ListXattrs returns the recorded extended attributes of an asset, removed
+// ones included.
+func (s *SmartContract) ListXattrs(ctx contractapi.TransactionContextInterface, fsUUID string, ino uint64, generation uint32) ([]Xattr, error) {
+    _, err := assetKey(ctx, fsUUID, ino, generation)
+    if err != nil {
+        return nil, err
+    }
+
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(xattrObjectType, []string{fsUUID, formatUint(ino), formatUint(uint64(generation))})
+    if err != nil {
+        return nil, fmt.Errorf("failed to list xattrs: %v", err)
+    }
+    defer resultsIterator.Close()
+
+    xattrs := []Xattr{}
+    for resultsIterator.HasNext() {
+        result, err := resultsIterator.Next()
+        if err != nil {
+            return nil, fmt.Errorf("failed to read query result: %v", err)
+        }
+
+        var x Xattr
+        err = json.Unmarshal(result.GetValue(), &x)
+        if err != nil {
+            return nil, fmt.Errorf("failed to unmarshal xattr: %v", err)
+        }
+
+        xattrs = append(xattrs, x)
+    }
+
+    return xattrs, nil
+}
+
+// GetXattrHistory returns every recorded version of an extended attribute,
+// newest first.
+func (s *SmartContract) GetXattrHistory(ctx contractapi.TransactionContextInterface, fsUUID string, ino uint64, generation uint32, name string) ([]XattrHistoryEntry, error) {
+    key, err := xattrKey(ctx, fsUUID, ino, generation, name)
+    if err != nil {
+        return nil, err
+    }
+
+    resultsIterator, err := ctx.GetStub().GetHistoryForKey(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read xattr history: %v", err)
+    }
+    defer resultsIterator.Close()
+
+    history := []XattrHistoryEntry{}
+    for resultsIterator.HasNext() {
+        modification, err := resultsIterator.Next()
+        if err != nil {
+            return nil, fmt.Errorf("failed to read xattr history: %v", err)
+        }
+
+        entry := XattrHistoryEntry{
+            TxID:      modification.GetTxId(),
+            Timestamp: modification.GetTimestamp().AsTime().Format(time.RFC3339Nano),
+        }
+
+        err = json.Unmarshal(modification.GetValue(), &entry.Xattr)
+        if err != nil {
+            return nil, fmt.Errorf("failed to unmarshal xattr: %v", err)
+        }
+
+        history = append(history, entry)
+    }
+
+    if len(history) == 0 {
+        return nil, fmt.Errorf("xattr %s of asset %s/%d@%d does not exist", name, fsUUID, ino, generation)
+    }
+
+    return history, nil
+}